@@ -1,9 +1,12 @@
 package main
 
 import (
+	"log"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/timebetov/readerblog/database"
 	"github.com/timebetov/readerblog/internals/routes"
+	"github.com/timebetov/readerblog/internals/utils"
 )
 
 // Entrypoint of the application
@@ -11,8 +14,22 @@ func main() {
 	// Connecting to DB
 	database.ConnectDB()
 
-	// Initializng fiber app
-	app := fiber.New()
+	// Checking the password hashing parameters before the first login needs them
+	if err := utils.InitPasswordHasher(); err != nil {
+		log.Fatalf("Invalid password hashing configuration: %v", err)
+	}
+
+	// Loading the JWT signing keys
+	if err := utils.InitKeyring(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	// Initializng fiber app, request bodies are streamed for the imports and
+	// limited to the default body limit everywhere else, see routes.SetupRoutes
+	app := fiber.New(fiber.Config{
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
 
 	// Setting up API routes
 	routes.SetupRoutes(app)
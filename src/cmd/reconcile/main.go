@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+
+	"github.com/timebetov/readerblog/database"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Recomputes the counters derived from other tables, e.g. after a manual change in the database
+func main() {
+	// Connecting to DB
+	database.ConnectDB()
+
+	userRepo := repositories.NewUserRepository(database.DB)
+	followService := services.NewFollowService(repositories.NewFollowRepository(database.DB), userRepo)
+
+	corrected, err := followService.ReconcileCounters()
+	if err != nil {
+		log.Fatalf("Failed to reconcile follow counters: %v", err)
+	}
+	log.Printf("Follow counters reconciled, %d users corrected", corrected)
+
+	postService := services.NewPostService(repositories.NewPostRepository(database.DB), userRepo, nil, nil, nil)
+	corrected, err = postService.ReconcileCounters()
+	if err != nil {
+		log.Fatalf("Failed to reconcile published post counters: %v", err)
+	}
+	log.Printf("Published post counters reconciled, %d users corrected", corrected)
+
+	reactionService := services.NewReactionService(repositories.NewReactionRepository(database.DB), postService, nil)
+	corrected, err = reactionService.ReconcileCounters()
+	if err != nil {
+		log.Fatalf("Failed to reconcile reaction counts: %v", err)
+	}
+	log.Printf("Reaction counts reconciled, %d posts and comments corrected", corrected)
+
+	tagService := services.NewTagService(repositories.NewTagRepository(database.DB))
+	corrected, err = tagService.ReconcileCounters()
+	if err != nil {
+		log.Fatalf("Failed to reconcile tag counts: %v", err)
+	}
+	log.Printf("Tag counts reconciled, %d tags corrected", corrected)
+}
@@ -43,9 +43,32 @@ func ConnectDB() {
 
 	fmt.Println("Connection Opened to Database")
 
+	// The rows of the users deleted before their tables had a foreign key would block adding it
+	if err = deleteOrphanedUserRows(DB); err != nil {
+		log.Fatalf("Failed to delete the rows of deleted users: %v", err)
+	}
+
 	// Migrate the schema
-	if err = DB.AutoMigrate(&models.User{}); err != nil {
+	if err = DB.AutoMigrate(&models.Permission{}, &models.Role{}, &models.User{}, &models.Session{}, &models.RecoveryCode{}, &models.PasswordReset{}, &models.LoginAttempt{}, &models.Identity{}, &models.APIToken{}, &models.Follow{}, &models.ImportJob{}, &models.Tag{}, &models.Category{}, &models.Post{}, &models.Comment{}, &models.PostReaction{}, &models.CommentReaction{}); err != nil {
 		log.Fatalf("Failed to migrate schema: %v", err)
 	}
 	fmt.Println("Schema was successfully migrated to database!")
+
+	// Creating the default roles and permissions
+	if err = SeedRBAC(DB); err != nil {
+		log.Fatalf("Failed to seed roles and permissions: %v", err)
+	}
+}
+
+// deleteOrphanedUserRows removes the rows which still point to a user that no longer exists
+func deleteOrphanedUserRows(db *gorm.DB) error {
+	for _, model := range []interface{}{&models.Session{}, &models.RecoveryCode{}, &models.PasswordReset{}, &models.Identity{}, &models.APIToken{}} {
+		if !db.Migrator().HasTable(model) || !db.Migrator().HasTable(&models.User{}) {
+			continue
+		}
+		if err := db.Where("NOT EXISTS (SELECT 1 FROM users WHERE users.id = user_id)").Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+// permissionSeed describes a permission checked by the routes and the default roles
+// it is granted to when it is created. Existing grants are never touched, so roles
+// edited through the API keep their permissions across restarts.
+type permissionSeed struct {
+	Name        string
+	Description string
+	Roles       []string
+}
+
+// Names of the roles created on the first start, ADMIN_ROLE and WRITER_ROLE keep their previous meaning
+func adminRoleName() string  { return envOr("ADMIN_ROLE", "admin") }
+func writerRoleName() string { return envOr("WRITER_ROLE", "writer") }
+
+func permissionSeeds() []permissionSeed {
+	admin := []string{adminRoleName()}
+	writers := []string{adminRoleName(), writerRoleName()}
+	return []permissionSeed{
+		{"users.read", "List and view users", admin},
+		{"users.create", "Create users", admin},
+		{"users.update", "Update users", admin},
+		{"users.delete", "Delete users", admin},
+		{"users.restore", "Restore soft deleted users", admin},
+		{"users.unlock", "Lift login lockouts", admin},
+		{"users.import", "Import users from CSV or NDJSON files", admin},
+		{"users.export", "Export users as CSV or NDJSON", admin},
+		{"login-attempts.read", "Read the login log", admin},
+		{"roles.read", "List roles and permissions", admin},
+		{"roles.manage", "Create, update and delete roles", admin},
+		{"roles.assign", "Assign roles to users", admin},
+		{"posts.create", "Write posts", writers},
+		{"posts.manage", "Edit, delete and restore the posts of anybody", admin},
+		{"comments.moderate", "Delete the comments of anybody", admin},
+		{"tags.manage", "Rename and merge the tags", admin},
+		{"categories.manage", "Create, edit and delete the categories", admin},
+	}
+}
+
+// SeedRBAC creates the default roles and the permissions missing from the database,
+// then gives users without roles the one stored in the legacy users.role column
+func SeedRBAC(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		admin := models.Role{Name: adminRoleName()}
+		if err := tx.Where(&admin).Attrs(models.Role{
+			ID:          uuid.New(),
+			Description: "Manages users and roles",
+			RequireMFA:  os.Getenv("REQUIRE_ADMIN_2FA") == "true",
+		}).FirstOrCreate(&admin).Error; err != nil {
+			return err
+		}
+		// REQUIRE_ADMIN_2FA can still turn the requirement on for an existing admin role
+		if os.Getenv("REQUIRE_ADMIN_2FA") == "true" && !admin.RequireMFA {
+			if err := tx.Model(&admin).Update("require_mfa", true).Error; err != nil {
+				return err
+			}
+		}
+
+		writer := models.Role{Name: writerRoleName()}
+		if err := tx.Where(&writer).Attrs(models.Role{
+			ID:          uuid.New(),
+			Description: "Default role of new users",
+			IsDefault:   true,
+		}).FirstOrCreate(&writer).Error; err != nil {
+			return err
+		}
+
+		for _, seed := range permissionSeeds() {
+			var count int64
+			if err := tx.Model(&models.Permission{}).Where("name = ?", seed.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+
+			permission := models.Permission{ID: uuid.New(), Name: seed.Name, Description: seed.Description}
+			if err := tx.Create(&permission).Error; err != nil {
+				return err
+			}
+
+			var roles []models.Role
+			if err := tx.Where("name IN ?", seed.Roles).Find(&roles).Error; err != nil {
+				return err
+			}
+			for i := range roles {
+				if err := tx.Model(&roles[i]).Omit("Permissions.*").Association("Permissions").Append(&permission); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !tx.Migrator().HasColumn("users", "role") {
+			return nil
+		}
+		return tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT users.id, roles.id FROM users JOIN roles ON roles.name = users.role
+			WHERE NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)`).Error
+	})
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
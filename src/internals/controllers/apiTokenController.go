@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type APITokenController struct {
+	Service *services.APITokenService
+}
+
+func NewAPITokenController(service *services.APITokenService) *APITokenController {
+	return &APITokenController{Service: service}
+}
+
+// Creating a personal access token for the authenticated user
+func (ac *APITokenController) CreateToken(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	var tokenDTO dtos.CreateAPITokenDTO
+
+	if err := c.BodyParser(&tokenDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	token, err := ac.Service.CreateToken(claims.Subject, claims.MFA, &tokenDTO)
+	if err != nil {
+		return apiTokenError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Token created, copy it now as it will not be shown again",
+		"data":    token})
+}
+
+// Listing the personal access tokens of the authenticated user
+func (ac *APITokenController) GetTokens(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	tokens, err := ac.Service.ListTokens(claims.Subject)
+	if err != nil {
+		return apiTokenError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Tokens found!",
+		"data":    fiber.Map{"tokens": tokens, "available_scopes": services.APITokenScopes}})
+}
+
+func (ac *APITokenController) GetToken(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	token, err := ac.Service.GetToken(claims.Subject, c.Params("tokenId"))
+	if err != nil {
+		return apiTokenError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Token found!",
+		"data":    token})
+}
+
+// Renaming a token or changing its scopes
+func (ac *APITokenController) UpdateToken(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	var tokenDTO dtos.UpdateAPITokenDTO
+
+	if err := c.BodyParser(&tokenDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	token, err := ac.Service.UpdateToken(claims.Subject, c.Params("tokenId"), &tokenDTO)
+	if err != nil {
+		return apiTokenError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Token updated successfully",
+		"data":    token})
+}
+
+func (ac *APITokenController) RevokeToken(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	if err := ac.Service.RevokeToken(claims.Subject, c.Params("tokenId")); err != nil {
+		return apiTokenError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Token was revoked successfully"})
+}
+
+// apiTokenError maps the errors of the personal access token service to responses
+func apiTokenError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to access this resource"})
+	case "token not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No token found with ID"})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Token operation failed",
+		"error":   err.Error()})
+}
@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"errors"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -27,8 +30,14 @@ func (ac *AuthController) RegisterUser(c *fiber.Ctx) error {
 			"data":    err.Error()})
 	}
 
-	user, token, err := ac.Service.RegisterUser(&userDTO)
+	user, tokens, err := ac.Service.RegisterUser(&userDTO, clientInfo(c))
 	if err != nil {
+		if reasons := passwordPolicyViolations(err); reasons != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Password does not meet the password policy",
+				"reasons": reasons})
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Registration failed!",
@@ -36,11 +45,22 @@ func (ac *AuthController) RegisterUser(c *fiber.Ctx) error {
 			"error":   err.Error()})
 	}
 
+	// Without tokens the user has to verify the email before logging in
+	if tokens == nil {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"status":  "success",
+			"message": "User registered successfully! Check your inbox to verify your email before logging in",
+			"data":    user,
+		})
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"status":  "success",
-		"message": "User registered successfully!",
-		"data":    user,
-		"token":   token,
+		"status":        "success",
+		"message":       "User registered successfully!",
+		"data":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -54,8 +74,30 @@ func (ac *AuthController) Login(c *fiber.Ctx) error {
 			"data":    err.Error()})
 	}
 
-	token, err := ac.Service.Authenticate(&userDTO)
+	tokens, challenge, err := ac.Service.Authenticate(&userDTO, clientInfo(c))
 	if err != nil {
+		var locked *services.LoginLockedError
+		if errors.As(err, &locked) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Too many failed login attempts, try again later",
+				"error":   err.Error()})
+		}
+		var pending *services.AccountPendingDeletionError
+		if errors.As(err, &pending) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"status":   "error",
+				"message":  "This account was deleted, log in with \"restore\": true to restore it",
+				"purge_at": pending.PurgeAt,
+				"error":    err.Error()})
+		}
+		if err.Error() == "email not verified" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Verify your email address before logging in",
+				"error":   err.Error()})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Authentication failed!",
@@ -63,9 +105,89 @@ func (ac *AuthController) Login(c *fiber.Ctx) error {
 			"error":   err.Error()})
 	}
 
+	// The password was right but a second factor is needed
+	if challenge != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":              "success",
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge.ChallengeToken,
+			"expires_in":          challenge.ExpiresIn,
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status": "success",
-		"token":  token,
+		"status":        "success",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// Second step of the login for users with 2FA enabled
+func (ac *AuthController) LoginTwoFactor(c *fiber.Ctx) error {
+	var loginDTO dtos.TwoFactorLoginDTO
+
+	if err := c.BodyParser(&loginDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	tokens, err := ac.Service.CompleteTwoFactorLogin(&loginDTO, clientInfo(c))
+	if err != nil {
+		var locked *services.LoginLockedError
+		if errors.As(err, &locked) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Too many failed login attempts, try again later",
+				"error":   err.Error()})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication failed!",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":        "success",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (ac *AuthController) RefreshToken(c *fiber.Ctx) error {
+	var refreshDTO dtos.RefreshTokenDTO
+
+	if err := c.BodyParser(&refreshDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	tokens, err := ac.Service.RefreshTokens(&refreshDTO)
+	if err != nil {
+		if err.Error() == "invalid refresh token" || err.Error() == "refresh token reuse detected" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid or expired refresh token",
+				"error":   err.Error()})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Token refresh failed!",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":        "success",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -95,8 +217,7 @@ func (ac *AuthController) Logout(c *fiber.Ctx) error {
 
 func (ac *AuthController) Profile(c *fiber.Ctx) error {
 	claims := c.Locals("claims").(*utils.Claims)
-	username := claims.Username
-	user, err := ac.Service.GetUserProfile(username)
+	user, err := ac.Service.GetUserProfile(claims.Subject)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
@@ -108,3 +229,107 @@ func (ac *AuthController) Profile(c *fiber.Ctx) error {
 		"data":   user,
 	})
 }
+
+// Users changing their own account, email and password changes need the current password
+func (ac *AuthController) UpdateProfile(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var profileDTO dtos.UpdateProfileDTO
+	if err := c.BodyParser(&profileDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	user, err := ac.Service.UpdateProfile(claims.Subject, claims.SessionID, &profileDTO)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Profile was updated successfully!",
+		"data":    user})
+}
+
+// Users deleting their own account, it can be restored by logging in during the grace period
+func (ac *AuthController) DeleteProfile(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var deleteDTO dtos.DeleteProfileDTO
+	if err := c.BodyParser(&deleteDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	purgeAt, err := ac.Service.DeleteProfile(claims.Subject, &deleteDTO)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":   "success",
+		"message":  "Account was deleted",
+		"purge_at": purgeAt})
+}
+
+// JWKS publishes the public signing keys so other services can verify tokens
+func (ac *AuthController) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(utils.PublicKeySet())
+}
+
+// clientInfo collects the details of the client a session is created for
+func clientInfo(c *fiber.Ctx) *dtos.ClientInfoDTO {
+	userAgent := c.Get(fiber.HeaderUserAgent)
+
+	// Clients can name themselves, otherwise the device is guessed from the user agent
+	device := strings.TrimSpace(c.Get("X-Device-Name"))
+	if device == "" {
+		device = utils.DeviceFromUserAgent(userAgent)
+	}
+
+	return &dtos.ClientInfoDTO{
+		IP:        c.IP(),
+		UserAgent: userAgent,
+		Device:    device,
+	}
+}
+
+// profileError maps the errors of the profile routes to responses
+func profileError(c *fiber.Ctx, err error) error {
+	if reasons := passwordPolicyViolations(err); reasons != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Password does not meet the password policy",
+			"reasons": reasons})
+	}
+
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to access this resource"})
+	case "current password required", "invalid current password":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The current password is required to change the email or the password",
+			"error":   err.Error()})
+	case "email already in use":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This email address is already in use",
+			"error":   err.Error()})
+	case "passwords do not match":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Passwords do not match"})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Review your input",
+		"error":   err.Error()})
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type AvatarController struct {
+	Service *services.AvatarService
+}
+
+func NewAvatarController(service *services.AvatarService) *AvatarController {
+	return &AvatarController{Service: service}
+}
+
+// Uploading an avatar as the "avatar" field of a multipart form
+func (ac *AvatarController) UploadAvatar(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Send the image as the 'avatar' field of a multipart form",
+			"error":   err.Error()})
+	}
+	if fileHeader.Size > int64(services.AvatarMaxSize()) {
+		return avatarError(c, errors.New("image too large"))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return avatarError(c, err)
+	}
+	defer file.Close()
+
+	// Never reading more than allowed, whatever the size announced
+	data, err := io.ReadAll(io.LimitReader(file, int64(services.AvatarMaxSize())+1))
+	if err != nil {
+		return avatarError(c, err)
+	}
+
+	profile, err := ac.Service.SetAvatar(claims.Subject, data)
+	if err != nil {
+		return avatarError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Avatar was updated successfully!",
+		"data":    profile})
+}
+
+func (ac *AvatarController) RemoveAvatar(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	profile, err := ac.Service.RemoveAvatar(claims.Subject)
+	if err != nil {
+		return avatarError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Avatar was removed",
+		"data":    profile})
+}
+
+// avatarError maps the errors of the avatar service to responses
+func avatarError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to access this resource"})
+	case "image too large":
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The image is too large",
+			"error":   err.Error()})
+	case "unsupported image type", "invalid image", "image dimensions too large":
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Upload a JPEG, PNG or GIF image",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Couldn't store the avatar",
+		"error":   err.Error()})
+}
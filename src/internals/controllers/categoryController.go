@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type CategoryController struct {
+	Service *services.CategoryService
+}
+
+func NewCategoryController(service *services.CategoryService) *CategoryController {
+	return &CategoryController{Service: service}
+}
+
+func (cc *CategoryController) GetCategories(c *fiber.Ctx) error {
+	categories, err := cc.Service.ListCategories()
+	if err != nil {
+		return categoryError(c, err, "Couldn't list categories")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   categories})
+}
+
+func (cc *CategoryController) CreateCategory(c *fiber.Ctx) error {
+	var categoryDTO dtos.CreateCategoryDTO
+	if err := c.BodyParser(&categoryDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&categoryDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	category, err := cc.Service.CreateCategory(&categoryDTO)
+	if err != nil {
+		return categoryError(c, err, "Couldn't create category")
+	}
+
+	c.Location("/api/categories/" + category.Slug + "/posts")
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Category was created successfully",
+		"data":    category})
+}
+
+func (cc *CategoryController) UpdateCategory(c *fiber.Ctx) error {
+	var categoryDTO dtos.UpdateCategoryDTO
+	if err := c.BodyParser(&categoryDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&categoryDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	category, err := cc.Service.UpdateCategory(c.Params("slug"), &categoryDTO)
+	if err != nil {
+		return categoryError(c, err, "Couldn't update category")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Category was updated successfully",
+		"data":    category})
+}
+
+// Deleting a category, its posts stay without category
+func (cc *CategoryController) DeleteCategory(c *fiber.Ctx) error {
+	category, err := cc.Service.DeleteCategory(c.Params("slug"))
+	if err != nil {
+		return categoryError(c, err, "Couldn't delete category")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Category: " + category.Name + " was deleted successfully"})
+}
+
+// categoryError maps the errors of the category service to responses
+func categoryError(c *fiber.Ctx, err error, message string) error {
+	switch err.Error() {
+	case "category not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No category found with this slug"})
+	case "invalid category name":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	case "category already exists":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Another category has this name"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"error":   err.Error()})
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type CommentController struct {
+	Service *services.CommentService
+}
+
+func NewCommentController(service *services.CommentService) *CommentController {
+	return &CommentController{Service: service}
+}
+
+// Listing the comment threads of a post, anybody who can read the post can read them
+func (cc *CommentController) GetComments(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	var listQuery dtos.CommentListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	comments, meta, err := cc.Service.ListComments(claims, c.Params("postId"), &listQuery)
+	if err != nil {
+		return commentError(c, err, "Couldn't list comments")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   comments,
+		"meta":   meta})
+}
+
+func (cc *CommentController) CreateComment(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var commentDTO dtos.CreateCommentDTO
+	if err := c.BodyParser(&commentDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&commentDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	comment, err := cc.Service.CreateComment(claims, c.Params("postId"), &commentDTO)
+	if err != nil {
+		return commentError(c, err, "Couldn't create comment")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Comment was created successfully",
+		"data":    comment})
+}
+
+func (cc *CommentController) UpdateComment(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var commentDTO dtos.UpdateCommentDTO
+	if err := c.BodyParser(&commentDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&commentDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	comment, err := cc.Service.UpdateComment(claims, c.Params("postId"), c.Params("commentId"), &commentDTO)
+	if err != nil {
+		return commentError(c, err, "Couldn't update comment")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Comment was updated successfully",
+		"data":    comment})
+}
+
+func (cc *CommentController) DeleteComment(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	if err := cc.Service.DeleteComment(claims, c.Params("postId"), c.Params("commentId")); err != nil {
+		return commentError(c, err, "Couldn't delete comment")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Comment was deleted successfully"})
+}
+
+// commentError maps the errors of the comment service to responses, the others are server errors
+func commentError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case err.Error() == "post not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No post found with this ID or slug"})
+	case err.Error() == "comment not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No comment found with this ID on the post"})
+	case err.Error() == "insufficient permissions", err.Error() == "two-factor authentication required":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	case err.Error() == "comments closed":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Comments are closed on this post"})
+	case err.Error() == "edit window closed":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The comment can no longer be edited"})
+	case err.Error() == "thread too deep":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This comment cannot be replied to, the thread is too deep"})
+	case strings.HasPrefix(err.Error(), "invalid "):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"error":   err.Error()})
+}
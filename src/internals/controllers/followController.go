@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type FollowController struct {
+	Service *services.FollowService
+}
+
+func NewFollowController(service *services.FollowService) *FollowController {
+	return &FollowController{Service: service}
+}
+
+func (fc *FollowController) Follow(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	username := c.Params("username")
+
+	if err := fc.Service.Follow(claims.Subject, username); err != nil {
+		return followError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "You are now following " + username})
+}
+
+func (fc *FollowController) Unfollow(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	username := c.Params("username")
+
+	if err := fc.Service.Unfollow(claims.Subject, username); err != nil {
+		return followError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "You are not following " + username + " anymore"})
+}
+
+func (fc *FollowController) GetFollowers(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	followers, meta, err := fc.Service.ListFollowers(claims.Subject, c.Params("username"), c.Query("limit"), c.Query("cursor"))
+	if err != nil {
+		return followError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   followers,
+		"meta":   meta})
+}
+
+func (fc *FollowController) GetFollowing(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	following, meta, err := fc.Service.ListFollowing(claims.Subject, c.Params("username"), c.Query("limit"), c.Query("cursor"))
+	if err != nil {
+		return followError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   following,
+		"meta":   meta})
+}
+
+// followError maps the errors of the follow service to responses
+func followError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No user found with this username"})
+	case "cannot follow yourself", "invalid limit query", "invalid cursor query":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	case "already following":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You already follow this user"})
+	case "follows hidden":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This user hides their follows"})
+	case "not following":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You do not follow this user"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Follow operation failed",
+		"error":   err.Error()})
+}
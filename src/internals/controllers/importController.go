@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type ImportController struct {
+	Service *services.ImportService
+}
+
+func NewImportController(service *services.ImportService) *ImportController {
+	return &ImportController{Service: service}
+}
+
+// Importing users from the CSV or NDJSON file sent as the request body. The body is streamed
+// into a temporary file and the job answered right away, its status tells how the rows went.
+func (ic *ImportController) ImportUsers(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var importQuery dtos.ImportQueryDTO
+	if err := c.QueryParser(&importQuery); err != nil {
+		return importError(c, err)
+	}
+	format, err := services.ParseTransferFormat(importQuery.Format, c.Get(fiber.HeaderContentType))
+	if err != nil {
+		return importError(c, err)
+	}
+
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	file, err := os.CreateTemp("", "user-import-*")
+	if err != nil {
+		return importError(c, err)
+	}
+	// Never writing more than allowed, whatever the size announced
+	written, err := io.Copy(file, io.LimitReader(body, services.ImportMaxSize()+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > services.ImportMaxSize() {
+		err = errors.New("file too large")
+	} else if err == nil && written == 0 {
+		err = errors.New("empty file")
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return importError(c, err)
+	}
+
+	job, err := ic.Service.StartImport(claims, file.Name(), format, importQuery.DryRun)
+	if err != nil {
+		return importError(c, err)
+	}
+
+	c.Location("/api/users/import/" + job.ID.String())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Import was started",
+		"data":    job})
+}
+
+func (ic *ImportController) GetImportJob(c *fiber.Ctx) error {
+	job, err := ic.Service.GetImportJob(c.Params("jobId"))
+	if err != nil {
+		return importError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Import job was found successfully!",
+		"data":    job})
+}
+
+// importError maps the errors of the import service to responses
+func importError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "import job not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No import job found with ID"})
+	case "file too large":
+		// The unread body would be taken for the next request
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The file is too large",
+			"error":   err.Error()})
+	case "empty file":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Send the file as the request body",
+			"error":   err.Error()})
+	case "invalid format query", "invalid dry_run query":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your query parameters, the format is csv or ndjson",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Could not import users",
+		"error":   err.Error()})
+}
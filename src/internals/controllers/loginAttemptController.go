@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+type LoginAttemptController struct {
+	Service     *services.LoginThrottleService
+	UserService *services.UserService
+}
+
+func NewLoginAttemptController(service *services.LoginThrottleService, userService *services.UserService) *LoginAttemptController {
+	return &LoginAttemptController{Service: service, UserService: userService}
+}
+
+// Listing the login log
+func (lc *LoginAttemptController) GetLoginAttempts(c *fiber.Ctx) error {
+	attempts, err := lc.Service.GetLoginAttempts(c.Query("username"), c.Query("ip"), c.Query("success"), c.Query("limit"))
+	if err != nil {
+		if err.Error() == "invalid success query" || err.Error() == "invalid limit query" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid 'success' or 'limit' query parameter",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve login attempts",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Login attempts found!",
+		"data":    attempts})
+}
+
+// Lifting the lockout of a user
+func (lc *LoginAttemptController) UnlockUser(c *fiber.Ctx) error {
+	// Read the param userId
+	id := c.Params("userId")
+
+	user, err := lc.UserService.GetUserById(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No user found with ID"})
+	}
+
+	if err := lc.Service.Unlock(user.Username); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't unlock user",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "User: " + user.Username + " was unlocked successfully"})
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// The state of the OpenID Connect flow is kept in this cookie of the browser which started it,
+// the callback is only accepted from that browser
+const (
+	oidcStateCookie    = "oidc_state"
+	oidcStateCookieAge = 10 * time.Minute
+)
+
+type OIDCController struct {
+	Service *services.OIDCService
+}
+
+func NewOIDCController(service *services.OIDCService) *OIDCController {
+	return &OIDCController{Service: service}
+}
+
+// Listing the configured identity providers
+func (oc *OIDCController) GetProviders(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   oc.Service.Providers()})
+}
+
+// Redirecting the user to the identity provider, "?redirect=false" returns the URL instead
+func (oc *OIDCController) StartLogin(c *fiber.Ctx) error {
+	authURL, state, err := oc.Service.StartLogin(c.Params("provider"))
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setOIDCStateCookie(c, state)
+
+	if c.Query("redirect") == "false" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status": "success",
+			"data":   fiber.Map{"authorization_url": authURL}})
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// Handling the redirect back from the identity provider
+func (oc *OIDCController) Callback(c *fiber.Ctx) error {
+	// The provider reports refusals and errors in the query
+	if providerError := c.Query("error"); providerError != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication failed!",
+			"error":   providerError + ": " + c.Query("error_description")})
+	}
+
+	result, err := oc.Service.Callback(c.Params("provider"), c.Query("code"), c.Query("state"), c.Cookies(oidcStateCookie), clientInfo(c))
+	// The state is single use, the cookie is not needed anymore
+	setOIDCStateCookie(c, "")
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	if result.Linked != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Account linked successfully!",
+			"data":    result.Linked})
+	}
+
+	if result.Challenge != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":              "success",
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     result.Challenge.ChallengeToken,
+			"expires_in":          result.Challenge.ExpiresIn,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":        "success",
+		"token":         result.Tokens.AccessToken,
+		"refresh_token": result.Tokens.RefreshToken,
+		"expires_in":    result.Tokens.ExpiresIn,
+	})
+}
+
+// Starting the linking of a provider account to the authenticated user
+func (oc *OIDCController) StartLink(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	authURL, state, err := oc.Service.StartLink(c.Params("provider"), claims.Subject)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setOIDCStateCookie(c, state)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Open the authorization URL to link your account",
+		"data":    fiber.Map{"authorization_url": authURL}})
+}
+
+// Listing the provider accounts linked to the authenticated user
+func (oc *OIDCController) GetIdentities(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	identities, err := oc.Service.GetIdentities(claims.Subject)
+	if err != nil {
+		return oidcError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Identities found!",
+		"data":    identities})
+}
+
+// Unlinking a provider account from the authenticated user
+func (oc *OIDCController) Unlink(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	if err := oc.Service.Unlink(claims.Subject, c.Params("identityId")); err != nil {
+		return oidcError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Identity was unlinked successfully"})
+}
+
+// setOIDCStateCookie keeps the state in the browser for the callback, an empty state removes the cookie.
+// Lax lets the cookie follow the redirect from the provider, which is a top level navigation.
+func setOIDCStateCookie(c *fiber.Ctx, state string) {
+	expires := time.Now().Add(oidcStateCookieAge)
+	maxAge := int(oidcStateCookieAge.Seconds())
+	if state == "" {
+		expires, maxAge = time.Unix(0, 0), -1
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   c.Secure(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// oidcError maps the errors of the OpenID Connect service to responses
+func oidcError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "provider not found", "identity not found", "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	case "invalid state":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The login request is invalid or has expired, start again"})
+	case "identity already linked":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This provider account is already linked to another user"})
+	case "account exists":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "An account with this email already exists, log in with your password and link the provider from your profile"})
+	case "provisioning not allowed", "email not verified by provider", "email not verified":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication failed!",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Authentication with the identity provider failed",
+		"error":   err.Error()})
+}
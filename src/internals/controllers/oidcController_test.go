@@ -0,0 +1,452 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/oidc"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+const mockClientID = "readerblog-test"
+
+// mockIssuer is a minimal OpenID Connect provider: discovery, JWKS, an authorization endpoint
+// logging in the account set on it and a token endpoint checking PKCE
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// Account the next authorization logs in
+	account oidc.IDTokenClaims
+	// Pending authorizations by code
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	claims    oidc.IDTokenClaims
+	challenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := uuid.NewString()
+
+		m.mu.Lock()
+		claims := m.account
+		claims.Nonce = query.Get("nonce")
+		m.codes[code] = mockAuthorization{claims: claims, challenge: query.Get("code_challenge")}
+		m.mu.Unlock()
+
+		redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		authorization, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+
+		if !ok || oidc.PKCEChallenge(r.FormValue("code_verifier")) != authorization.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := authorization.claims
+		claims.Issuer = m.URL
+		claims.Audience = jwt.ClaimStrings{mockClientID}
+		claims.IssuedAt = jwt.NewNumericDate(time.Now())
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(m.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// logsIn sets the account the provider authenticates next
+func (m *mockIssuer) logsIn(subject, email, username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.account = oidc.IDTokenClaims{Email: email, EmailVerified: true, PreferredUsername: username}
+	m.account.Subject = subject
+}
+
+// In memory repositories, only the methods used by the flow are implemented
+type fakeUserRepository struct {
+	repositories.UserRepository
+	users []*models.User
+}
+
+func (r *fakeUserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	for _, user := range r.users {
+		if match(user) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepository) FindUserById(id string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID.String() == id })
+}
+
+// Like the database, lookups by username or email skip deleted users
+func (r *fakeUserRepository) FindUserByUsername(username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username && !u.DeletedAt.Valid })
+}
+
+func (r *fakeUserRepository) UsernameTaken(username string) (bool, error) {
+	_, err := r.find(func(u *models.User) bool { return u.Username == username })
+	return err == nil, nil
+}
+
+func (r *fakeUserRepository) FindUserByEmail(email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Email == email && !u.DeletedAt.Valid })
+}
+
+func (r *fakeUserRepository) CreateUser(user *models.User) error {
+	copied := *user
+	r.users = append(r.users, &copied)
+	return nil
+}
+
+func (r *fakeUserRepository) UpdateUser(user *models.User) error {
+	for i, existing := range r.users {
+		if existing.ID == user.ID {
+			copied := *user
+			r.users[i] = &copied
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+type fakeIdentityRepository struct {
+	repositories.IdentityRepository
+	identities []*models.Identity
+}
+
+func (r *fakeIdentityRepository) CreateIdentity(identity *models.Identity) error {
+	identity.CreatedAt = time.Now()
+	copied := *identity
+	r.identities = append(r.identities, &copied)
+	return nil
+}
+
+func (r *fakeIdentityRepository) FindIdentity(provider, subject string) (*models.Identity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentityRepository) UpdateIdentity(identity *models.Identity) error {
+	return nil
+}
+
+type fakeSessionRepository struct {
+	repositories.SessionRepository
+}
+
+func (r *fakeSessionRepository) CreateSession(session *models.Session) error {
+	return nil
+}
+
+type fakeLoginAttemptRepository struct {
+	repositories.LoginAttemptRepository
+}
+
+func (r *fakeLoginAttemptRepository) CreateLoginAttempt(attempt *models.LoginAttempt) error {
+	return nil
+}
+
+type oidcTestEnv struct {
+	app        *fiber.App
+	issuer     *mockIssuer
+	users      *fakeUserRepository
+	identities *fakeIdentityRepository
+}
+
+// newOIDCTestEnv serves the OIDC routes against the mock issuer, the link route authenticates
+// as the user whose ID is in the X-Test-User header
+func newOIDCTestEnv(t *testing.T, linkByEmail bool) *oidcTestEnv {
+	issuer := newMockIssuer(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("APP_URL", "http://readerblog.test")
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", issuer.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", mockClientID)
+	t.Setenv("OIDC_MOCK_AUTO_PROVISION", "true")
+	if linkByEmail {
+		t.Setenv("OIDC_MOCK_LINK_BY_EMAIL", "true")
+	}
+	if err := utils.InitKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	users := &fakeUserRepository{}
+	identities := &fakeIdentityRepository{}
+	sessionService := services.NewSessionService(&fakeSessionRepository{}, redisClient)
+	userService := services.NewUserService(users, sessionService)
+	throttle := services.NewLoginThrottleService(&fakeLoginAttemptRepository{}, redisClient)
+	authService := services.NewAuthService(nil, userService, sessionService, nil, nil, throttle, nil, redisClient)
+	controller := NewOIDCController(services.NewOIDCService(identities, users, authService, redisClient))
+
+	app := fiber.New()
+	app.Get("/api/auth/:provider/start", controller.StartLogin)
+	app.Get("/api/auth/:provider/callback", controller.Callback)
+	app.Post("/api/profile/identities/:provider/start", func(c *fiber.Ctx) error {
+		claims := &utils.Claims{}
+		claims.Subject = c.Get("X-Test-User")
+		c.Locals("claims", claims)
+		return c.Next()
+	}, controller.StartLink)
+
+	return &oidcTestEnv{app: app, issuer: issuer, users: users, identities: identities}
+}
+
+// authorize opens the authorization URL at the mock issuer and returns the callback it redirects to
+func (env *oidcTestEnv) authorize(t *testing.T, authURL string) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.RequestURI()
+}
+
+func (env *oidcTestEnv) do(t *testing.T, req *http.Request) (*http.Response, map[string]interface{}) {
+	resp, err := env.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body
+}
+
+func stateCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("state cookie must be HttpOnly and SameSite=Lax, got %+v", cookie)
+			}
+			return cookie
+		}
+	}
+	t.Fatal("start did not set the state cookie")
+	return nil
+}
+
+// startLogin runs the start route and the authorization, returning the callback and the state cookie
+func (env *oidcTestEnv) startLogin(t *testing.T) (string, *http.Cookie) {
+	resp, _ := env.do(t, httptest.NewRequest(http.MethodGet, "/api/auth/mock/start", nil))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("start answered %d", resp.StatusCode)
+	}
+	return env.authorize(t, resp.Header.Get("Location")), stateCookie(t, resp)
+}
+
+func (env *oidcTestEnv) callback(t *testing.T, callback string, cookie *http.Cookie) (*http.Response, map[string]interface{}) {
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return env.do(t, req)
+}
+
+func TestOIDCLoginProvisionsAccount(t *testing.T) {
+	env := newOIDCTestEnv(t, false)
+	env.issuer.logsIn("alice-subject", "Alice@Example.com", "alice")
+
+	callback, cookie := env.startLogin(t)
+	resp, body := env.callback(t, callback, cookie)
+	if resp.StatusCode != http.StatusOK || body["token"] == nil || body["refresh_token"] == nil {
+		t.Fatalf("callback answered %d %v, want tokens", resp.StatusCode, body)
+	}
+
+	if len(env.users.users) != 1 {
+		t.Fatalf("got %d users, want the provisioned one", len(env.users.users))
+	}
+	user := env.users.users[0]
+	if user.Email != "alice@example.com" || user.VerifiedAt == nil {
+		t.Errorf("provisioned user has email %q and verified at %v", user.Email, user.VerifiedAt)
+	}
+	if len(env.identities.identities) != 1 || env.identities.identities[0].UserID != user.ID {
+		t.Fatalf("the provider account was not linked to the provisioned user")
+	}
+
+	// Logging in again finds the same account
+	callback, cookie = env.startLogin(t)
+	if resp, body := env.callback(t, callback, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second login answered %d %v", resp.StatusCode, body)
+	}
+	if len(env.users.users) != 1 || len(env.identities.identities) != 1 {
+		t.Errorf("second login created %d users and %d identities", len(env.users.users), len(env.identities.identities))
+	}
+}
+
+func TestOIDCLinkAttachesIdentityToUser(t *testing.T) {
+	env := newOIDCTestEnv(t, false)
+	bob := &models.User{ID: uuid.New(), Username: "bobsmith01", Email: "bob@example.com"}
+	env.users.CreateUser(bob)
+	// The provider account uses another address than the local one
+	env.issuer.logsIn("bob-subject", "bob@corp.example", "bob")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/profile/identities/mock/start", nil)
+	req.Header.Set("X-Test-User", bob.ID.String())
+	resp, body := env.do(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("link start answered %d %v", resp.StatusCode, body)
+	}
+	authURL := body["data"].(map[string]interface{})["authorization_url"].(string)
+
+	callback := env.authorize(t, authURL)
+	resp, body = env.callback(t, callback, stateCookie(t, resp))
+	if resp.StatusCode != http.StatusOK || body["message"] != "Account linked successfully!" {
+		t.Fatalf("callback answered %d %v, want the linked identity", resp.StatusCode, body)
+	}
+
+	if len(env.identities.identities) != 1 || env.identities.identities[0].UserID != bob.ID {
+		t.Fatalf("the provider account was not linked to bob")
+	}
+	if len(env.users.users) != 1 {
+		t.Errorf("linking created %d users", len(env.users.users)-1)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	env := newOIDCTestEnv(t, false)
+	env.issuer.logsIn("carol-subject", "carol@example.com", "carol")
+
+	callback, cookie := env.startLogin(t)
+
+	// Another browser opening the callback gets nothing
+	if resp, _ := env.callback(t, callback, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback without the cookie answered %d, want 400", resp.StatusCode)
+	}
+	forged := &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+	if resp, _ := env.callback(t, callback, forged); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback with another state answered %d, want 400", resp.StatusCode)
+	}
+	if len(env.users.users) != 0 {
+		t.Fatal("a refused callback provisioned an account")
+	}
+
+	// The refused callbacks did not spend the state of the browser which started the flow
+	if resp, body := env.callback(t, callback, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback with the cookie answered %d %v", resp.StatusCode, body)
+	}
+}
+
+func TestOIDCDoesNotLinkByEmailToUnverifiedAccount(t *testing.T) {
+	env := newOIDCTestEnv(t, true)
+	squatter := &models.User{ID: uuid.New(), Username: "squatter01", Email: "dave@example.com"}
+	env.users.CreateUser(squatter)
+	env.issuer.logsIn("dave-subject", "dave@example.com", "dave")
+
+	callback, cookie := env.startLogin(t)
+	if resp, body := env.callback(t, callback, cookie); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("callback answered %d %v, want 409 account exists", resp.StatusCode, body)
+	}
+	if len(env.identities.identities) != 0 {
+		t.Fatal("the provider account was linked to an unverified account")
+	}
+
+	// Once the owner verified the address the accounts are linked
+	now := time.Now()
+	env.users.users[0].VerifiedAt = &now
+	callback, cookie = env.startLogin(t)
+	if resp, body := env.callback(t, callback, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback answered %d %v, want tokens", resp.StatusCode, body)
+	}
+	if len(env.identities.identities) != 1 || env.identities.identities[0].UserID != squatter.ID {
+		t.Fatal("the provider account was not linked to the verified account")
+	}
+}
+
+func TestOIDCProvisioningSkipsUsernamesOfDeletedUsers(t *testing.T) {
+	env := newOIDCTestEnv(t, false)
+	deleted := &models.User{ID: uuid.New(), Username: "erinwalsh", Email: "erin@old.example"}
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	env.users.CreateUser(deleted)
+	env.issuer.logsIn("erin-subject", "erin@example.com", "erinwalsh")
+
+	callback, cookie := env.startLogin(t)
+	if resp, body := env.callback(t, callback, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback answered %d %v", resp.StatusCode, body)
+	}
+	if len(env.users.users) != 2 {
+		t.Fatalf("got %d users, want the provisioned one next to the deleted one", len(env.users.users))
+	}
+	if username := env.users.users[1].Username; username == deleted.Username || !strings.HasPrefix(username, "erinwalsh") {
+		t.Errorf("provisioned username %q, want another one than the deleted user's", username)
+	}
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type PasswordController struct {
+	Service *services.PasswordResetService
+}
+
+func NewPasswordController(service *services.PasswordResetService) *PasswordController {
+	return &PasswordController{Service: service}
+}
+
+// Requesting a password reset link
+func (pc *PasswordController) ForgotPassword(c *fiber.Ctx) error {
+	var forgotDTO dtos.ForgotPasswordDTO
+
+	if err := c.BodyParser(&forgotDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	if err := pc.Service.ForgotPassword(&forgotDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't request password reset",
+			"error":   err.Error()})
+	}
+
+	// Same answer whether the address exists or not
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "If this address belongs to an account, a password reset link was sent"})
+}
+
+// Setting a new password with the token received by email
+func (pc *PasswordController) ResetPassword(c *fiber.Ctx) error {
+	var resetDTO dtos.ResetPasswordDTO
+
+	if err := c.BodyParser(&resetDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	if err := pc.Service.ResetPassword(&resetDTO); err != nil {
+		if reasons := passwordPolicyViolations(err); reasons != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Password does not meet the password policy",
+				"reasons": reasons})
+		}
+		if err.Error() == "invalid reset token" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "The reset token is invalid or has expired"})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't reset password",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Password was reset successfully, log in with your new password"})
+}
+
+// passwordPolicyViolations returns the reasons a password was refused by the policy, nil for other errors
+func passwordPolicyViolations(err error) []utils.PasswordViolation {
+	var policyErr *utils.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Violations
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type PostController struct {
+	Service *services.PostService
+}
+
+func NewPostController(service *services.PostService) *PostController {
+	return &PostController{Service: service}
+}
+
+func (pc *PostController) CreatePost(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var postDTO dtos.CreatePostDTO
+	if err := c.BodyParser(&postDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&postDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	post, err := pc.Service.CreatePost(claims, &postDTO, c.Query("format"))
+	if err != nil {
+		return postError(c, err, "Couldn't create post")
+	}
+
+	c.Location("/api/posts/" + post.ID)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Post was created successfully",
+		"data":    post})
+}
+
+// Listing the posts, anonymous users only see the published ones
+func (pc *PostController) GetPosts(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	var listQuery dtos.PostListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	posts, meta, err := pc.Service.ListPosts(claims, &listQuery)
+	if err != nil {
+		return postError(c, err, "Couldn't list posts")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   posts,
+		"meta":   meta})
+}
+
+// Listing the posts of a tag, the query parameters of the post list apply
+func (pc *PostController) GetTagPosts(c *fiber.Ctx) error {
+	return pc.getFilteredPosts(c, func(listQuery *dtos.PostListQueryDTO) { listQuery.Tag = c.Params("slug") })
+}
+
+// Listing the posts of a category, the query parameters of the post list apply
+func (pc *PostController) GetCategoryPosts(c *fiber.Ctx) error {
+	return pc.getFilteredPosts(c, func(listQuery *dtos.PostListQueryDTO) { listQuery.Category = c.Params("slug") })
+}
+
+func (pc *PostController) getFilteredPosts(c *fiber.Ctx, filter func(*dtos.PostListQueryDTO)) error {
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	var listQuery dtos.PostListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	filter(&listQuery)
+
+	posts, meta, err := pc.Service.ListPosts(claims, &listQuery)
+	if err != nil {
+		return postError(c, err, "Couldn't list posts")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   posts,
+		"meta":   meta})
+}
+
+// Getting one post by ID or slug
+func (pc *PostController) GetPost(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	post, err := pc.Service.GetPost(claims, c.Params("postId"), c.Query("format"))
+	if err != nil {
+		return postError(c, err, "Couldn't get post")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   post})
+}
+
+func (pc *PostController) UpdatePost(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var postDTO dtos.UpdatePostDTO
+	if err := c.BodyParser(&postDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&postDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	post, err := pc.Service.UpdatePost(claims, c.Params("postId"), &postDTO, c.Query("format"))
+	if err != nil {
+		return postError(c, err, "Couldn't update post")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Post was updated successfully",
+		"data":    post})
+}
+
+func (pc *PostController) DeletePost(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	post, err := pc.Service.DeletePost(claims, c.Query("force"), c.Params("postId"))
+	if err != nil {
+		return postError(c, err, "Couldn't delete post")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Post: " + post.Title + " was deleted successfully"})
+}
+
+func (pc *PostController) RestorePost(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	post, err := pc.Service.RestorePost(claims, c.Params("postId"))
+	if err != nil {
+		return postError(c, err, "Couldn't restore post")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Post: " + post.Title + " was restored successfully"})
+}
+
+// postError maps the errors of the post service to responses, the others are server errors
+func postError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case err.Error() == "post not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No post found with this ID or slug"})
+	case err.Error() == "tag not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No tag found with this slug"})
+	case err.Error() == "category not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No category found with this slug"})
+	case err.Error() == "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No user found with this username"})
+	case err.Error() == "authentication required":
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Log in to list posts which are not published"})
+	case err.Error() == "insufficient permissions", err.Error() == "two-factor authentication required":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	case err.Error() == "invalid status transition":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The post cannot move to this status",
+			"error":   err.Error()})
+	case strings.HasPrefix(err.Error(), "invalid "), err.Error() == "unknown category":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"error":   err.Error()})
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// ReactionController serves the reactions of the posts and, when the route has a commentId, of the comments
+type ReactionController struct {
+	Service *services.ReactionService
+}
+
+func NewReactionController(service *services.ReactionService) *ReactionController {
+	return &ReactionController{Service: service}
+}
+
+func (rc *ReactionController) AddReaction(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var reactionDTO dtos.ReactionDTO
+	if err := c.BodyParser(&reactionDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&reactionDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	counts, err := rc.Service.AddReaction(claims, c.Params("postId"), c.Params("commentId"), reactionDTO.Type)
+	if err != nil {
+		return reactionError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Reaction was added",
+		"data":    fiber.Map{"reactions": counts}})
+}
+
+// Removing a reaction, its type is given by the type query parameter
+func (rc *ReactionController) RemoveReaction(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	counts, err := rc.Service.RemoveReaction(claims, c.Params("postId"), c.Params("commentId"), c.Query("type"))
+	if err != nil {
+		return reactionError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Reaction was removed",
+		"data":    fiber.Map{"reactions": counts}})
+}
+
+// Listing who reacted, anybody who can read the post can see it
+func (rc *ReactionController) GetReactions(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	var listQuery dtos.ReactionListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	reactors, meta, err := rc.Service.ListReactions(claims, c.Params("postId"), c.Params("commentId"), &listQuery)
+	if err != nil {
+		return reactionError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   reactors,
+		"meta":   meta})
+}
+
+// reactionError maps the errors of the reaction service to responses
+func reactionError(c *fiber.Ctx, err error) error {
+	switch {
+	case err.Error() == "post not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No post found with this ID or slug"})
+	case err.Error() == "comment not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No comment found with this ID on the post"})
+	case err.Error() == "unknown reaction type":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Unknown reaction type, use one of " + strings.Join(services.ReactionTypes(), ", ")})
+	case err.Error() == "already reacted":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You already reacted with this type"})
+	case err.Error() == "not reacted":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You did not react with this type"})
+	case strings.HasPrefix(err.Error(), "invalid "):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Reaction operation failed",
+		"error":   err.Error()})
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+type RoleController struct {
+	Service *services.RBACService
+}
+
+func NewRoleController(service *services.RBACService) *RoleController {
+	return &RoleController{Service: service}
+}
+
+// Listing every permission roles can be granted
+func (rc *RoleController) GetPermissions(c *fiber.Ctx) error {
+	permissions, err := rc.Service.ListPermissions()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve permissions",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Permissions found!",
+		"data":    permissions})
+}
+
+func (rc *RoleController) GetRoles(c *fiber.Ctx) error {
+	roles, err := rc.Service.ListRoles()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve roles",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Roles found!",
+		"data":    roles})
+}
+
+func (rc *RoleController) GetRole(c *fiber.Ctx) error {
+	role, err := rc.Service.GetRole(c.Params("roleId"))
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role was found successfully!",
+		"data":    role})
+}
+
+func (rc *RoleController) CreateRole(c *fiber.Ctx) error {
+	var roleDTO dtos.CreateRoleDTO
+
+	if err := c.BodyParser(&roleDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"data":    err.Error()})
+	}
+
+	role, err := rc.Service.CreateRole(&roleDTO)
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role was created successfully!",
+		"data":    role})
+}
+
+func (rc *RoleController) UpdateRole(c *fiber.Ctx) error {
+	var roleDTO dtos.UpdateRoleDTO
+
+	if err := c.BodyParser(&roleDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"data":    err.Error()})
+	}
+
+	role, err := rc.Service.UpdateRole(c.Params("roleId"), &roleDTO)
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role was updated successfully!",
+		"data":    role})
+}
+
+func (rc *RoleController) DeleteRole(c *fiber.Ctx) error {
+	if err := rc.Service.DeleteRole(c.Params("roleId")); err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role was deleted successfully"})
+}
+
+// Listing the roles of a user
+func (rc *RoleController) GetUserRoles(c *fiber.Ctx) error {
+	roles, err := rc.Service.GetUserRoles(c.Params("userId"))
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Roles found!",
+		"data":    roles})
+}
+
+// Replacing the roles of a user
+func (rc *RoleController) SetUserRoles(c *fiber.Ctx) error {
+	var rolesDTO dtos.UserRolesDTO
+
+	if err := c.BodyParser(&rolesDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"data":    err.Error()})
+	}
+
+	roles, err := rc.Service.SetUserRoles(c.Params("userId"), &rolesDTO)
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Roles were assigned successfully, they apply to the next request of the user",
+		"data":    roles})
+}
+
+// roleError maps the errors of the RBAC service to responses
+func roleError(c *fiber.Ctx, err error) error {
+	switch {
+	case err.Error() == "role not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No role found with ID"})
+	case err.Error() == "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No user found with ID"})
+	case err.Error() == "role already exists", err.Error() == "role in use":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	case strings.HasPrefix(err.Error(), "unknown role"), strings.HasPrefix(err.Error(), "unknown permission"):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Role operation failed",
+		"error":   err.Error()})
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type SessionController struct {
+	Service *services.SessionService
+}
+
+func NewSessionController(service *services.SessionService) *SessionController {
+	return &SessionController{Service: service}
+}
+
+// Listing the active sessions of the authenticated user
+func (sc *SessionController) GetSessions(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	sessions, err := sc.Service.ListSessions(claims.SessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve sessions",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Sessions found!",
+		"data":    sessions})
+}
+
+// Revoking one session of the authenticated user
+func (sc *SessionController) RevokeSession(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	// Read the param sessionId
+	id := c.Params("sessionId")
+
+	if err := sc.Service.RevokeSession(claims.SessionID, id); err != nil {
+		if err.Error() == "session not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "No session found with ID"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't revoke session",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Session was revoked successfully"})
+}
+
+// Logging the authenticated user out everywhere
+func (sc *SessionController) RevokeAllSessions(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	count, err := sc.Service.RevokeAllSessions(claims.SessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't revoke sessions",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Logged out from every session",
+		"data":    fiber.Map{"revoked": count}})
+}
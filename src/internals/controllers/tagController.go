@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type TagController struct {
+	Service *services.TagService
+}
+
+func NewTagController(service *services.TagService) *TagController {
+	return &TagController{Service: service}
+}
+
+// Listing the most used tags, ?q= keeps the ones starting like it for autocompletion
+func (tc *TagController) GetTags(c *fiber.Ctx) error {
+	tags, err := tc.Service.ListTags(c.Query("q"), c.Query("limit"))
+	if err != nil {
+		return tagError(c, err, "Couldn't list tags")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   tags})
+}
+
+func (tc *TagController) GetTag(c *fiber.Ctx) error {
+	tag, err := tc.Service.GetTag(c.Params("slug"))
+	if err != nil {
+		return tagError(c, err, "Couldn't retrieve tag")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   tag})
+}
+
+func (tc *TagController) RenameTag(c *fiber.Ctx) error {
+	var tagDTO dtos.RenameTagDTO
+	if err := c.BodyParser(&tagDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&tagDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	tag, err := tc.Service.RenameTag(c.Params("slug"), &tagDTO)
+	if err != nil {
+		return tagError(c, err, "Couldn't rename tag")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Tag was renamed successfully",
+		"data":    tag})
+}
+
+// Merging a tag into another one, its posts get the other tag
+func (tc *TagController) MergeTag(c *fiber.Ctx) error {
+	var mergeDTO dtos.MergeTagDTO
+	if err := c.BodyParser(&mergeDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	if err := utils.ValidateUser(&mergeDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	tag, err := tc.Service.MergeTag(c.Params("slug"), &mergeDTO)
+	if err != nil {
+		return tagError(c, err, "Couldn't merge tags")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Tag " + c.Params("slug") + " was merged into " + tag.Slug,
+		"data":    tag})
+}
+
+// tagError maps the errors of the tag service to responses
+func tagError(c *fiber.Ctx, err error, message string) error {
+	switch err.Error() {
+	case "tag not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No tag found with this slug"})
+	case "invalid tag", "invalid limit query", "cannot merge a tag into itself":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	case "tag already exists":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Another tag has this name, merge the tags instead"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"error":   err.Error()})
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type TwoFactorController struct {
+	Service *services.TwoFactorService
+}
+
+func NewTwoFactorController(service *services.TwoFactorService) *TwoFactorController {
+	return &TwoFactorController{Service: service}
+}
+
+// Generating a TOTP secret for the authenticated user
+func (tc *TwoFactorController) Enroll(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	enrollment, err := tc.Service.Enroll(claims.Subject)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Scan the secret with your authenticator app and confirm it with a code",
+		"data":    enrollment})
+}
+
+// Enabling 2FA with a code generated by the authenticator app
+func (tc *TwoFactorController) Confirm(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	var codeDTO dtos.TwoFactorCodeDTO
+
+	if err := c.BodyParser(&codeDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	codes, err := tc.Service.Confirm(claims.Subject, &codeDTO)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		"data":    fiber.Map{"recovery_codes": codes}})
+}
+
+// Replacing the recovery codes of the authenticated user
+func (tc *TwoFactorController) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	var codeDTO dtos.TwoFactorCodeDTO
+
+	if err := c.BodyParser(&codeDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	codes, err := tc.Service.RegenerateRecoveryCodes(claims.Subject, &codeDTO)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Recovery codes regenerated, the previous ones no longer work",
+		"data":    fiber.Map{"recovery_codes": codes}})
+}
+
+// Disabling 2FA with a TOTP or recovery code
+func (tc *TwoFactorController) Disable(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+	var codeDTO dtos.TwoFactorCodeDTO
+
+	if err := c.BodyParser(&codeDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	if err := tc.Service.Disable(claims.Subject, &codeDTO); err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Two-factor authentication disabled"})
+}
+
+// twoFactorError maps the errors of the 2FA service to responses
+func twoFactorError(c *fiber.Ctx, err error) error {
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to access this resource"})
+	case "invalid two-factor code":
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid two-factor code"})
+	case "two-factor authentication already enabled", "two-factor authentication not enabled", "two-factor authentication not enrolled":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Two-factor operation failed",
+		"error":   err.Error()})
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+type UserBatchController struct {
+	Service *services.UserBatchService
+}
+
+func NewUserBatchController(service *services.UserBatchService) *UserBatchController {
+	return &UserBatchController{Service: service}
+}
+
+// Applying one action to the users listed by ID or matching a filter
+func (bc *UserBatchController) RunBatch(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*utils.Claims)
+
+	var batchDTO dtos.UserBatchDTO
+	if err := c.BodyParser(&batchDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+
+	result, err := bc.Service.RunBatch(claims, &batchDTO)
+	if err != nil {
+		return batchError(c, err)
+	}
+
+	// An atomic batch stopped by one of its users changed nothing
+	if result.Aborted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Batch was aborted, no user was changed",
+			"data":    result})
+	}
+
+	message := "Batch was applied"
+	if result.DryRun {
+		message = "Batch was previewed, no user was changed"
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": message,
+		"data":    result})
+}
+
+// batchError maps the errors of the batch service to responses
+func batchError(c *fiber.Ctx, err error) error {
+	switch {
+	case err.Error() == "insufficient permissions", err.Error() == "two-factor authentication required":
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error()})
+	case err.Error() == "too many users":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Too many users for one batch, narrow the selection",
+			"error":   err.Error()})
+	case strings.HasPrefix(err.Error(), "invalid "), strings.HasPrefix(err.Error(), "unknown role"):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error()})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Batch failed",
+		"error":   err.Error()})
+}
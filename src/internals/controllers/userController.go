@@ -1,13 +1,17 @@
 package controllers
 
 import (
-	"os"
+	"bufio"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/timebetov/readerblog/internals/models/dtos"
 	"github.com/timebetov/readerblog/internals/services"
 )
 
+// Public profiles can be served from caches for a minute
+const publicProfileCacheControl = "public, max-age=60"
+
 type UserController struct {
 	Service *services.UserService
 }
@@ -20,37 +24,83 @@ func NewUserController(service *services.UserService) *UserController {
 
 // Getting all users
 func (uc *UserController) GetUsers(c *fiber.Ctx) error {
-	// Getting query
-	deletedQuery := c.Query("deleted")
+	// Getting the query parameters
+	var listQuery dtos.UserListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your query parameters",
+			"error":   err.Error()})
+	}
 
-	// Getting all users
-	users, err := uc.Service.GetUsers(deletedQuery)
+	// Getting one page of users
+	users, meta, err := uc.Service.GetUsers(&listQuery)
 	if err != nil {
-		if err.Error() == "invalid deleted query" {
+		switch err.Error() {
+		case "invalid limit query", "invalid cursor query", "invalid deleted query", "invalid sort query",
+			"invalid created_after query", "invalid created_before query", "invalid count query":
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Invalid boolean value for 'deleted' query parameter",
+				"message": "Review your query parameters",
+				"error":   err.Error(),
 			})
 		}
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not retrieve users",
-			"error":   err})
+			"error":   err.Error()})
 	}
 
-	// If no users were found, return an error
-	if len(users) == 0 {
-		return c.Status(404).JSON(fiber.Map{
-			"status":  "error",
-			"message": "No users found!",
-			"data":    nil})
-	}
-
-	// In case of success, return the users if found at least 1 user
+	// An empty page is still a successful answer
 	return c.Status(200).JSON(fiber.Map{
 		"status":  "success",
 		"message": "Users found!",
-		"data":    &users})
+		"data":    users,
+		"meta":    meta})
+}
+
+// Exporting the users matching the filters of the list as CSV or NDJSON, streamed page by page
+func (uc *UserController) ExportUsers(c *fiber.Ctx) error {
+	var listQuery dtos.UserListQueryDTO
+	if err := c.QueryParser(&listQuery); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your query parameters",
+			"error":   err.Error()})
+	}
+
+	format := c.Query("format", services.TransferFormatCSV)
+	export, err := uc.Service.ExportUsers(&listQuery, format)
+	if err != nil {
+		switch err.Error() {
+		case "invalid format query", "invalid deleted query", "invalid created_after query", "invalid created_before query":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Review your query parameters",
+				"error":   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not export users",
+			"error":   err.Error()})
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == services.TransferFormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	c.Attachment("users." + format)
+	c.Set(fiber.HeaderContentType, contentType)
+
+	// The status is sent before the users are read, a failure can only cut the file short
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export(w); err != nil {
+			log.Printf("User export was interrupted: %v", err)
+		}
+		w.Flush()
+	})
+	return nil
 }
 
 // Creating a brand new User
@@ -68,6 +118,12 @@ func (uc *UserController) CreateUser(c *fiber.Ctx) error {
 	// Passing to the service layer to create a new user
 	createdUser, err := uc.Service.CreateUser(&userDTO)
 	if err != nil {
+		if reasons := passwordPolicyViolations(err); reasons != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Password does not meet the password policy",
+				"reasons": reasons})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Couldn't create user",
@@ -83,6 +139,29 @@ func (uc *UserController) CreateUser(c *fiber.Ctx) error {
 	})
 }
 
+// Getting the public profile of a user, anybody can read it
+func (uc *UserController) GetPublicProfile(c *fiber.Ctx) error {
+	profile, err := uc.Service.GetPublicProfile(c.Params("username"))
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "No user found with username"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve the profile",
+			"error":   err.Error()})
+	}
+
+	// Shared caches may keep the profile for a while, the ETag revalidates it afterwards
+	c.Set(fiber.HeaderCacheControl, publicProfileCacheControl)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Profile was found successfully!",
+		"data":    profile})
+}
+
 func (uc *UserController) GetUser(c *fiber.Ctx) error {
 	// Read the param userId
 	id := c.Params("userId")
@@ -117,17 +196,17 @@ func (uc *UserController) UpdateUser(c *fiber.Ctx) error {
 
 	user, err := uc.Service.UpdateUser(id, &userDTO)
 	if err != nil {
+		if reasons := passwordPolicyViolations(err); reasons != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Password does not meet the password policy",
+				"reasons": reasons})
+		}
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"status":  "error",
 				"message": "No user found with ID",
 			})
-		} else if err.Error() == "invalid role" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Invalid role",
-				"allowed": []string{os.Getenv("ADMIN_ROLE"), os.Getenv("WRITER_ROLE")},
-			})
 		} else if err.Error() == "passwords do not match" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"status":  "error",
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+type VerificationController struct {
+	Service *services.VerificationService
+}
+
+func NewVerificationController(service *services.VerificationService) *VerificationController {
+	return &VerificationController{Service: service}
+}
+
+// Handling the link sent by email
+func (vc *VerificationController) VerifyEmail(c *fiber.Ctx) error {
+	// Read the query 'token'
+	token := c.Query("token")
+
+	user, err := vc.Service.VerifyEmail(token)
+	if err != nil {
+		if err.Error() == "invalid verification link" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "The verification link is invalid or has expired"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't verify email",
+			"error":   err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email " + user.Email + " was verified successfully"})
+}
+
+// Sending a new verification link
+func (vc *VerificationController) ResendVerification(c *fiber.Ctx) error {
+	var resendDTO dtos.ResendVerificationDTO
+
+	if err := c.BodyParser(&resendDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid input",
+			"data":    err.Error()})
+	}
+
+	if err := vc.Service.ResendVerification(&resendDTO); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Couldn't send verification email",
+			"error":   err.Error()})
+	}
+
+	// Same answer whether the address exists or not
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "If this address belongs to an unverified account, a new verification link was sent"})
+}
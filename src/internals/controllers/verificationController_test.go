@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/mailer"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+var verificationLink = regexp.MustCompile(`http\S+/api/verify-email\?token=\S+`)
+
+type verificationTestEnv struct {
+	app   *fiber.App
+	mail  *mailer.MemoryMailer
+	users *fakeUserRepository
+	user  *models.User
+}
+
+// newVerificationTestEnv serves the verification routes for one unverified user
+func newVerificationTestEnv(t *testing.T) *verificationTestEnv {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("APP_URL", "http://readerblog.test")
+	if err := utils.InitKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	users := &fakeUserRepository{}
+	user := &models.User{ID: uuid.New(), Username: "erinwalsh01", Email: "erin@example.com"}
+	users.CreateUser(user)
+	mail := mailer.NewMemoryMailer()
+	controller := NewVerificationController(services.NewVerificationService(users, mail, redisClient))
+
+	app := fiber.New()
+	app.Get("/api/verify-email", controller.VerifyEmail)
+	app.Post("/api/verify-email/resend", controller.ResendVerification)
+
+	return &verificationTestEnv{app: app, mail: mail, users: users, user: user}
+}
+
+func (env *verificationTestEnv) do(t *testing.T, req *http.Request) (*http.Response, map[string]interface{}) {
+	resp, err := env.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body
+}
+
+// requestLink asks for a verification email and returns the token of the link it contains
+func (env *verificationTestEnv) requestLink(t *testing.T) string {
+	req := httptest.NewRequest(http.MethodPost, "/api/verify-email/resend", strings.NewReader(`{"email":"Erin@Example.com"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if resp, body := env.do(t, req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("resend answered %d %v", resp.StatusCode, body)
+	}
+
+	messages := env.mail.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails, want the verification email", len(messages))
+	}
+	if messages[0].To != env.user.Email {
+		t.Fatalf("verification email sent to %q", messages[0].To)
+	}
+	link, err := url.Parse(verificationLink.FindString(messages[0].Body))
+	if err != nil || link.Host != "readerblog.test" {
+		t.Fatalf("no link to the app in the email: %q", messages[0].Body)
+	}
+	return link.Query().Get("token")
+}
+
+func (env *verificationTestEnv) verify(t *testing.T, token string) (*http.Response, map[string]interface{}) {
+	return env.do(t, httptest.NewRequest(http.MethodGet, "/api/verify-email?token="+url.QueryEscape(token), nil))
+}
+
+func (env *verificationTestEnv) verified(t *testing.T) bool {
+	user, err := env.users.FindUserById(env.user.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.VerifiedAt != nil
+}
+
+func TestVerificationLinkVerifiesEmail(t *testing.T) {
+	env := newVerificationTestEnv(t)
+
+	token := env.requestLink(t)
+	resp, body := env.verify(t, token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify answered %d %v", resp.StatusCode, body)
+	}
+	if !env.verified(t) {
+		t.Fatal("the email was not marked as verified")
+	}
+
+	// Opening the link again is harmless
+	if resp, body := env.verify(t, token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second verify answered %d %v", resp.StatusCode, body)
+	}
+
+	// Verified addresses are not sent another link
+	req := httptest.NewRequest(http.MethodPost, "/api/verify-email/resend", strings.NewReader(`{"email":"erin@example.com"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	env.do(t, req)
+	if len(env.mail.Messages()) != 1 {
+		t.Errorf("got %d emails after verifying, want no new one", len(env.mail.Messages()))
+	}
+}
+
+func TestVerificationRejectsExpiredLink(t *testing.T) {
+	env := newVerificationTestEnv(t)
+
+	token, err := utils.GenerateChallengeToken(env.user.ID.String(), &utils.ChallengeClaims{
+		Purpose:  "verify_email",
+		Username: env.user.Username,
+		Email:    env.user.Email,
+	}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp, body := env.verify(t, token); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("verify with an expired link answered %d %v, want 400", resp.StatusCode, body)
+	}
+	if env.verified(t) {
+		t.Fatal("an expired link verified the email")
+	}
+}
+
+func TestVerificationRejectsTamperedLink(t *testing.T) {
+	env := newVerificationTestEnv(t)
+	token := env.requestLink(t)
+	parts := strings.Split(token, ".")
+
+	// The payload claims another address, the signature no longer matches
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(payload), env.user.Email, "mallory@example.com", 1)
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(forged))
+
+	// Tokens issued for another purpose are not verification links
+	otherPurpose, err := utils.GenerateChallengeToken(env.user.ID.String(), &utils.ChallengeClaims{
+		Purpose:  "two_factor",
+		Username: env.user.Username,
+		Email:    env.user.Email,
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tampered := range map[string]string{
+		"forged payload": strings.Join(parts, "."),
+		"cut signature":  token[:len(token)-4],
+		"other purpose":  otherPurpose,
+		"missing token":  "",
+	} {
+		if resp, body := env.verify(t, tampered); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("verify with a %s answered %d %v, want 400", name, resp.StatusCode, body)
+		}
+	}
+	if env.verified(t) {
+		t.Fatal("a tampered link verified the email")
+	}
+
+	// A link sent before the address changed does not verify the new one
+	env.user.Email = "erin@newmail.example"
+	env.users.UpdateUser(env.user)
+	if resp, body := env.verify(t, token); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("verify with the link of the old address answered %d %v, want 400", resp.StatusCode, body)
+	}
+	if env.verified(t) {
+		t.Fatal("the link of the old address verified the new one")
+	}
+}
@@ -0,0 +1,27 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every email as an .eml file into a directory, handy in development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir, from: sender()}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o644)
+}
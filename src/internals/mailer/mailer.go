@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"log"
+	"os"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails, implementations are picked with the MAILER env variable
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer builds the mailer configured by MAILER: "smtp" or "file" (default).
+// Emails carry verification and reset tokens, they are never only logged.
+func NewMailer() Mailer {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		m, err := NewSMTPMailer()
+		if err != nil {
+			log.Fatalf("Could not configure SMTP mailer: %v", err)
+		}
+		return m
+	case "", "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir)
+	default:
+		log.Fatalf("Unknown MAILER %q, expected smtp or file", os.Getenv("MAILER"))
+		return nil
+	}
+}
+
+// sender returns the From address of outgoing emails
+func sender() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "readerblog <no-reply@readerblog.local>"
+}
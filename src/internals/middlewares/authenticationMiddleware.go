@@ -11,14 +11,30 @@ import (
 func AuthenticationMiddleware(authService *services.AuthService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
-		if authHeader == "" {
+		parts := strings.Split(authHeader, " ")
+		if authHeader == "" || len(parts) != 2 {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"status":  "error",
 				"message": "Missing or invalid token",
 			})
 		}
 
-		tokenStr := strings.Split(authHeader, " ")[1]
+		tokenStr := parts[1]
+
+		// Personal access tokens are checked against the database, they have no session
+		if authService.IsAPIToken(tokenStr) {
+			claims, err := authService.AuthenticateAPIToken(tokenStr, c.IP())
+			if err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Invalid or expired token",
+				})
+			}
+
+			c.Locals("claims", claims)
+			return c.Next()
+		}
+
 		claims, err := utils.ParseToken(tokenStr)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -35,7 +51,51 @@ func AuthenticationMiddleware(authService *services.AuthService) fiber.Handler {
 			})
 		}
 
+		// Check if the session of the token was revoked
+		if !authService.IsSessionActive(claims) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Session has been revoked",
+			})
+		}
+
+		// Roles or password changed since the token was issued
+		if !authService.IsTokenVersionCurrent(claims) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Token is outdated, refresh it",
+			})
+		}
+
 		c.Locals("claims", claims)
 		return c.Next()
 	}
 }
+
+// OptionalAuthenticationMiddleware authenticates the requests carrying an Authorization header
+// and lets anonymous ones through without claims, for routes readable by anybody
+func OptionalAuthenticationMiddleware(authService *services.AuthService) fiber.Handler {
+	authenticate := AuthenticationMiddleware(authService)
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+		return authenticate(c)
+	}
+}
+
+// SessionTokenMiddleware refuses personal access tokens on the routes managing the account itself,
+// like sessions, 2FA or the tokens. It must run after AuthenticationMiddleware.
+func SessionTokenMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := c.Locals("claims").(*utils.Claims)
+		if claims.IsAPIToken() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Personal access tokens cannot be used here, log in instead",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -1,25 +1,41 @@
 package middlewares
 
 import (
-	"os"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/timebetov/readerblog/internals/utils"
 )
 
-func AuthorizationMiddleware(requiredRole ...string) fiber.Handler {
+// AuthorizationMiddleware keeps personal access tokens to the resources their scopes cover.
+// Permissions of the user are checked by PermissionMiddleware. Anonymous requests let through
+// by OptionalAuthenticationMiddleware have no token to check.
+func AuthorizationMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		claims := c.Locals("claims").(*utils.Claims)
+		claims, ok := c.Locals("claims").(*utils.Claims)
+		if !ok {
+			return c.Next()
+		}
 
-		if len(requiredRole) > 0 && requiredRole[0] != "" {
-			if claims.Role != requiredRole[0] && claims.Role != os.Getenv("ADMIN_ROLE") {
-				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-					"status":  "error",
-					"message": "insufficient permissions",
-				})
-			}
+		if scope := requiredScope(c); !claims.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Token is missing the scope " + scope,
+			})
 		}
 
 		return c.Next()
 	}
 }
+
+// requiredScope derives the scope of the request from the first segment of its route
+// and its method, e.g. GET /api/users needs "users:read" and PATCH /api/users/:id "users:write"
+func requiredScope(c *fiber.Ctx) string {
+	resource := strings.SplitN(strings.TrimPrefix(c.Path(), "/api/"), "/", 2)[0]
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
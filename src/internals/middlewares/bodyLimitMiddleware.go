@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimitMiddleware refuses request bodies larger than limit bytes. The server streams
+// request bodies so imports can read files of any size, the other routes keep this limit.
+// Requests for which skip returns true go through unchecked.
+func BodyLimitMiddleware(limit int, skip func(c *fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if skip != nil && skip(c) {
+			return c.Next()
+		}
+
+		length := c.Request().Header.ContentLength()
+		if length > limit {
+			// The unread body would be taken for the next request
+			c.Context().SetConnectionClose()
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Request body too large",
+			})
+		}
+
+		// Chunked bodies announce no length, they are read up to the limit
+		if length == -1 && c.Context().RequestBodyStream() != nil {
+			body, err := io.ReadAll(io.LimitReader(c.Context().RequestBodyStream(), int64(limit)+1))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Could not read the request body",
+				})
+			}
+			if len(body) > limit {
+				c.Context().SetConnectionClose()
+				return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Request body too large",
+				})
+			}
+			c.Request().SetBody(body)
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// PermissionMiddleware lets the request through when the roles of the user grant every
+// listed permission, e.g. "users.delete". It must run after AuthenticationMiddleware.
+func PermissionMiddleware(rbacService *services.RBACService, permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := c.Locals("claims").(*utils.Claims)
+
+		if err := rbacService.Authorize(claims.Subject, claims.MFA, permissions...); err != nil {
+			switch err.Error() {
+			case "two-factor authentication required":
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Two-factor authentication is required for one of your roles, enable it and log in again",
+				})
+			case "insufficient permissions":
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"status":  "error",
+					"message": "insufficient permissions",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not check permissions",
+				"error":   err.Error(),
+			})
+		}
+
+		return c.Next()
+	}
+}
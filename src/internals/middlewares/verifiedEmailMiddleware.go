@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// VerifiedEmailMiddleware refuses write requests from users who did not verify their email
+// when EMAIL_VERIFICATION_POLICY is "write". It must run after AuthenticationMiddleware.
+func VerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+
+		claims := c.Locals("claims").(*utils.Claims)
+		if !claims.EmailVerified && services.EmailVerificationPolicy() == services.VerificationPolicyWrite {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Verify your email address first, then refresh your token",
+			})
+		}
+
+		return c.Next()
+	}
+}
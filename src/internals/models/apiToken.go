@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIToken is a personal access token a user creates for scripts, only its hash is stored
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Name       string     `gorm:"not null"`
+	Prefix     string     `gorm:"not null"`
+	TokenHash  string     `gorm:"not null;uniqueIndex"`
+	Scopes     string     `gorm:"type:text;not null"`
+	MFA        bool       `gorm:"not null;default:false"`
+	ExpiresAt  *time.Time `gorm:"default:null"`
+	LastUsedAt *time.Time `gorm:"default:null"`
+	LastUsedIP string     `gorm:"type:text"`
+	CreatedAt  time.Time
+	User       User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Comment is a reply to a post or, through ParentID, to another comment. A deleted comment
+// stays in the thread as a tombstone so its replies keep their place.
+type Comment struct {
+	ID     uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	PostID uuid.UUID `gorm:"type:uuid;not null;index:idx_comments_thread,priority:1" json:"post_id"`
+	Post   Post      `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"-"`
+	// Cleared when the author is deleted for good, the comment then shows no author
+	AuthorID *uuid.UUID `gorm:"type:uuid;index" json:"author_id"`
+	Author   *User      `gorm:"foreignKey:AuthorID;constraint:OnDelete:SET NULL" json:"-"`
+	// Comment replied to, nil for the comments on the post itself
+	ParentID *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"`
+	Parent   *Comment   `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE" json:"-"`
+	// Top level comment of the thread, nil for the top level comments themselves
+	RootID *uuid.UUID `gorm:"type:uuid;index" json:"root_id"`
+	// 0 for the top level comments, 1 for their replies, and so on
+	Depth uint   `gorm:"not null;default:0" json:"depth"`
+	Body  string `gorm:"type:text;not null" json:"body"`
+	// Counted in the same transaction as the reactions, never saved with the rest of the comment
+	Reactions ReactionCounts `gorm:"serializer:json;type:jsonb;not null;default:'{}'" json:"reactions"`
+	EditedAt  *time.Time     `json:"edited_at"`
+	CreatedAt time.Time      `gorm:"index:idx_comments_thread,priority:2" json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
@@ -0,0 +1,31 @@
+package dtos
+
+import "time"
+
+type CreateAPITokenDTO struct {
+	Name      string     `json:"name" validate:"required,max=64"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type UpdateAPITokenDTO struct {
+	Name   string   `json:"name" validate:"omitempty,max=64"`
+	Scopes []string `json:"scopes"`
+}
+
+type APITokenDTO struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatedAPITokenDTO is returned once on creation, the token itself cannot be retrieved later
+type CreatedAPITokenDTO struct {
+	APITokenDTO
+	Token string `json:"token"`
+}
@@ -0,0 +1,37 @@
+package dtos
+
+import "time"
+
+type CreateCommentDTO struct {
+	Body string `json:"body" validate:"required,max=5000"`
+	// Comment replied to, the post itself when empty
+	ParentID string `json:"parent_id" validate:"omitempty,uuid"`
+}
+
+type UpdateCommentDTO struct {
+	Body string `json:"body" validate:"required,max=5000"`
+}
+
+// CommentListQueryDTO holds the query parameters of the comment list, the service parses them
+type CommentListQueryDTO struct {
+	Limit  string `query:"limit"`
+	Cursor string `query:"cursor"`
+	// tree nests the replies in their parent, flat lists the threads in reading order with their depth
+	View string `query:"view"`
+}
+
+// CommentDTO is a comment, a deleted one is a tombstone without author nor body
+type CommentDTO struct {
+	ID       string         `json:"id"`
+	ParentID *string        `json:"parent_id"`
+	Depth    uint           `json:"depth"`
+	Author   *PostAuthorDTO `json:"author"`
+	Body     string         `json:"body"`
+	// Number of reactions by type, not shown on tombstones
+	Reactions map[string]int64 `json:"reactions,omitempty"`
+	Deleted   bool             `json:"deleted"`
+	EditedAt  *time.Time       `json:"edited_at"`
+	CreatedAt time.Time        `json:"created_at"`
+	// Only in the tree view
+	Replies []CommentDTO `json:"replies,omitempty"`
+}
@@ -0,0 +1,11 @@
+package dtos
+
+import "time"
+
+// FollowDTO is a user of a followers or following list
+type FollowDTO struct {
+	Username   string    `json:"username"`
+	Image      string    `json:"image"`
+	Bio        string    `json:"bio"`
+	FollowedAt time.Time `json:"followed_at"`
+}
@@ -0,0 +1,18 @@
+package dtos
+
+import "time"
+
+type IdentityDTO struct {
+	ID          string     `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	LinkedAt    time.Time  `json:"linked_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// ExternalLoginDTO is the outcome of an OpenID Connect callback, only one of the fields is set
+type ExternalLoginDTO struct {
+	Tokens    *TokenDTO
+	Challenge *TwoFactorChallengeDTO
+	Linked    *IdentityDTO
+}
@@ -0,0 +1,11 @@
+package dtos
+
+// PageMetaDTO describes a page of a cursor paginated list, the next page is
+// requested by passing next_cursor back as the cursor query parameter
+type PageMetaDTO struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	// Only counted when asked for with count=true
+	Total *int64 `json:"total,omitempty"`
+}
@@ -0,0 +1,72 @@
+package dtos
+
+import "time"
+
+type CreatePostDTO struct {
+	Title   string `json:"title" validate:"required,max=200"`
+	Content string `json:"content" validate:"required,max=100000"`
+	// A draft by default
+	Status         string `json:"status" validate:"omitempty,oneof=draft published archived"`
+	CommentsClosed bool   `json:"comments_closed"`
+	// Names of the tags, the missing ones are created
+	Tags []string `json:"tags" validate:"max=10,dive,required,max=64"`
+	// Slug of the category
+	Category string `json:"category"`
+}
+
+type UpdatePostDTO struct {
+	Title          *string `json:"title" validate:"omitempty,min=1,max=200"`
+	Content        *string `json:"content" validate:"omitempty,min=1,max=100000"`
+	Status         *string `json:"status" validate:"omitempty,oneof=draft published archived"`
+	CommentsClosed *bool   `json:"comments_closed"`
+	// Replaces the tags when given
+	Tags *[]string `json:"tags" validate:"omitempty,max=10,dive,required,max=64"`
+	// Slug of the category, empty to remove it
+	Category *string `json:"category"`
+}
+
+// PostListQueryDTO holds the query parameters of the post list, the service parses them
+type PostListQueryDTO struct {
+	Limit   string `query:"limit"`
+	Cursor  string `query:"cursor"`
+	Author  string `query:"author"`
+	Status  string `query:"status"`
+	Deleted string `query:"deleted"`
+	Format  string `query:"format"`
+	// Slugs of a tag and a category
+	Tag      string `query:"tag"`
+	Category string `query:"category"`
+}
+
+type PostDTO struct {
+	ID      string `json:"id"`
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+	// Content in the format asked for, markdown by default
+	Format         string `json:"format"`
+	Content        string `json:"content"`
+	Status         string `json:"status"`
+	CommentsClosed bool   `json:"comments_closed"`
+	// Number of reactions by type
+	Reactions   map[string]int64 `json:"reactions"`
+	Author      PostAuthorDTO    `json:"author"`
+	Category    *PostTagDTO      `json:"category"`
+	Tags        []PostTagDTO     `json:"tags"`
+	PublishedAt *time.Time       `json:"published_at"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	DeletedAt   *time.Time       `json:"deleted_at,omitempty"`
+}
+
+// PostTagDTO is a tag or the category of a post
+type PostTagDTO struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// PostAuthorDTO is the public part of the author shown with a post
+type PostAuthorDTO struct {
+	Username string `json:"username"`
+	Image    string `json:"image"`
+}
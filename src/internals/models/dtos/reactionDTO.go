@@ -0,0 +1,22 @@
+package dtos
+
+import "time"
+
+type ReactionDTO struct {
+	Type string `json:"type" validate:"required"`
+}
+
+// ReactionListQueryDTO holds the query parameters of the reaction list, the service parses them
+type ReactionListQueryDTO struct {
+	Limit  string `query:"limit"`
+	Cursor string `query:"cursor"`
+	Type   string `query:"type"`
+}
+
+// ReactorDTO is a user of a "who reacted" list
+type ReactorDTO struct {
+	Username  string    `json:"username"`
+	Image     string    `json:"image"`
+	Type      string    `json:"type"`
+	ReactedAt time.Time `json:"reacted_at"`
+}
@@ -0,0 +1,33 @@
+package dtos
+
+import "time"
+
+type CreateRoleDTO struct {
+	Name        string   `json:"name" validate:"required,min=3,max=64"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	IsDefault   bool     `json:"is_default"`
+	RequireMFA  bool     `json:"require_mfa"`
+}
+
+type UpdateRoleDTO struct {
+	Name        *string  `json:"name" validate:"omitempty,min=3,max=64"`
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+	IsDefault   *bool    `json:"is_default"`
+	RequireMFA  *bool    `json:"require_mfa"`
+}
+
+type RoleDTO struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsDefault   bool      `json:"is_default"`
+	RequireMFA  bool      `json:"require_mfa"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type UserRolesDTO struct {
+	Roles []string `json:"roles" validate:"required"`
+}
@@ -0,0 +1,21 @@
+package dtos
+
+import "time"
+
+// ClientInfoDTO describes the client a session is created for
+type ClientInfoDTO struct {
+	IP        string
+	UserAgent string
+	Device    string
+}
+
+type SessionDTO struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	IssuedAt   time.Time `json:"issued_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
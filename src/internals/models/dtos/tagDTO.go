@@ -0,0 +1,32 @@
+package dtos
+
+type TagDTO struct {
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	PostsCount int64  `json:"posts_count"`
+}
+
+type RenameTagDTO struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+type MergeTagDTO struct {
+	// Slug of the tag the posts move to
+	Into string `json:"into" validate:"required"`
+}
+
+type CategoryDTO struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+}
+
+type CreateCategoryDTO struct {
+	Name        string `json:"name" validate:"required,max=64"`
+	Description string `json:"description" validate:"max=500"`
+}
+
+type UpdateCategoryDTO struct {
+	Name        *string `json:"name" validate:"omitempty,min=1,max=64"`
+	Description *string `json:"description" validate:"omitempty,max=500"`
+}
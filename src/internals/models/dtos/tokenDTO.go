@@ -0,0 +1,12 @@
+package dtos
+
+// TokenDTO is the pair of credentials handed out on login, registration and refresh
+type TokenDTO struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type RefreshTokenDTO struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
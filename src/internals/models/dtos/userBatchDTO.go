@@ -0,0 +1,43 @@
+package dtos
+
+// UserBatchDTO applies one action to the users listed by ID or to the ones matching a filter
+type UserBatchDTO struct {
+	Action string              `json:"action" validate:"required,oneof=delete restore set_roles add_roles remove_roles"`
+	IDs    []string            `json:"ids"`
+	Filter *UserBatchFilterDTO `json:"filter"`
+	// Roles set, added or removed by the role actions
+	Roles []string `json:"roles"`
+	// Nothing is applied when one of the users fails
+	Atomic bool `json:"atomic"`
+	// Only tells which users would be affected
+	DryRun bool `json:"dry_run"`
+}
+
+// UserBatchFilterDTO selects users like the query parameters of the user list
+type UserBatchFilterDTO struct {
+	Role          string `json:"role"`
+	CreatedAfter  string `json:"created_after"`
+	CreatedBefore string `json:"created_before"`
+	EmailDomain   string `json:"email_domain"`
+	Deleted       string `json:"deleted"`
+}
+
+// UserBatchResultDTO reports what a batch did to every user
+type UserBatchResultDTO struct {
+	Action  string                   `json:"action"`
+	DryRun  bool                     `json:"dry_run"`
+	Atomic  bool                     `json:"atomic"`
+	Aborted bool                     `json:"aborted"`
+	Applied int                      `json:"applied"`
+	Skipped int                      `json:"skipped"`
+	Failed  int                      `json:"failed"`
+	Results []UserBatchItemResultDTO `json:"results"`
+}
+
+// UserBatchItemResultDTO is the outcome of a batch for one user
+type UserBatchItemResultDTO struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
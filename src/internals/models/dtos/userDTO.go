@@ -5,29 +5,97 @@ import "time"
 type CreateUserDTO struct {
 	Username             string `json:"username" validate:"required,min=8,max=32,username"`
 	Email                string `json:"email" validate:"required,email"`
-	Password             string `json:"password" validate:"required,min=8,max=32"`
+	Password             string `json:"password" validate:"required"`
 	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
 }
 
 type UpdateUserDTO struct {
 	Email                *string `json:"email" validate:"omitempty,email"`
-	Password             *string `json:"password" validate:"omitempty,min=8,max=32"`
+	Password             *string `json:"password"`
 	PasswordConfirmation *string `json:"password_confirmation" validate:"omitempty,eqfield=Password"`
-	Role                 *string `json:"role" validate:"omitempty,min=5"`
+	Image                *string `json:"image" validate:"omitempty,max=2048,url|len=0"`
+	Bio                  *string `json:"bio" validate:"omitempty,max=500"`
+}
+
+// UpdateProfileDTO is the update a user makes to their own account,
+// changing the email or the password requires the current password
+type UpdateProfileDTO struct {
+	UpdateUserDTO
+	CurrentPassword *string `json:"current_password" validate:"omitempty,max=1024"`
+	// Only the given flags are changed
+	Privacy *UpdatePrivacyDTO `json:"privacy"`
+}
+
+type UpdatePrivacyDTO struct {
+	Hidden           *bool `json:"hidden"`
+	HideImage        *bool `json:"hide_image"`
+	HideBio          *bool `json:"hide_bio"`
+	HideFollowCounts *bool `json:"hide_follow_counts"`
+	HideJoinDate     *bool `json:"hide_join_date"`
+	HidePostsCount   *bool `json:"hide_posts_count"`
+}
+
+type DeleteProfileDTO struct {
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+// UserListQueryDTO holds the query parameters of the user list, the service parses them
+type UserListQueryDTO struct {
+	Limit         string `query:"limit"`
+	Cursor        string `query:"cursor"`
+	Role          string `query:"role"`
+	CreatedAfter  string `query:"created_after"`
+	CreatedBefore string `query:"created_before"`
+	Deleted       string `query:"deleted"`
+	EmailDomain   string `query:"email_domain"`
+	Sort          string `query:"sort"`
+	Count         string `query:"count"`
 }
 
 type LoginUserDTO struct {
 	Username             string `json:"username" validate:"required,username,min=8,max=32"`
-	Password             string `json:"password" validate:"required,min=8,max=32"`
+	Password             string `json:"password" validate:"required,max=1024"`
 	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
+	// Restores an account its owner deleted, while the grace period runs
+	Restore bool `json:"restore"`
 }
 
 type ProfileDTO struct {
-	Username    string    `json:"username"`
-	Email       string    `json:"email"`
-	Role        string    `json:"role"`
-	Subscribers uint      `json:"subscribers"`
-	Followed    uint      `json:"followed"`
-	Image       string    `json:"image"`
-	CreatedAt   time.Time `json:"created_at"`
+	Username      string            `json:"username"`
+	Email         string            `json:"email"`
+	Roles         []string          `json:"roles"`
+	EmailVerified bool              `json:"email_verified"`
+	Subscribers   uint              `json:"subscribers"`
+	Followed      uint              `json:"followed"`
+	Image         string            `json:"image"`
+	Avatars       map[string]string `json:"avatars,omitempty"`
+	Bio           string            `json:"bio"`
+	Privacy       ProfilePrivacyDTO `json:"privacy"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// ProfilePrivacyDTO lists what the public profile hides
+type ProfilePrivacyDTO struct {
+	Hidden           bool `json:"hidden"`
+	HideImage        bool `json:"hide_image"`
+	HideBio          bool `json:"hide_bio"`
+	HideFollowCounts bool `json:"hide_follow_counts"`
+	HideJoinDate     bool `json:"hide_join_date"`
+	HidePostsCount   bool `json:"hide_posts_count"`
+}
+
+// PublicProfileDTO is the profile anybody can see, the fields hidden by the user are left out
+type PublicProfileDTO struct {
+	Username       string            `json:"username"`
+	Image          string            `json:"image,omitempty"`
+	Avatars        map[string]string `json:"avatars,omitempty"`
+	Bio            string            `json:"bio,omitempty"`
+	Subscribers    *uint             `json:"subscribers,omitempty"`
+	Followed       *uint             `json:"followed,omitempty"`
+	PublishedPosts *uint             `json:"published_posts,omitempty"`
+	JoinedAt       *time.Time        `json:"joined_at,omitempty"`
+}
+
+type ResendVerificationDTO struct {
+	Email string `json:"email" validate:"required,email"`
 }
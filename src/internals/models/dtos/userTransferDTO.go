@@ -0,0 +1,33 @@
+package dtos
+
+import "time"
+
+// UserImportDTO is one row of an import, users are matched by email. Empty fields
+// leave an existing user unchanged, new users without password have to reset it.
+type UserImportDTO struct {
+	Username string   `json:"username" validate:"required,min=8,max=32,username"`
+	Email    string   `json:"email" validate:"required,email"`
+	Password string   `json:"password" validate:"omitempty,max=1024"`
+	Image    string   `json:"image" validate:"omitempty,max=2048,url"`
+	Bio      string   `json:"bio" validate:"omitempty,max=500"`
+	Roles    []string `json:"roles"`
+}
+
+// UserExportDTO is one user of an export, a line of NDJSON or a row of CSV
+type UserExportDTO struct {
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	Roles         []string   `json:"roles"`
+	Image         string     `json:"image"`
+	Bio           string     `json:"bio"`
+	EmailVerified bool       `json:"email_verified"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeletedAt     *time.Time `json:"deleted_at"`
+}
+
+// ImportQueryDTO holds the query parameters of an import, the format defaults to the content type
+type ImportQueryDTO struct {
+	Format string `query:"format"`
+	DryRun string `query:"dry_run"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Follow is a user subscribing to another one. The pair is the primary key so a user follows
+// another one at most once, the counters of models.User are kept in line with this table.
+type Follow struct {
+	FollowerID uuid.UUID `gorm:"primaryKey;type:uuid;check:chk_follows_not_self,follower_id <> followed_id"`
+	FollowedID uuid.UUID `gorm:"primaryKey;type:uuid;index"`
+	CreatedAt  time.Time `gorm:"not null;index"`
+	Follower   User      `gorm:"foreignKey:FollowerID;constraint:OnDelete:CASCADE"`
+	Followed   User      `gorm:"foreignKey:FollowedID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links an account of an external OpenID Connect provider to a user
+type Identity struct {
+	ID          uuid.UUID  `gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Provider    string     `gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject     string     `gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email       string     `gorm:"type:text"`
+	LastLoginAt *time.Time `gorm:"default:null"`
+	CreatedAt   time.Time
+	User        User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
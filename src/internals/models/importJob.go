@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of an import job
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportJob tracks a bulk user import, the file is processed in the background
+type ImportJob struct {
+	ID        uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null;index" json:"created_by"`
+	Format    string    `gorm:"not null" json:"format"`
+	DryRun    bool      `gorm:"not null" json:"dry_run"`
+	Status    string    `gorm:"not null" json:"status"`
+	// Rows read so far, created and updated count what a dry run would do
+	Rows    int `gorm:"not null;default:0" json:"rows"`
+	Created int `gorm:"not null;default:0" json:"created"`
+	Updated int `gorm:"not null;default:0" json:"updated"`
+	Failed  int `gorm:"not null;default:0" json:"failed"`
+	// The first errors of the rows, Failed counts all of them
+	Errors []ImportRowError `gorm:"serializer:json;type:text" json:"errors"`
+	// Why the whole job failed, e.g. a missing column
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ImportRowError is the reason a row of an import was refused
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginAttempt is one entry of the login log, successful or not
+type LoginAttempt struct {
+	ID        uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Username  string    `gorm:"not null;index" json:"username"`
+	IP        string    `gorm:"not null;index" json:"ip"`
+	UserAgent string    `gorm:"type:text" json:"user_agent"`
+	Success   bool      `gorm:"not null" json:"success"`
+	Reason    string    `gorm:"not null" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Statuses of a post, only published posts are shown to readers
+const (
+	PostDraft     = "draft"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
+// Post is an article written by a user. The published_posts counter of the author
+// counts their published posts which are not deleted.
+type Post struct {
+	ID       uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	AuthorID uuid.UUID `gorm:"type:uuid;not null;index" json:"author_id"`
+	Author   User      `gorm:"foreignKey:AuthorID;constraint:OnDelete:CASCADE" json:"-"`
+	// Generated from the title on creation and never changed, so links keep working
+	Slug  string `gorm:"uniqueIndex;not null" json:"slug"`
+	Title string `gorm:"not null" json:"title"`
+	// Markdown written by the author
+	Content string `gorm:"type:text;not null" json:"content"`
+	// Sanitized HTML and plain text excerpt of the content, rendered when it is saved
+	ContentHTML string `gorm:"type:text" json:"content_html"`
+	Excerpt     string `gorm:"type:text" json:"excerpt"`
+	Status      string `gorm:"not null;index" json:"status"`
+	// Set by the author to stop new comments, the existing ones stay
+	CommentsClosed bool `gorm:"not null;default:false" json:"comments_closed"`
+	// Left without category when it is deleted
+	CategoryID *uuid.UUID `gorm:"type:uuid;index" json:"category_id"`
+	Category   *Category  `gorm:"foreignKey:CategoryID;constraint:OnDelete:SET NULL" json:"category"`
+	// Saved apart from the rest of the post, with the tag counts
+	Tags []Tag `gorm:"many2many:post_tags;constraint:OnDelete:CASCADE" json:"tags"`
+	// Counted in the same transaction as the reactions, never saved with the rest of the post
+	Reactions ReactionCounts `gorm:"serializer:json;type:jsonb;not null;default:'{}'" json:"reactions"`
+	// Set by the first publication
+	PublishedAt *time.Time     `gorm:"index" json:"published_at"`
+	CreatedAt   time.Time      `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+// IsCounted tells whether the post counts in the published_posts of its author
+func (p *Post) IsCounted() bool {
+	return p.Status == PostPublished && !p.DeletedAt.Valid
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReactionCounts holds the number of reactions of a post or a comment by reaction type
+type ReactionCounts map[string]int64
+
+// PostReaction is a user reacting to a post, at most once per reaction type.
+// The Reactions counts of models.Post are kept in line with this table.
+type PostReaction struct {
+	PostID    uuid.UUID `gorm:"primaryKey;type:uuid"`
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid;index"`
+	Type      string    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index"`
+	Post      Post      `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// CommentReaction is a user reacting to a comment, at most once per reaction type.
+// The Reactions counts of models.Comment are kept in line with this table.
+type CommentReaction struct {
+	CommentID uuid.UUID `gorm:"primaryKey;type:uuid"`
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid;index"`
+	Type      string    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index"`
+	Comment   Comment   `gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role groups permissions, users can hold several roles
+type Role struct {
+	ID          uuid.UUID    `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        string       `gorm:"unique;not null" json:"name"`
+	Description string       `gorm:"type:text" json:"description"`
+	IsDefault   bool         `gorm:"not null;default:false" json:"is_default"`
+	RequireMFA  bool         `gorm:"not null;default:false" json:"require_mfa"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// Permission is a named action checked by the routes, e.g. "users.delete"
+type Permission struct {
+	ID          uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        string    `gorm:"unique;not null" json:"name"`
+	Description string    `gorm:"type:text" json:"description"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one login of a user on a device, its ID is shared by every token issued for that login
+type Session struct {
+	ID         uuid.UUID  `gorm:"primary_key;type:uuid"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Device     string     `gorm:"type:text"`
+	IP         string     `gorm:"type:text"`
+	UserAgent  string     `gorm:"type:text"`
+	MFA        bool       `gorm:"not null;default:false"`
+	CreatedAt  time.Time  `gorm:"not null"`
+	LastSeenAt time.Time  `gorm:"not null"`
+	ExpiresAt  time.Time  `gorm:"not null;index"`
+	RevokedAt  *time.Time `gorm:"index"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
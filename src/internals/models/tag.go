@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tag labels posts, a post has any number of tags. PostsCount counts the posts
+// shown on the tag page, the published ones which are not deleted.
+type Tag struct {
+	ID   uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name string    `gorm:"not null" json:"name"`
+	// Normalized from the name, tags whose names give the same slug are the same tag
+	Slug       string    `gorm:"uniqueIndex;not null" json:"slug"`
+	PostsCount int64     `gorm:"not null;default:0;index" json:"posts_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Category files a post under one of the sections managed by the admins
+type Category struct {
+	ID          uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        string    `gorm:"not null" json:"name"`
+	Slug        string    `gorm:"uniqueIndex;not null" json:"slug"`
+	Description string    `gorm:"type:text" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
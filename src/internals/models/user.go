@@ -1,19 +1,49 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	ID          uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
-	Username    string         `gorm:"unique;not null" json:"username"`
-	Email       string         `gorm:"unique;not null" json:"email"`
-	Password    string         `gorm:"not null" json:"password"`
-	Role        string         `gorm:"not null;default:'writer'"`
-	Subscribers uint           `gorm:"default:0"`
-	Followed    uint           `gorm:"default:0"`
-	Image       string         `gorm:"type:text"`
-	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	ID          uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	Username    string    `gorm:"unique;not null" json:"username"`
+	Email       string    `gorm:"unique;not null" json:"email"`
+	Password    string    `gorm:"not null" json:"password"`
+	Roles       []Role    `gorm:"many2many:user_roles" json:"roles"`
+	Subscribers uint      `gorm:"default:0"`
+	Followed    uint      `gorm:"default:0"`
+	// Maintained with the posts, shown on the public profile
+	PublishedPosts uint   `gorm:"not null;default:0" json:"published_posts"`
+	Image          string `gorm:"type:text"`
+	Bio            string `gorm:"type:text"`
+	// What the public profile hides, everything is shown by default
+	Privacy ProfilePrivacy `gorm:"embedded;embeddedPrefix:privacy_" json:"privacy"`
+	// URLs of the uploaded avatar by size in pixels, Image holds the largest one
+	Avatars map[string]string `gorm:"serializer:json;type:text" json:"avatars,omitempty"`
+	// Storage key prefix of the avatar files, to remove them when replaced
+	AvatarKey   string     `gorm:"type:text" json:"-"`
+	TOTPSecret  string     `gorm:"type:text" json:"-"`
+	TOTPEnabled bool       `gorm:"not null;default:false"`
+	VerifiedAt  *time.Time `gorm:"default:null"`
+	// Bumped to invalidate every access token issued before, see UserRepository.IncrementTokenVersion
+	TokenVersion uint           `gorm:"not null;default:1" json:"-"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	// Set when the user deleted their own account, they can restore it by logging in until then
+	PurgeAt *time.Time `gorm:"default:null" json:"purge_at,omitempty"`
+}
+
+// ProfilePrivacy chooses which fields the public profile of a user hides,
+// the flags are negative so the zero value shows everything
+type ProfilePrivacy struct {
+	// The public profile is not found at all
+	Hidden           bool `gorm:"not null;default:false" json:"hidden"`
+	HideImage        bool `gorm:"not null;default:false" json:"hide_image"`
+	HideBio          bool `gorm:"not null;default:false" json:"hide_bio"`
+	HideFollowCounts bool `gorm:"not null;default:false" json:"hide_follow_counts"`
+	HideJoinDate     bool `gorm:"not null;default:false" json:"hide_join_date"`
+	HidePostsCount   bool `gorm:"not null;default:false" json:"hide_posts_count"`
 }
@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is an OpenID Connect identity provider configured from the environment.
+//
+// OIDC_PROVIDERS lists the provider names, e.g. "corp,google". For each NAME:
+//   - OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+//   - OIDC_<NAME>_SCOPES (default "openid email profile")
+//   - OIDC_<NAME>_AUTO_PROVISION: create accounts for unknown users (default false)
+//   - OIDC_<NAME>_ALLOWED_DOMAINS: comma separated email domains allowed to be provisioned
+//   - OIDC_<NAME>_LINK_BY_EMAIL: link to an existing account with the same verified email (default false)
+type Provider struct {
+	Name           string
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	Scopes         []string
+	AutoProvision  bool
+	AllowedDomains []string
+	LinkByEmail    bool
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+	keysAt    time.Time
+}
+
+// LoadProviders reads every provider listed in OIDC_PROVIDERS, redirectURL builds the callback URL
+func LoadProviders(redirectURL func(name string) string) map[string]*Provider {
+	providers := make(map[string]*Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &Provider{
+			Name:           name,
+			Issuer:         strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:       os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:   os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:    redirectURL(name),
+			Scopes:         strings.Fields(os.Getenv(prefix + "SCOPES")),
+			AutoProvision:  os.Getenv(prefix+"AUTO_PROVISION") == "true",
+			AllowedDomains: splitList(os.Getenv(prefix + "ALLOWED_DOMAINS")),
+			LinkByEmail:    os.Getenv(prefix+"LINK_BY_EMAIL") == "true",
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			continue
+		}
+
+		providers[name] = provider
+	}
+
+	return providers
+}
+
+// Names returns the sorted names of the providers
+func Names(providers map[string]*Provider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DomainAllowed tells whether accounts with this email can be provisioned, no list allows all
+func (p *Provider) DomainAllowed(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type apiTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAPITokenRepository(db *gorm.DB) APITokenRepository {
+	return &apiTokenRepository{db}
+}
+
+func (r *apiTokenRepository) CreateAPIToken(token *models.APIToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *apiTokenRepository) FindAPITokenByHash(hash string) (*models.APIToken, error) {
+	var token models.APIToken
+	err := r.db.First(&token, "token_hash = ?", hash).Error
+	return &token, err
+}
+
+// Get a token only if it belongs to the user
+func (r *apiTokenRepository) FindUserAPIToken(id, userID string) (*models.APIToken, error) {
+	var token models.APIToken
+	err := r.db.First(&token, "id = ? AND user_id = ?", id, userID).Error
+	return &token, err
+}
+
+func (r *apiTokenRepository) FindUserAPITokens(userID string) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *apiTokenRepository) UpdateAPIToken(token *models.APIToken) error {
+	return r.db.Save(token).Error
+}
+
+// Record when and from where the token was last used
+func (r *apiTokenRepository) TouchAPIToken(id string, at time.Time, ip string) error {
+	return r.db.Model(&models.APIToken{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_at": at, "last_used_ip": ip}).Error
+}
+
+// Delete a token of the user, reports whether one was deleted
+func (r *apiTokenRepository) DeleteAPIToken(id, userID string) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIToken{})
+	return result.RowsAffected == 1, result.Error
+}
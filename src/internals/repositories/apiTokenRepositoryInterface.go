@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type APITokenRepository interface {
+	CreateAPIToken(token *models.APIToken) error
+	FindAPITokenByHash(hash string) (*models.APIToken, error)
+	FindUserAPIToken(id, userID string) (*models.APIToken, error)
+	FindUserAPITokens(userID string) ([]models.APIToken, error)
+	UpdateAPIToken(token *models.APIToken) error
+	TouchAPIToken(id string, at time.Time, ip string) error
+	DeleteAPIToken(id, userID string) (bool, error)
+}
@@ -16,7 +16,8 @@ func NewAuthRepository(db *gorm.DB) AuthRepository {
 
 func (ar *authRepository) FindUserByCredentials(username, password string) (*models.User, error) {
 	var user models.User
-	if err := ar.db.First(&user, "username = ?", username).Error; err != nil {
+	// Deleted users are included, their owner may be restoring the account
+	if err := ar.db.Unscoped().Preload("Roles").First(&user, "username = ?", username).Error; err != nil {
 		return nil, err
 	}
 
@@ -25,9 +26,18 @@ func (ar *authRepository) FindUserByCredentials(username, password string) (*mod
 	}
 	return &user, nil
 }
-func (ar *authRepository) FindSelf(username string) (*models.User, error) {
+func (ar *authRepository) FindSelf(id string) (*models.User, error) {
 	var user models.User
-	// Find the user with the matching username
-	err := ar.db.First(&user, "username = ?", username).Error
+	// Find the active user with the matching ID
+	err := ar.db.Preload("Roles").First(&user, "id = ?", id).Error
 	return &user, err
 }
+
+// Replace only the password hash, e.g. when it is upgraded on login
+func (ar *authRepository) UpdatePassword(user *models.User, hashedPassword string) error {
+	if err := ar.db.Model(user).Update("password", hashedPassword).Error; err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
@@ -7,4 +7,5 @@ import (
 type AuthRepository interface {
 	FindUserByCredentials(username, password string) (*models.User, error)
 	FindSelf(id string) (*models.User, error)
+	UpdatePassword(user *models.User, hashedPassword string) error
 }
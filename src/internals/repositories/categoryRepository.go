@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type categoryRepository struct {
+	db *gorm.DB
+}
+
+func NewCategoryRepository(db *gorm.DB) CategoryRepository {
+	return &categoryRepository{db}
+}
+
+func (r *categoryRepository) FindCategories() ([]models.Category, error) {
+	categories := []models.Category{}
+	err := r.db.Order("name ASC").Find(&categories).Error
+	return categories, err
+}
+
+func (r *categoryRepository) FindCategoryBySlug(slug string) (*models.Category, error) {
+	var category models.Category
+	err := r.db.First(&category, "slug = ?", slug).Error
+	return &category, err
+}
+
+func (r *categoryRepository) CreateCategory(category *models.Category) error {
+	return r.db.Create(category).Error
+}
+
+func (r *categoryRepository) UpdateCategory(category *models.Category) error {
+	return r.db.Save(category).Error
+}
+
+// Deleting a category, its posts are left without category
+func (r *categoryRepository) DeleteCategory(category *models.Category) error {
+	return r.db.Delete(category).Error
+}
@@ -0,0 +1,11 @@
+package repositories
+
+import "github.com/timebetov/readerblog/internals/models"
+
+type CategoryRepository interface {
+	FindCategories() ([]models.Category, error)
+	FindCategoryBySlug(slug string) (*models.Category, error)
+	CreateCategory(category *models.Category) error
+	UpdateCategory(category *models.Category) error
+	DeleteCategory(category *models.Category) error
+}
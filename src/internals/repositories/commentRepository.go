@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type commentRepository struct {
+	db *gorm.DB
+}
+
+func NewCommentRepository(db *gorm.DB) CommentRepository {
+	return &commentRepository{db}
+}
+
+// Getting one page of the top level comments of a post, the tombstones keep their place
+func (r *commentRepository) FindRootComments(postID string, page *CommentPage) ([]models.Comment, error) {
+	comments := []models.Comment{}
+
+	query := r.db.Unscoped().Preload("Author", unscopedPreload).
+		Where("post_id = ? AND parent_id IS NULL", postID)
+	if page.After != nil {
+		query = query.Where("(created_at, id) > (?, ?)", page.After.CreatedAt, page.After.ID)
+	}
+
+	err := query.Order("created_at ASC").
+		Order("id ASC").
+		Limit(page.Limit).
+		Find(&comments).Error
+	return comments, err
+}
+
+// Getting every reply of the threads started by the given comments, oldest first
+func (r *commentRepository) FindReplies(rootIDs []string) ([]models.Comment, error) {
+	comments := []models.Comment{}
+	if len(rootIDs) == 0 {
+		return comments, nil
+	}
+
+	err := r.db.Unscoped().Preload("Author", unscopedPreload).
+		Where("root_id IN ?", rootIDs).
+		Order("created_at ASC").
+		Order("id ASC").
+		Find(&comments).Error
+	return comments, err
+}
+
+// Getting one comment by id, deleted or not
+func (r *commentRepository) FindCommentById(id string) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.db.Unscoped().Preload("Author", unscopedPreload).First(&comment, "id = ?", id).Error
+	return &comment, err
+}
+
+func (r *commentRepository) CreateComment(comment *models.Comment) error {
+	return r.db.Omit("Post", "Author", "Parent", "Reactions").Create(comment).Error
+}
+
+func (r *commentRepository) UpdateComment(comment *models.Comment) error {
+	return r.db.Omit("Post", "Author", "Parent", "Reactions").Save(comment).Error
+}
+
+// Soft deleting a comment, its replies stay under the tombstone
+func (r *commentRepository) DeleteComment(comment *models.Comment) error {
+	return r.db.Delete(comment).Error
+}
@@ -0,0 +1,28 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+// CommentPage selects the top level comments of a post, oldest first, deleted ones included
+type CommentPage struct {
+	// Keyset of the last comment of the previous page, the page starts after it
+	After *CommentKeyset
+	Limit int
+}
+
+type CommentKeyset struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+type CommentRepository interface {
+	FindRootComments(postID string, page *CommentPage) ([]models.Comment, error)
+	FindReplies(rootIDs []string) ([]models.Comment, error)
+	FindCommentById(id string) (*models.Comment, error)
+	CreateComment(comment *models.Comment) error
+	UpdateComment(comment *models.Comment) error
+	DeleteComment(comment *models.Comment) error
+}
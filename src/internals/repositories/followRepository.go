@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type followRepository struct {
+	db *gorm.DB
+}
+
+func NewFollowRepository(db *gorm.DB) FollowRepository {
+	return &followRepository{db}
+}
+
+// Following a user, the counters of both users change in the same transaction.
+// Returns false when the follow already existed.
+func (r *followRepository) CreateFollow(followerID, followedID string) (bool, error) {
+	created := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(
+			"INSERT INTO follows (follower_id, followed_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			followerID, followedID, time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		return updateFollowCounters(tx, followerID, followedID, 1)
+	})
+	return created, err
+}
+
+// Unfollowing a user, returns false when there was nothing to remove
+func (r *followRepository) DeleteFollow(followerID, followedID string) (bool, error) {
+	deleted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("follower_id = ? AND followed_id = ?", followerID, followedID).Delete(&models.Follow{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		deleted = true
+		return updateFollowCounters(tx, followerID, followedID, -1)
+	})
+	return deleted, err
+}
+
+// Getting the users following the user, deleted followers and hidden profiles are left out
+func (r *followRepository) FindFollowers(userID string, page *FollowPage) ([]models.Follow, error) {
+	follows := []models.Follow{}
+	query := r.db.Joins("JOIN users ON users.id = follows.follower_id AND users.deleted_at IS NULL AND NOT users.privacy_hidden").
+		Where("follows.followed_id = ?", userID)
+	if page.After != nil {
+		query = query.Where("(follows.created_at, follows.follower_id) < (?, ?)", page.After.CreatedAt, page.After.UserID)
+	}
+	err := query.Preload("Follower").
+		Order("follows.created_at DESC").
+		Order("follows.follower_id DESC").
+		Limit(page.Limit).
+		Find(&follows).Error
+	return follows, err
+}
+
+// Getting the users the user follows, deleted users and hidden profiles are left out
+func (r *followRepository) FindFollowing(userID string, page *FollowPage) ([]models.Follow, error) {
+	follows := []models.Follow{}
+	query := r.db.Joins("JOIN users ON users.id = follows.followed_id AND users.deleted_at IS NULL AND NOT users.privacy_hidden").
+		Where("follows.follower_id = ?", userID)
+	if page.After != nil {
+		query = query.Where("(follows.created_at, follows.followed_id) < (?, ?)", page.After.CreatedAt, page.After.UserID)
+	}
+	err := query.Preload("Followed").
+		Order("follows.created_at DESC").
+		Order("follows.followed_id DESC").
+		Limit(page.Limit).
+		Find(&follows).Error
+	return follows, err
+}
+
+// Recomputing the counters of every user from the follows table, returns how many users were corrected
+func (r *followRepository) ReconcileFollowCounters() (int64, error) {
+	result := r.db.Exec(`UPDATE users SET subscribers = counts.subscribers, followed = counts.followed
+		FROM (SELECT users.id,
+			(SELECT COUNT(*) FROM follows WHERE follows.followed_id = users.id) AS subscribers,
+			(SELECT COUNT(*) FROM follows WHERE follows.follower_id = users.id) AS followed
+			FROM users) AS counts
+		WHERE users.id = counts.id AND (users.subscribers <> counts.subscribers OR users.followed <> counts.followed)`)
+	return result.RowsAffected, result.Error
+}
+
+// updateFollowCounters moves the counters of a follow by delta: the follower follows one more
+// (or less) user and the followed user has one more (or less) subscriber
+func updateFollowCounters(tx *gorm.DB, followerID, followedID string, delta int) error {
+	if err := tx.Exec("UPDATE users SET followed = followed + ? WHERE id = ?", delta, followerID).Error; err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE users SET subscribers = subscribers + ? WHERE id = ?", delta, followedID).Error
+}
@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+// FollowPage selects a page of followers or followed users, newest follows first
+type FollowPage struct {
+	// Keyset of the last follow of the previous page, the page starts after it
+	After *FollowKeyset
+	Limit int
+}
+
+type FollowKeyset struct {
+	CreatedAt time.Time
+	UserID    string
+}
+
+type FollowRepository interface {
+	CreateFollow(followerID, followedID string) (bool, error)
+	DeleteFollow(followerID, followedID string) (bool, error)
+	FindFollowers(userID string, page *FollowPage) ([]models.Follow, error)
+	FindFollowing(userID string, page *FollowPage) ([]models.Follow, error)
+	ReconcileFollowCounters() (int64, error)
+}
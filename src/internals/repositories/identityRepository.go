@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{db}
+}
+
+func (r *identityRepository) CreateIdentity(identity *models.Identity) error {
+	return r.db.Create(identity).Error
+}
+
+// Get the identity of a provider account
+func (r *identityRepository) FindIdentity(provider, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := r.db.First(&identity, "provider = ? AND subject = ?", provider, subject).Error
+	return &identity, err
+}
+
+func (r *identityRepository) FindUserIdentities(userID string) ([]models.Identity, error) {
+	var identities []models.Identity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepository) UpdateIdentity(identity *models.Identity) error {
+	return r.db.Save(identity).Error
+}
+
+// Delete an identity of the user, reports whether one was deleted
+func (r *identityRepository) DeleteIdentity(id, userID string) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Identity{})
+	return result.RowsAffected == 1, result.Error
+}
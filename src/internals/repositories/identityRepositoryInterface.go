@@ -0,0 +1,13 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type IdentityRepository interface {
+	CreateIdentity(identity *models.Identity) error
+	FindIdentity(provider, subject string) (*models.Identity, error)
+	FindUserIdentities(userID string) ([]models.Identity, error)
+	UpdateIdentity(identity *models.Identity) error
+	DeleteIdentity(id, userID string) (bool, error)
+}
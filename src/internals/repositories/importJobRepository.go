@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type importJobRepository struct {
+	db *gorm.DB
+}
+
+func NewImportJobRepository(db *gorm.DB) ImportJobRepository {
+	return &importJobRepository{db}
+}
+
+func (r *importJobRepository) CreateImportJob(job *models.ImportJob) error {
+	return r.db.Create(job).Error
+}
+
+func (r *importJobRepository) FindImportJobById(id string) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := r.db.First(&job, "id = ?", id).Error
+	return &job, err
+}
+
+func (r *importJobRepository) UpdateImportJob(job *models.ImportJob) error {
+	return r.db.Save(job).Error
+}
+
+// Failing the jobs left pending or running, their files are gone with the previous process
+func (r *importJobRepository) FailUnfinishedImportJobs(reason string) (int64, error) {
+	result := r.db.Model(&models.ImportJob{}).
+		Where("status IN ?", []string{models.ImportJobPending, models.ImportJobRunning}).
+		Updates(map[string]any{"status": models.ImportJobFailed, "error": reason, "finished_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
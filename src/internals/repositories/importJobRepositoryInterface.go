@@ -0,0 +1,12 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type ImportJobRepository interface {
+	CreateImportJob(job *models.ImportJob) error
+	FindImportJobById(id string) (*models.ImportJob, error)
+	UpdateImportJob(job *models.ImportJob) error
+	FailUnfinishedImportJobs(reason string) (int64, error)
+}
@@ -0,0 +1,37 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type loginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db}
+}
+
+func (r *loginAttemptRepository) CreateLoginAttempt(attempt *models.LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+// Getting the latest login attempts, empty filters are ignored
+func (r *loginAttemptRepository) FindLoginAttempts(username, ip string, success *bool, limit int) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+
+	query := r.db.Order("created_at DESC").Limit(limit)
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if success != nil {
+		query = query.Where("success = ?", *success)
+	}
+
+	err := query.Find(&attempts).Error
+	return attempts, err
+}
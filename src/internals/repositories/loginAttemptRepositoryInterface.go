@@ -0,0 +1,10 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type LoginAttemptRepository interface {
+	CreateLoginAttempt(attempt *models.LoginAttempt) error
+	FindLoginAttempts(username, ip string, success *bool, limit int) ([]models.LoginAttempt, error)
+}
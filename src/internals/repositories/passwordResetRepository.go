@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type passwordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &passwordResetRepository{db}
+}
+
+func (r *passwordResetRepository) CreatePasswordReset(reset *models.PasswordReset) error {
+	return r.db.Create(reset).Error
+}
+
+func (r *passwordResetRepository) FindPasswordResetByHash(tokenHash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	err := r.db.First(&reset, "token_hash = ?", tokenHash).Error
+	return &reset, err
+}
+
+// Marking the reset as used and setting the new password of its user in one transaction,
+// reports false if another request used it first. The other pending resets of the user are
+// invalidated and the token version goes up so the tokens signed before stop working.
+func (r *passwordResetRepository) UsePasswordReset(reset *models.PasswordReset, hashedPassword string) (bool, error) {
+	used := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordReset{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		used = true
+
+		if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).
+			Updates(map[string]interface{}{"password": hashedPassword, "token_version": gorm.Expr("token_version + 1")}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", time.Now()).Error
+	})
+	return used && err == nil, err
+}
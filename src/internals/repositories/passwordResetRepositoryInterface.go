@@ -0,0 +1,11 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type PasswordResetRepository interface {
+	CreatePasswordReset(reset *models.PasswordReset) error
+	FindPasswordResetByHash(tokenHash string) (*models.PasswordReset, error)
+	UsePasswordReset(reset *models.PasswordReset, hashedPassword string) (bool, error)
+}
@@ -0,0 +1,207 @@
+package repositories
+
+import (
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postRepository struct {
+	db *gorm.DB
+}
+
+func NewPostRepository(db *gorm.DB) PostRepository {
+	return &postRepository{db}
+}
+
+// Getting one page of posts, the deleted authors are left out
+func (r *postRepository) FindPosts(filter *PostFilter) ([]models.Post, error) {
+	posts := []models.Post{}
+
+	query := r.db.Model(&models.Post{}).Preload("Author").Preload("Category").Preload("Tags").
+		Joins("JOIN users ON users.id = posts.author_id AND users.deleted_at IS NULL")
+	if filter.Deleted {
+		query = query.Unscoped().Where("posts.deleted_at IS NOT NULL")
+	}
+	if filter.AuthorID != "" {
+		query = query.Where("posts.author_id = ?", filter.AuthorID)
+	}
+	if filter.Status != "" {
+		query = query.Where("posts.status = ?", filter.Status)
+	}
+	if filter.CategoryID != "" {
+		query = query.Where("posts.category_id = ?", filter.CategoryID)
+	}
+	if filter.TagID != "" {
+		query = query.Joins("JOIN post_tags ON post_tags.post_id = posts.id AND post_tags.tag_id = ?", filter.TagID)
+	}
+	if filter.After != nil {
+		query = query.Where("(posts."+filter.SortColumn+", posts.id) < (?, ?)", filter.After.Time, filter.After.ID)
+	}
+
+	err := query.Order("posts." + filter.SortColumn + " DESC").
+		Order("posts.id DESC").
+		Limit(filter.Limit).
+		Find(&posts).Error
+	return posts, err
+}
+
+// Getting one post by id, deleted or not, with its author even if deleted
+func (r *postRepository) FindPostById(id string) (*models.Post, error) {
+	var post models.Post
+	err := r.db.Unscoped().Preload("Author", unscopedPreload).Preload("Category").Preload("Tags").First(&post, "id = ?", id).Error
+	return &post, err
+}
+
+func (r *postRepository) FindPostBySlug(slug string) (*models.Post, error) {
+	var post models.Post
+	err := r.db.Unscoped().Preload("Author", unscopedPreload).Preload("Category").Preload("Tags").First(&post, "slug = ?", slug).Error
+	return &post, err
+}
+
+func unscopedPreload(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// Creating a post with its tags, a published one counts for its author and its tags in the same transaction
+func (r *postRepository) CreatePost(post *models.Post) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Author", "Category", "Reactions", "Tags.*").Create(post).Error; err != nil {
+			return err
+		}
+		return updatePostCounter(tx, post, countDelta(false, post.IsCounted()))
+	})
+}
+
+// Saving a post, the counters of its author and its tags follow when it is published or unpublished.
+// The tags are replaced when tags is not nil. A post deleted meanwhile is not found.
+func (r *postRepository) UpdatePost(post *models.Post, tags []models.Tag) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockPost(tx, post.ID)
+		if err != nil {
+			return err
+		}
+		if locked.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		wasCounted := locked.IsCounted()
+
+		if err := tx.Omit("Author", "Category", "Reactions", "Tags").Save(post).Error; err != nil {
+			return err
+		}
+		if tags == nil {
+			return updatePostCounter(tx, post, countDelta(wasCounted, post.IsCounted()))
+		}
+
+		// The old tags lose the post and the new ones get it
+		if wasCounted {
+			if err := updateTagCounts(tx, post, -1); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(post).Omit("Tags.*").Association("Tags").Replace(tags); err != nil {
+			return err
+		}
+		if post.IsCounted() {
+			if err := updateTagCounts(tx, post, 1); err != nil {
+				return err
+			}
+		}
+		return updateAuthorCounter(tx, post, countDelta(wasCounted, post.IsCounted()))
+	})
+}
+
+// Deleting a post softly or for good, it no longer counts for its author and its tags
+func (r *postRepository) DeletePost(force bool, post *models.Post) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockPost(tx, post.ID)
+		if err != nil {
+			return err
+		}
+
+		// Counted first, the tags of the post go with it when it is deleted for good
+		if err := updatePostCounter(tx, post, countDelta(locked.IsCounted(), false)); err != nil {
+			return err
+		}
+		query := tx
+		if force {
+			query = tx.Unscoped()
+		}
+		return query.Delete(post).Error
+	})
+}
+
+// Restoring a soft deleted post, restoring it twice counts it once
+func (r *postRepository) RestorePost(post *models.Post) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockPost(tx, post.ID)
+		if err != nil {
+			return err
+		}
+		post.DeletedAt = gorm.DeletedAt{}
+		if !locked.DeletedAt.Valid {
+			return nil
+		}
+
+		if err := tx.Unscoped().Model(post).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return updatePostCounter(tx, post, countDelta(false, post.IsCounted()))
+	})
+}
+
+// Recomputing the published_posts counter of every user from the posts, returns how many were wrong
+func (r *postRepository) ReconcilePostCounters() (int64, error) {
+	result := r.db.Exec(`UPDATE users SET published_posts = counts.published_posts
+		FROM (SELECT users.id,
+			(SELECT COUNT(*) FROM posts WHERE posts.author_id = users.id AND posts.status = ? AND posts.deleted_at IS NULL) AS published_posts
+			FROM users) AS counts
+		WHERE users.id = counts.id AND users.published_posts <> counts.published_posts`, models.PostPublished)
+	return result.RowsAffected, result.Error
+}
+
+// lockPost reads the post again and locks its row until the end of the transaction. Counters move from
+// this state and not from the one read before the transaction, which a concurrent change may have outdated.
+func lockPost(tx *gorm.DB, id uuid.UUID) (*models.Post, error) {
+	var post models.Post
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "status", "deleted_at").First(&post, "id = ?", id).Error
+	return &post, err
+}
+
+// countDelta is how the counter moves when a post goes from counted or not to counted or not
+func countDelta(wasCounted, isCounted bool) int {
+	switch {
+	case isCounted && !wasCounted:
+		return 1
+	case wasCounted && !isCounted:
+		return -1
+	}
+	return 0
+}
+
+// updatePostCounter moves the published_posts counter of the author and the posts count of the tags by delta
+func updatePostCounter(tx *gorm.DB, post *models.Post, delta int) error {
+	if err := updateAuthorCounter(tx, post, delta); err != nil {
+		return err
+	}
+	return updateTagCounts(tx, post, delta)
+}
+
+func updateAuthorCounter(tx *gorm.DB, post *models.Post, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Exec("UPDATE users SET published_posts = published_posts + ? WHERE id = ?", delta, post.AuthorID).Error
+}
+
+// updateTagCounts leaves the tags alone when the author is deleted, their posts are not counted.
+// The row of the author is share locked so that they are not deleted or restored meanwhile.
+func updateTagCounts(tx *gorm.DB, post *models.Post, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Exec(`UPDATE tags SET posts_count = posts_count + ? WHERE id IN (SELECT tag_id FROM post_tags WHERE post_id = ?)
+		AND EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL FOR SHARE)`, delta, post.ID, post.AuthorID).Error
+}
@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+// PostFilter selects one page of posts, newest first. Only the posts of active authors are listed.
+type PostFilter struct {
+	AuthorID string
+	Status   string
+	// Only the posts filed under the category or labelled with the tag when not empty
+	CategoryID string
+	TagID      string
+	// Lists the soft deleted posts instead of the others
+	Deleted bool
+	// Column the posts are sorted by, published_at or created_at, the ID breaks ties
+	SortColumn string
+	// Keyset of the last post of the previous page, the list starts after it
+	After *PostKeyset
+	Limit int
+}
+
+type PostKeyset struct {
+	Time time.Time
+	ID   string
+}
+
+type PostRepository interface {
+	FindPosts(filter *PostFilter) ([]models.Post, error)
+	FindPostById(id string) (*models.Post, error)
+	FindPostBySlug(slug string) (*models.Post, error)
+	CreatePost(post *models.Post) error
+	UpdatePost(post *models.Post, tags []models.Tag) error
+	DeletePost(force bool, post *models.Post) error
+	RestorePost(post *models.Post) error
+	ReconcilePostCounters() (int64, error)
+}
@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type reactionRepository struct {
+	db *gorm.DB
+}
+
+func NewReactionRepository(db *gorm.DB) ReactionRepository {
+	return &reactionRepository{db}
+}
+
+// Reacting to a post or a comment, its counts change in the same transaction.
+// Returns false when the user already reacted with this type.
+func (r *reactionRepository) CreateReaction(target ReactionTarget, targetID, userID, reactionType string) (bool, error) {
+	created := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(
+			"INSERT INTO "+target.Table+" ("+target.Column+", user_id, type, created_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			targetID, userID, reactionType, time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		return updateReactionCount(tx, target, targetID, reactionType, 1)
+	})
+	return created, err
+}
+
+// Removing a reaction, returns false when there was nothing to remove
+func (r *reactionRepository) DeleteReaction(target ReactionTarget, targetID, userID, reactionType string) (bool, error) {
+	deleted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(
+			"DELETE FROM "+target.Table+" WHERE "+target.Column+" = ? AND user_id = ? AND type = ?",
+			targetID, userID, reactionType)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		deleted = true
+		return updateReactionCount(tx, target, targetID, reactionType, -1)
+	})
+	return deleted, err
+}
+
+func (r *reactionRepository) FindReactionCounts(target ReactionTarget, targetID string) (models.ReactionCounts, error) {
+	var row struct {
+		Reactions models.ReactionCounts `gorm:"serializer:json"`
+	}
+	err := r.db.Table(target.Counts).Select("reactions").Where("id = ?", targetID).Take(&row).Error
+	return row.Reactions, err
+}
+
+// Getting the users who reacted, deleted users are left out
+func (r *reactionRepository) FindReactors(target ReactionTarget, targetID string, page *ReactionPage) ([]Reactor, error) {
+	reactors := []Reactor{}
+	query := r.db.Table(target.Table+" AS reactions").
+		Select("users.id AS user_id, users.username, users.image, reactions.type, reactions.created_at").
+		Joins("JOIN users ON users.id = reactions.user_id AND users.deleted_at IS NULL").
+		Where("reactions."+target.Column+" = ?", targetID)
+	if page.Type != "" {
+		query = query.Where("reactions.type = ?", page.Type)
+	}
+	if page.After != nil {
+		query = query.Where("(reactions.created_at, reactions.user_id, reactions.type) < (?, ?, ?)",
+			page.After.CreatedAt, page.After.UserID, page.After.Type)
+	}
+	err := query.Order("reactions.created_at DESC").
+		Order("reactions.user_id DESC").
+		Order("reactions.type DESC").
+		Limit(page.Limit).
+		Scan(&reactors).Error
+	return reactors, err
+}
+
+// Recomputing the reaction counts of every post and comment, returns how many were corrected
+func (r *reactionRepository) ReconcileReactionCounts() (int64, error) {
+	var corrected int64
+	for _, target := range []ReactionTarget{PostReactions, CommentReactions} {
+		result := r.db.Exec("UPDATE " + target.Counts + " SET reactions = " + reactionCountsSQL(target, "") +
+			" WHERE reactions <> " + reactionCountsSQL(target, ""))
+		if result.Error != nil {
+			return corrected, result.Error
+		}
+		corrected += result.RowsAffected
+	}
+	return corrected, nil
+}
+
+// reactionCountsSQL aggregates the reactions of the current row of the counts table into
+// a JSON object of counts by type, leaving out the reactions matching exclude if given
+func reactionCountsSQL(target ReactionTarget, exclude string) string {
+	where := fmt.Sprintf("%s.%s = %s.id", target.Table, target.Column, target.Counts)
+	if exclude != "" {
+		where += " AND NOT (" + exclude + ")"
+	}
+	return fmt.Sprintf("COALESCE((SELECT jsonb_object_agg(type, count) FROM (SELECT type, COUNT(*) AS count FROM %s WHERE %s GROUP BY type) AS counts), '{}')",
+		target.Table, where)
+}
+
+// recountReactionsWithoutUser recomputes the counts of everything the user reacted to as if
+// their reactions were gone, before they are deleted with the user
+func recountReactionsWithoutUser(tx *gorm.DB, userID string) error {
+	for _, target := range []ReactionTarget{PostReactions, CommentReactions} {
+		err := tx.Exec("UPDATE "+target.Counts+" SET reactions = "+reactionCountsSQL(target, target.Table+".user_id = @user")+
+			" WHERE id IN (SELECT "+target.Column+" FROM "+target.Table+" WHERE user_id = @user)",
+			map[string]interface{}{"user": userID}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateReactionCount moves the count of one reaction type by delta, the row lock
+// taken by the update keeps concurrent reactions from losing counts. A type whose count
+// drops to zero is removed, as it is missing from the counts recomputed by the reconciliation.
+func updateReactionCount(tx *gorm.DB, target ReactionTarget, targetID, reactionType string, delta int) error {
+	return tx.Exec("UPDATE "+target.Counts+" SET reactions = CASE WHEN COALESCE((reactions->>@type)::bigint, 0) + @delta > 0"+
+		" THEN jsonb_set(reactions, ARRAY[CAST(@type AS text)], to_jsonb(COALESCE((reactions->>@type)::bigint, 0) + @delta))"+
+		" ELSE reactions - CAST(@type AS text) END WHERE id = @id",
+		map[string]interface{}{"type": reactionType, "delta": delta, "id": targetID}).Error
+}
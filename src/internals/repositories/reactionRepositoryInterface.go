@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+// ReactionTarget is what reactions are given to: the table of the reactions, its column
+// holding the reacted ID and the table whose reactions column holds the counts
+type ReactionTarget struct {
+	Table  string
+	Column string
+	Counts string
+}
+
+var (
+	PostReactions    = ReactionTarget{"post_reactions", "post_id", "posts"}
+	CommentReactions = ReactionTarget{"comment_reactions", "comment_id", "comments"}
+)
+
+// ReactionPage selects a page of the users who reacted, newest reactions first
+type ReactionPage struct {
+	// Only the reactions of this type when not empty
+	Type string
+	// Keyset of the last reaction of the previous page, the page starts after it
+	After *ReactionKeyset
+	Limit int
+}
+
+type ReactionKeyset struct {
+	CreatedAt time.Time
+	UserID    string
+	Type      string
+}
+
+// Reactor is a user who reacted, with the type of their reaction
+type Reactor struct {
+	UserID    string
+	Username  string
+	Image     string
+	Type      string
+	CreatedAt time.Time
+}
+
+type ReactionRepository interface {
+	CreateReaction(target ReactionTarget, targetID, userID, reactionType string) (bool, error)
+	DeleteReaction(target ReactionTarget, targetID, userID, reactionType string) (bool, error)
+	FindReactionCounts(target ReactionTarget, targetID string) (models.ReactionCounts, error)
+	FindReactors(target ReactionTarget, targetID string, page *ReactionPage) ([]Reactor, error)
+	ReconcileReactionCounts() (int64, error)
+}
@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeReactionDB stands in for Postgres behind the reaction repository, no database is needed.
+// It runs the statements of the repository on the reaction tables and the counts kept in
+// posts and comments, and fails on any other statement. Postgres serializes the transactions
+// reacting to one target with the row lock of the count update, the fake serializes all of them.
+type fakeReactionDB struct {
+	mu sync.Mutex
+	// Keys of the reactions, table|target|user|type
+	reactions map[string]bool
+	// Counts by table of the counts, then target ID
+	counts map[string]map[string]map[string]int64
+}
+
+func newFakeReactionDB(postIDs ...string) *fakeReactionDB {
+	db := &fakeReactionDB{
+		reactions: make(map[string]bool),
+		counts:    map[string]map[string]map[string]int64{"posts": {}, "comments": {}},
+	}
+	for _, id := range postIDs {
+		db.counts["posts"][id] = map[string]int64{}
+	}
+	return db
+}
+
+func (db *fakeReactionDB) open(t *testing.T) ReactionRepository {
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(db)}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewReactionRepository(gormDB)
+}
+
+func (db *fakeReactionDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeReactionConn{db: db}, nil
+}
+func (db *fakeReactionDB) Driver() driver.Driver { return nil }
+
+// clone copies the state, to be restored when a transaction is rolled back
+func (db *fakeReactionDB) clone() *fakeReactionDB {
+	c := newFakeReactionDB()
+	for key := range db.reactions {
+		c.reactions[key] = true
+	}
+	for table, rows := range db.counts {
+		for id, counts := range rows {
+			c.counts[table][id] = map[string]int64{}
+			for reactionType, n := range counts {
+				c.counts[table][id][reactionType] = n
+			}
+		}
+	}
+	return c
+}
+
+// actualCounts counts the reactions of a target by type, types without reactions are missing
+func (db *fakeReactionDB) actualCounts(target ReactionTarget, id string) map[string]int64 {
+	counts := map[string]int64{}
+	prefix := target.Table + "|" + id + "|"
+	for key := range db.reactions {
+		if strings.HasPrefix(key, prefix) {
+			counts[key[strings.LastIndexByte(key, '|')+1:]]++
+		}
+	}
+	return counts
+}
+
+func (db *fakeReactionDB) exec(query string, args []driver.NamedValue) (int64, error) {
+	if strings.Contains(query, "@") {
+		return 0, fmt.Errorf("named argument left in the statement: %s", query)
+	}
+	arg := func(i int) string { return fmt.Sprint(args[i].Value) }
+	for _, target := range []ReactionTarget{PostReactions, CommentReactions} {
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO "+target.Table+" ") && strings.HasSuffix(query, "ON CONFLICT DO NOTHING"):
+			key := strings.Join([]string{target.Table, arg(0), arg(1), arg(2)}, "|")
+			if db.reactions[key] {
+				return 0, nil
+			}
+			db.reactions[key] = true
+			return 1, nil
+
+		case strings.HasPrefix(query, "DELETE FROM "+target.Table+" WHERE "+target.Column+" = $1 AND user_id = $2 AND type = $3"):
+			key := strings.Join([]string{target.Table, arg(0), arg(1), arg(2)}, "|")
+			if !db.reactions[key] {
+				return 0, nil
+			}
+			delete(db.reactions, key)
+			return 1, nil
+
+		case strings.HasPrefix(query, "UPDATE "+target.Counts+" SET reactions = ") && strings.Contains(query, "jsonb_set"):
+			// The type comes first and the target ID last, the delta is the only number
+			reactionType, id := arg(0), arg(len(args)-1)
+			var delta int64
+			for _, a := range args {
+				if n, ok := a.Value.(int64); ok {
+					delta = n
+				}
+			}
+			counts, ok := db.counts[target.Counts][id]
+			if !ok {
+				return 0, nil
+			}
+			// jsonb_set alone keeps a count of zero, removing the type takes the - operator
+			if counts[reactionType]+delta <= 0 && strings.Contains(query, "ELSE reactions - ") {
+				delete(counts, reactionType)
+			} else {
+				counts[reactionType] += delta
+			}
+			return 1, nil
+
+		case strings.HasPrefix(query, "UPDATE "+target.Counts+" SET reactions = COALESCE(") && len(args) == 0:
+			// Reconciliation, jsonb values differ when a type is missing from one of them
+			var corrected int64
+			for id, counts := range db.counts[target.Counts] {
+				if actual := db.actualCounts(target, id); !reflect.DeepEqual(counts, actual) {
+					db.counts[target.Counts][id] = actual
+					corrected++
+				}
+			}
+			return corrected, nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected statement: %s", query)
+}
+
+type fakeReactionConn struct {
+	db *fakeReactionDB
+	// State before the open transaction, nil outside of one
+	before *fakeReactionDB
+}
+
+func (c *fakeReactionConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.before == nil {
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+	}
+	affected, err := c.db.exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *fakeReactionConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.before = c.db.clone()
+	return c, nil
+}
+
+func (c *fakeReactionConn) Commit() error {
+	c.before = nil
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *fakeReactionConn) Rollback() error {
+	c.db.reactions, c.db.counts = c.before.reactions, c.before.counts
+	c.before = nil
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *fakeReactionConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("statements are not prepared")
+}
+
+func (c *fakeReactionConn) Close() error { return nil }
+
+func TestConcurrentReactionsKeepCountsReconciled(t *testing.T) {
+	const postID = "6f1c2a8e-0b5d-4c3e-9a7f-1d2e3f4a5b6c"
+	db := newFakeReactionDB(postID)
+	repo := db.open(t)
+
+	// Every user adds and removes the same reactions at once, some of them twice
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for u := 0; u < 10; u++ {
+		userID := fmt.Sprintf("00000000-0000-0000-0000-%012d", u)
+		for i := 0; i < 4; i++ {
+			for _, reactionType := range []string{"like", "love"} {
+				wg.Add(2)
+				go func(reactionType string) {
+					defer wg.Done()
+					if _, err := repo.CreateReaction(PostReactions, postID, userID, reactionType); err != nil {
+						errs <- err
+					}
+				}(reactionType)
+				go func(reactionType string) {
+					defer wg.Done()
+					if _, err := repo.DeleteReaction(PostReactions, postID, userID, reactionType); err != nil {
+						errs <- err
+					}
+				}(reactionType)
+			}
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	counts := db.counts["posts"][postID]
+	if actual := db.actualCounts(PostReactions, postID); !reflect.DeepEqual(counts, actual) {
+		t.Fatalf("the post counts %v reactions, it has %v", counts, actual)
+	}
+	corrected, err := repo.ReconcileReactionCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrected != 0 {
+		t.Errorf("the reconciliation corrected %d rows, want 0", corrected)
+	}
+}
+
+func TestRemovingLastReactionLeavesNothingToReconcile(t *testing.T) {
+	const postID = "0e6a4f1b-7c2d-4b8e-a5f9-3c1d2e4f6a7b"
+	db := newFakeReactionDB(postID)
+	repo := db.open(t)
+	userID := "00000000-0000-0000-0000-000000000001"
+
+	if created, err := repo.CreateReaction(PostReactions, postID, userID, "like"); err != nil || !created {
+		t.Fatalf("reacting returned %v, %v", created, err)
+	}
+	if created, err := repo.CreateReaction(PostReactions, postID, userID, "like"); err != nil || created {
+		t.Fatalf("reacting twice returned %v, %v", created, err)
+	}
+	if got := db.counts["posts"][postID]; !reflect.DeepEqual(got, map[string]int64{"like": 1}) {
+		t.Fatalf("the post counts %v", got)
+	}
+
+	if deleted, err := repo.DeleteReaction(PostReactions, postID, userID, "like"); err != nil || !deleted {
+		t.Fatalf("removing the reaction returned %v, %v", deleted, err)
+	}
+	if deleted, err := repo.DeleteReaction(PostReactions, postID, userID, "like"); err != nil || deleted {
+		t.Fatalf("removing the reaction twice returned %v, %v", deleted, err)
+	}
+	// The type is gone from the counts, as it is from the counts recomputed by the reconciliation
+	if got := db.counts["posts"][postID]; len(got) != 0 {
+		t.Fatalf("the post counts %v once its reaction is removed", got)
+	}
+	if corrected, err := repo.ReconcileReactionCounts(); err != nil || corrected != 0 {
+		t.Errorf("the reconciliation returned %d, %v, want 0 rows corrected", corrected, err)
+	}
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{db}
+}
+
+func (r *roleRepository) FindPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+	err := r.db.Order("name").Find(&permissions).Error
+	return permissions, err
+}
+
+func (r *roleRepository) FindPermissionsByName(names []string) ([]models.Permission, error) {
+	var permissions []models.Permission
+	err := r.db.Where("name IN ?", names).Find(&permissions).Error
+	return permissions, err
+}
+
+func (r *roleRepository) FindRoles() ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) FindRoleById(id string) (*models.Role, error) {
+	var role models.Role
+	err := r.db.Preload("Permissions").First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (r *roleRepository) FindRolesByName(names []string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").Where("name IN ?", names).Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) CreateRole(role *models.Role) error {
+	return r.db.Omit("Permissions.*").Create(role).Error
+}
+
+// Save the role and replace its permissions in one transaction
+func (r *roleRepository) UpdateRole(role *models.Role, permissions []models.Permission) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Permissions").Save(role).Error; err != nil {
+			return err
+		}
+		return tx.Model(role).Omit("Permissions.*").Association("Permissions").Replace(permissions)
+	})
+}
+
+// Delete the role together with the links to its permissions
+func (r *roleRepository) DeleteRole(role *models.Role) error {
+	return r.db.Select("Permissions").Delete(role).Error
+}
+
+// Count the users holding the role, soft deleted ones included
+func (r *roleRepository) CountRoleUsers(roleID string) (int64, error) {
+	var count int64
+	err := r.db.Table("user_roles").Where("role_id = ?", roleID).Count(&count).Error
+	return count, err
+}
+
+// Get the roles and their permissions of an active user
+func (r *roleRepository) FindUserRoles(userID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Joins("JOIN users ON users.id = user_roles.user_id").
+		Where("users.id = ? AND users.deleted_at IS NULL", userID).
+		Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) ReplaceUserRoles(user *models.User, roles []models.Role) error {
+	return r.db.Model(user).Omit("Roles.*").Association("Roles").Replace(roles)
+}
@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type RoleRepository interface {
+	FindPermissions() ([]models.Permission, error)
+	FindPermissionsByName(names []string) ([]models.Permission, error)
+	FindRoles() ([]models.Role, error)
+	FindRoleById(id string) (*models.Role, error)
+	FindRolesByName(names []string) ([]models.Role, error)
+	CreateRole(role *models.Role) error
+	UpdateRole(role *models.Role, permissions []models.Permission) error
+	DeleteRole(role *models.Role) error
+	CountRoleUsers(roleID string) (int64, error)
+	FindUserRoles(userID string) ([]models.Role, error)
+	ReplaceUserRoles(user *models.User, roles []models.Role) error
+}
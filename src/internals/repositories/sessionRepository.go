@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+)
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db}
+}
+
+func (r *sessionRepository) CreateSession(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+// Get one specific session by id, revoked and expired sessions included
+func (r *sessionRepository) FindSessionById(id string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.First(&session, "id = ?", id).Error
+	return &session, err
+}
+
+// Getting the sessions of a user that are neither revoked nor expired, most recently used first
+func (r *sessionRepository) FindActiveSessions(userID string) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *sessionRepository) UpdateSession(session *models.Session) error {
+	return r.db.Save(session).Error
+}
+
+func (r *sessionRepository) TouchSession(id string, seenAt time.Time) error {
+	return r.db.Model(&models.Session{}).Where("id = ?", id).Update("last_seen_at", seenAt).Error
+}
+
+// Pushing the expiry of a session unless it was revoked meanwhile, reports whether it was extended
+func (r *sessionRepository) ExtendSession(id string, seenAt, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"last_seen_at": seenAt, "expires_at": expiresAt})
+	return result.RowsAffected == 1, result.Error
+}
+
+// Revoking every active session of a user but the excepted ones, returns the ids of the sessions revoked
+func (r *sessionRepository) RevokeUserSessions(userID string, except ...string) ([]string, error) {
+	var ids []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+		if len(except) > 0 {
+			query = query.Where("id NOT IN ?", except)
+		}
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&models.Session{}).
+			Where("id IN ?", ids).
+			Update("revoked_at", time.Now()).Error
+	})
+	return ids, err
+}
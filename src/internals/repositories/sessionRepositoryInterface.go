@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/timebetov/readerblog/internals/models"
+)
+
+type SessionRepository interface {
+	CreateSession(session *models.Session) error
+	FindSessionById(id string) (*models.Session, error)
+	FindActiveSessions(userID string) ([]models.Session, error)
+	UpdateSession(session *models.Session) error
+	TouchSession(id string, seenAt time.Time) error
+	ExtendSession(id string, seenAt, expiresAt time.Time) (bool, error)
+	RevokeUserSessions(userID string, except ...string) ([]string, error)
+}
@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type tagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) TagRepository {
+	return &tagRepository{db}
+}
+
+// Getting the tags with the given slugs, the missing ones are created with the given names.
+// The tags are returned in the given order.
+func (r *tagRepository) FindOrCreateTags(tags []models.Tag) ([]models.Tag, error) {
+	if len(tags) == 0 {
+		return []models.Tag{}, nil
+	}
+	if err := r.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "slug"}}, DoNothing: true}).
+		Omit("PostsCount").Create(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		slugs = append(slugs, tag.Slug)
+	}
+	var found []models.Tag
+	if err := r.db.Where("slug IN ?", slugs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	bySlug := make(map[string]models.Tag, len(found))
+	for _, tag := range found {
+		bySlug[tag.Slug] = tag
+	}
+	ordered := make([]models.Tag, 0, len(slugs))
+	for _, slug := range slugs {
+		ordered = append(ordered, bySlug[slug])
+	}
+	return ordered, nil
+}
+
+func (r *tagRepository) FindTagBySlug(slug string) (*models.Tag, error) {
+	var tag models.Tag
+	err := r.db.First(&tag, "slug = ?", slug).Error
+	return &tag, err
+}
+
+// Getting the most used tags, only the ones whose slug starts with the prefix if given
+func (r *tagRepository) FindTags(slugPrefix string, limit int) ([]models.Tag, error) {
+	tags := []models.Tag{}
+	query := r.db.Model(&models.Tag{})
+	if slugPrefix != "" {
+		query = query.Where("slug LIKE ?", slugPrefix+"%")
+	}
+	err := query.Order("posts_count DESC").Order("slug ASC").Limit(limit).Find(&tags).Error
+	return tags, err
+}
+
+// Saving the name and the slug of a tag, the count is left to the posts
+func (r *tagRepository) UpdateTag(tag *models.Tag) error {
+	return r.db.Model(tag).Select("Name", "Slug").Updates(tag).Error
+}
+
+// Moving the posts of the source tag to the target one and deleting the source,
+// the count of the target is recomputed as posts may have had both tags
+func (r *tagRepository) MergeTags(source, target *models.Tag) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, ? FROM post_tags WHERE tag_id = ? ON CONFLICT DO NOTHING`, target.ID, source.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(source).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE tags SET posts_count = "+tagCountSQL+" WHERE id = ?", models.PostPublished, target.ID).Error; err != nil {
+			return err
+		}
+		return tx.First(target, "id = ?", target.ID).Error
+	})
+}
+
+// Recomputing the posts count of every tag, returns how many were wrong
+func (r *tagRepository) ReconcileTagCounts() (int64, error) {
+	result := r.db.Exec("UPDATE tags SET posts_count = "+tagCountSQL+" WHERE posts_count <> "+tagCountSQL,
+		models.PostPublished, models.PostPublished)
+	return result.RowsAffected, result.Error
+}
+
+// tagCountSQL counts the published posts, not deleted, of the current row of tags. The posts of
+// deleted authors are left out, as they are from the tag pages.
+const tagCountSQL = `(SELECT COUNT(*) FROM post_tags JOIN posts ON posts.id = post_tags.post_id
+	JOIN users ON users.id = posts.author_id AND users.deleted_at IS NULL
+	WHERE post_tags.tag_id = tags.id AND posts.status = ? AND posts.deleted_at IS NULL)`
+
+// updateAuthorsTagCounts moves the posts count of the tags by delta for every published post
+// of the authors, when they are deleted or restored
+func updateAuthorsTagCounts(tx *gorm.DB, authorIDs interface{}, delta int) error {
+	return tx.Exec(`UPDATE tags SET posts_count = posts_count + ? * counts.posts_count
+		FROM (SELECT post_tags.tag_id, COUNT(*) AS posts_count FROM post_tags
+			JOIN posts ON posts.id = post_tags.post_id
+			WHERE posts.author_id IN ? AND posts.status = ? AND posts.deleted_at IS NULL
+			GROUP BY post_tags.tag_id) AS counts
+		WHERE tags.id = counts.tag_id`, delta, authorIDs, models.PostPublished).Error
+}
@@ -0,0 +1,12 @@
+package repositories
+
+import "github.com/timebetov/readerblog/internals/models"
+
+type TagRepository interface {
+	FindOrCreateTags(tags []models.Tag) ([]models.Tag, error)
+	FindTagBySlug(slug string) (*models.Tag, error)
+	FindTags(slugPrefix string, limit int) ([]models.Tag, error)
+	UpdateTag(tag *models.Tag) error
+	MergeTags(source, target *models.Tag) error
+	ReconcileTagCounts() (int64, error)
+}
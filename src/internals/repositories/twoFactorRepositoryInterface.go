@@ -0,0 +1,7 @@
+package repositories
+
+type TwoFactorRepository interface {
+	ReplaceRecoveryCodes(userID string, codeHashes []string) error
+	UseRecoveryCode(userID string, codeHash string) (bool, error)
+	DisableTwoFactor(userID string) error
+}
@@ -1,8 +1,13 @@
 package repositories
 
 import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/timebetov/readerblog/internals/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type userRepository struct {
@@ -17,30 +22,82 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 
 // First method is to create a new User in the database
 func (r *userRepository) CreateUser(user *models.User) error {
-	return r.db.Create(user).Error
+	// Users created without roles get the default ones
+	if len(user.Roles) == 0 {
+		if err := r.db.Where("is_default = ?", true).Find(&user.Roles).Error; err != nil {
+			return err
+		}
+	}
+	// Only the links to the roles are written, never the roles themselves
+	return r.db.Omit("Roles.*").Create(user).Error
 }
 
-// Getting all users from the database
-func (r *userRepository) FindUsers(includeDeleted bool) ([]models.User, error) {
-	var users []models.User
-	var err error
+// Getting one page of users, sorted and filtered
+func (r *userRepository) FindUsers(filter *UserFilter) ([]models.User, error) {
+	users := []models.User{}
 
-	if includeDeleted {
-		// Fetch all users, including the soft-deleted ones
-		err = r.db.Unscoped().Where("deleted_at IS NOT NULL").Find(&users).Error
-	} else {
-		// Fetch all users, excluding the soft-deleted ones
-		err = r.db.Find(&users).Error
+	query := r.filterUsers(filter)
+	if filter.After != nil {
+		// Keyset pagination: the rows sorted after the last one of the previous page
+		operator := ">"
+		if filter.Descending {
+			operator = "<"
+		}
+		query = query.Where("(users."+filter.SortColumn+", users.id) "+operator+" (?, ?)", filter.After.Value, filter.After.ID)
 	}
 
+	direction := " ASC"
+	if filter.Descending {
+		direction = " DESC"
+	}
+	err := query.Preload("Roles").
+		Order("users." + filter.SortColumn + direction).
+		Order("users.id" + direction).
+		Limit(filter.Limit).
+		Find(&users).Error
 	return users, err
 }
 
+// Counting the users matching the filter, the pagination is ignored
+func (r *userRepository) CountUsers(filter *UserFilter) (int64, error) {
+	var count int64
+	err := r.filterUsers(filter).Count(&count).Error
+	return count, err
+}
+
+func (r *userRepository) filterUsers(filter *UserFilter) *gorm.DB {
+	query := r.db.Model(&models.User{})
+	switch filter.Deleted {
+	case OnlyDeleted:
+		query = query.Unscoped().Where("users.deleted_at IS NOT NULL")
+	case IncludeDeleted:
+		query = query.Unscoped()
+	}
+
+	if filter.Role != "" {
+		query = query.Where(`EXISTS (SELECT 1 FROM user_roles JOIN roles ON roles.id = user_roles.role_id
+			WHERE user_roles.user_id = users.id AND roles.name = ?)`, filter.Role)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("users.created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("users.created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.EmailDomain != "" {
+		query = query.Where(`users.email LIKE ? ESCAPE '\'`, "%@"+likeEscaper.Replace(filter.EmailDomain))
+	}
+	return query
+}
+
+// Escaping the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 // Get one specific user by id from the database
 func (r *userRepository) FindUserById(id string) (*models.User, error) {
 	var user models.User
 	// Find the user with the matching ID
-	err := r.db.Unscoped().First(&user, "id = ?", id).Error
+	err := r.db.Unscoped().Preload("Roles").First(&user, "id = ?", id).Error
 	return &user, err
 }
 
@@ -48,25 +105,218 @@ func (r *userRepository) FindUserById(id string) (*models.User, error) {
 func (r *userRepository) FindUserByUsername(username string) (*models.User, error) {
 	var user models.User
 	// Find the user with the matching username
-	err := r.db.First(&user, "username = ?", username).Error
+	err := r.db.Preload("Roles").First(&user, "username = ?", username).Error
 	return &user, err
 }
 
+// Get one specific user by email from the database
+func (r *userRepository) FindUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	// Find the user with the matching email
+	err := r.db.Preload("Roles").First(&user, "email = ?", email).Error
+	return &user, err
+}
+
+// Whether a user holds the username, deleted ones included as they keep it until purged
+func (r *userRepository) UsernameTaken(username string) (bool, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
+
+// Getting the users holding the email or the username, deleted ones included as they keep both
+func (r *userRepository) FindUsersByEmailOrUsername(email, username string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Unscoped().Preload("Roles").Where("email = ? OR username = ?", email, username).Find(&users).Error
+	return users, err
+}
+
+// Getting the users of a batch, deleted ones included
+func (r *userRepository) FindUsersByIds(ids []string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Unscoped().Preload("Roles").Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+// Applying one change to every user of a batch in a single transaction, the deleted
+// users and the users getting new roles have their access tokens outdated
+func (r *userRepository) ApplyBatch(change UserBatchChange, ids []string, roles []models.Role) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	roleIDs := make([]uuid.UUID, 0, len(roles))
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		users := tx.Unscoped().Model(&models.User{}).Where("id IN ?", ids)
+
+		switch change {
+		case BatchDelete:
+			// The posts of the deleted users no longer count for their tags
+			deleted, err := lockUserIDs(tx, ids, "deleted_at IS NULL")
+			if err != nil {
+				return err
+			}
+			if len(deleted) > 0 {
+				if err := users.Session(&gorm.Session{}).Where("id IN ?", deleted).
+					Updates(map[string]any{"deleted_at": time.Now(), "purge_at": nil}).Error; err != nil {
+					return err
+				}
+				if err := updateAuthorsTagCounts(tx, deleted, -1); err != nil {
+					return err
+				}
+			}
+		case BatchRestore:
+			restored, err := lockUserIDs(tx, ids, "deleted_at IS NOT NULL")
+			if err != nil {
+				return err
+			}
+			if err := users.Updates(map[string]any{"deleted_at": nil, "purge_at": nil}).Error; err != nil {
+				return err
+			}
+			if len(restored) == 0 {
+				return nil
+			}
+			return updateAuthorsTagCounts(tx, restored, 1)
+		case BatchSetRoles:
+			if err := tx.Exec("DELETE FROM user_roles WHERE user_id IN ?", ids).Error; err != nil {
+				return err
+			}
+			if err := insertUserRoles(tx, ids, roleIDs); err != nil {
+				return err
+			}
+		case BatchAddRoles:
+			if err := insertUserRoles(tx, ids, roleIDs); err != nil {
+				return err
+			}
+		case BatchRemoveRoles:
+			if err := tx.Exec("DELETE FROM user_roles WHERE user_id IN ? AND role_id IN ?", ids, roleIDs).Error; err != nil {
+				return err
+			}
+		}
+
+		return users.Session(&gorm.Session{}).UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+	})
+}
+
+// lockUserIDs locks the users matching the condition until the end of the transaction and returns their IDs
+func lockUserIDs(tx *gorm.DB, ids []string, condition string) ([]string, error) {
+	var locked []string
+	err := tx.Unscoped().Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", ids).Where(condition).Pluck("id", &locked).Error
+	return locked, err
+}
+
+// Linking every user to every role, existing links are kept
+func insertUserRoles(tx *gorm.DB, userIDs []string, roleIDs []uuid.UUID) error {
+	if len(roleIDs) == 0 {
+		return nil
+	}
+	links := make([]map[string]any, 0, len(userIDs)*len(roleIDs))
+	for _, userID := range userIDs {
+		for _, roleID := range roleIDs {
+			links = append(links, map[string]any{"user_id": userID, "role_id": roleID})
+		}
+	}
+	return tx.Table("user_roles").Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+}
+
 // Update one specific user by id in the database
+// Roles are left untouched, they are assigned through the role repository,
+// and so is the token version which only ever goes up through IncrementTokenVersion
 func (r *userRepository) UpdateUser(user *models.User) error {
-	return r.db.Save(user).Error
+	return r.db.Omit("Roles", "TokenVersion").Save(user).Error
 }
 
 // Delete one specific user by id in the database
 func (r *userRepository) DeleteUser(force bool, user *models.User) error {
 	if force {
-		return r.db.Unscoped().Delete(user).Error
+		return r.db.Transaction(func(tx *gorm.DB) error {
+			// The follows go with the user, the counters of the users on the other side are kept in line
+			if err := tx.Exec("UPDATE users SET followed = followed - 1 WHERE id IN (SELECT follower_id FROM follows WHERE followed_id = ?)", user.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE users SET subscribers = subscribers - 1 WHERE id IN (SELECT followed_id FROM follows WHERE follower_id = ?)", user.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("follower_id = ? OR followed_id = ?", user.ID, user.ID).Delete(&models.Follow{}).Error; err != nil {
+				return err
+			}
+			// So do their posts, which no longer count for their tags unless the user was already deleted
+			var current models.User
+			if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "deleted_at").First(&current, "id = ?", user.ID).Error; err != nil {
+				return err
+			}
+			if !current.DeletedAt.Valid {
+				if err := updateAuthorsTagCounts(tx, []uuid.UUID{user.ID}, -1); err != nil {
+					return err
+				}
+			}
+			// So do the reactions, the counts of what they reacted to are recomputed first
+			if err := recountReactionsWithoutUser(tx, user.ID.String()); err != nil {
+				return err
+			}
+			// The links to the roles go with the user
+			return tx.Unscoped().Select("Roles").Delete(user).Error
+		})
 	} else {
-		return r.db.Delete(user).Error
+		// The posts of a deleted user no longer count for their tags
+		return r.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Delete(user)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return updateAuthorsTagCounts(tx, []uuid.UUID{user.ID}, -1)
+		})
 	}
 }
 
+// Restoring a deleted user, their posts count for their tags again
 func (r *userRepository) RestoreUser(user *models.User) error {
-	user.DeletedAt = gorm.DeletedAt{}
-	return r.db.Save(user).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.User{}).Where("id = ? AND deleted_at IS NOT NULL", user.ID).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if err := updateAuthorsTagCounts(tx, []uuid.UUID{user.ID}, 1); err != nil {
+				return err
+			}
+		}
+
+		user.DeletedAt = gorm.DeletedAt{}
+		user.PurgeAt = nil
+		return tx.Omit("Roles", "TokenVersion").Save(user).Error
+	})
+}
+
+// Set or clear the date until which a user can restore their deleted account
+func (r *userRepository) SchedulePurge(user *models.User, at *time.Time) error {
+	if err := r.db.Unscoped().Model(user).Update("purge_at", at).Error; err != nil {
+		return err
+	}
+	user.PurgeAt = at
+	return nil
+}
+
+// Replace only the image and avatar fields of the user
+func (r *userRepository) UpdateAvatar(user *models.User) error {
+	return r.db.Model(user).Select("image", "avatars", "avatar_key").Updates(user).Error
+}
+
+// Get the current token version of an active user
+func (r *userRepository) FindTokenVersion(id string) (uint, error) {
+	var user models.User
+	err := r.db.Select("token_version").First(&user, "id = ?", id).Error
+	return user.TokenVersion, err
+}
+
+// Invalidate every access token of the user, deleted users included
+func (r *userRepository) IncrementTokenVersion(id string) error {
+	return r.db.Unscoped().Model(&models.User{}).Where("id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
 }
@@ -1,15 +1,67 @@
 package repositories
 
 import (
+	"time"
+
 	"github.com/timebetov/readerblog/internals/models"
 )
 
+// Which users the list includes depending on their deletion
+type DeletedFilter int
+
+const (
+	ExcludeDeleted DeletedFilter = iota
+	OnlyDeleted
+	IncludeDeleted
+)
+
+// UserFilter selects and orders the users of a list, zero values are ignored
+type UserFilter struct {
+	Deleted       DeletedFilter
+	Role          string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	EmailDomain   string
+	// Column the users are sorted by, the ID breaks ties
+	SortColumn string
+	Descending bool
+	// Keyset of the last user of the previous page, the list starts after it
+	After *UserKeyset
+	Limit int
+}
+
+type UserKeyset struct {
+	Value any
+	ID    string
+}
+
+// UserBatchChange is what a batch applies to every user of a list
+type UserBatchChange int
+
+const (
+	BatchDelete UserBatchChange = iota
+	BatchRestore
+	BatchSetRoles
+	BatchAddRoles
+	BatchRemoveRoles
+)
+
 type UserRepository interface {
-	FindUsers(includeDeleted bool) ([]models.User, error)
+	FindUsers(filter *UserFilter) ([]models.User, error)
+	CountUsers(filter *UserFilter) (int64, error)
 	FindUserById(id string) (*models.User, error)
 	FindUserByUsername(username string) (*models.User, error)
+	FindUserByEmail(email string) (*models.User, error)
+	FindUsersByEmailOrUsername(email, username string) ([]models.User, error)
+	UsernameTaken(username string) (bool, error)
+	FindUsersByIds(ids []string) ([]models.User, error)
+	ApplyBatch(change UserBatchChange, ids []string, roles []models.Role) error
 	CreateUser(user *models.User) error
 	UpdateUser(user *models.User) error
 	DeleteUser(force bool, user *models.User) error
 	RestoreUser(user *models.User) error
+	SchedulePurge(user *models.User, at *time.Time) error
+	UpdateAvatar(user *models.User) error
+	FindTokenVersion(id string) (uint, error)
+	IncrementTokenVersion(id string) error
 }
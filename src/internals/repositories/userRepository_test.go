@@ -0,0 +1,172 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingDB records the statements it is given without a database. Updates affect one row
+// unless their statement contains a key of affected, queries return the rows of their first
+// matching key in rows.
+type recordingDB struct {
+	statements []string
+	affected   map[string]int64
+	rows       map[string][][]driver.Value
+}
+
+func (db *recordingDB) open(t *testing.T) *gorm.DB {
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(db)}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gormDB
+}
+
+// tagCountUpdates counts the recorded statements moving the posts count of tags
+func (db *recordingDB) tagCountUpdates() int {
+	n := 0
+	for _, statement := range db.statements {
+		if strings.HasPrefix(statement, "UPDATE tags SET posts_count") {
+			n++
+		}
+	}
+	return n
+}
+
+func (db *recordingDB) Connect(context.Context) (driver.Conn, error) { return db, nil }
+func (db *recordingDB) Driver() driver.Driver                        { return nil }
+
+func (db *recordingDB) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	db.statements = append(db.statements, query)
+	for key, affected := range db.affected {
+		if strings.Contains(query, key) {
+			return driver.RowsAffected(affected), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (db *recordingDB) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	db.statements = append(db.statements, query)
+	for key, rows := range db.rows {
+		if strings.Contains(query, key) {
+			return &recordedRows{columns: []string{"id", "deleted_at"}[:len(rows[0])], values: rows}, nil
+		}
+	}
+	return &recordedRows{columns: []string{"id"}}, nil
+}
+
+func (db *recordingDB) Begin() (driver.Tx, error) { return db, nil }
+func (db *recordingDB) Commit() error             { return nil }
+func (db *recordingDB) Rollback() error           { return nil }
+func (db *recordingDB) Close() error              { return nil }
+
+func (db *recordingDB) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("statements are not prepared")
+}
+
+// recordedRows returns rows of an id, and a deleted_at column when given
+type recordedRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recordedRows) Columns() []string { return r.columns }
+func (r *recordedRows) Close() error      { return nil }
+
+func (r *recordedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestSoftDeleteMovesTagCountsOnlyOnChange(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "frankhill01"}
+
+	for name, test := range map[string]struct {
+		run      func(repo UserRepository) error
+		affected int64
+		want     int
+	}{
+		"deleting":                 {func(repo UserRepository) error { return repo.DeleteUser(false, user) }, 1, 1},
+		"deleting a deleted user":  {func(repo UserRepository) error { return repo.DeleteUser(false, user) }, 0, 0},
+		"restoring":                {func(repo UserRepository) error { return repo.RestoreUser(user) }, 1, 1},
+		"restoring an active user": {func(repo UserRepository) error { return repo.RestoreUser(user) }, 0, 0},
+		"deleting a batch":         {func(repo UserRepository) error { return repo.ApplyBatch(BatchDelete, []string{user.ID.String()}, nil) }, 1, 1},
+		"restoring a batch":        {func(repo UserRepository) error { return repo.ApplyBatch(BatchRestore, []string{user.ID.String()}, nil) }, 1, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := &recordingDB{affected: map[string]int64{`SET "deleted_at"`: test.affected}}
+			// The batches find the users changing state when locking them
+			if test.affected > 0 {
+				db.rows = map[string][][]driver.Value{"FOR UPDATE": {{user.ID.String()}}}
+			}
+			if err := test.run(NewUserRepository(db.open(t))); err != nil {
+				t.Fatal(err)
+			}
+			if got := db.tagCountUpdates(); got != test.want {
+				t.Errorf("moved the tag counts %d times, want %d:\n%s", got, test.want, strings.Join(db.statements, "\n"))
+			}
+		})
+	}
+}
+
+func TestForceDeleteCountsTagsOfDeletedUsersOnce(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "frankhill01"}
+
+	for name, test := range map[string]struct {
+		deletedAt driver.Value
+		want      int
+	}{
+		"active user": {nil, 1},
+		// Their posts already left the counts when they were soft deleted
+		"soft deleted user": {time.Now(), 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := &recordingDB{rows: map[string][][]driver.Value{"FOR UPDATE": {{user.ID.String(), test.deletedAt}}}}
+			if err := NewUserRepository(db.open(t)).DeleteUser(true, user); err != nil {
+				t.Fatal(err)
+			}
+			if got := db.tagCountUpdates(); got != test.want {
+				t.Errorf("moved the tag counts %d times, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindUsersKeysetFollowsTheSortDirection(t *testing.T) {
+	after := &UserKeyset{Value: "ada0001", ID: uuid.NewString()}
+	for descending, want := range map[bool][]string{
+		false: {"(users.username, users.id) > ($1, $2)", "ORDER BY users.username ASC,users.id ASC LIMIT $3"},
+		true:  {"(users.username, users.id) < ($1, $2)", "ORDER BY users.username DESC,users.id DESC LIMIT $3"},
+	} {
+		db := &recordingDB{}
+		filter := &UserFilter{SortColumn: "username", Descending: descending, After: after, Limit: 51}
+		if _, err := NewUserRepository(db.open(t)).FindUsers(filter); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.statements) != 1 {
+			t.Fatalf("got statements %v, want one query", db.statements)
+		}
+		for _, part := range want {
+			if !strings.Contains(db.statements[0], part) {
+				t.Errorf("descending %v: %q does not contain %q", descending, db.statements[0], part)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// All routes related to the personal access tokens of the authenticated user
+func SetupAPITokenRoutes(api fiber.Router, authService *services.AuthService, apiTokenController *controllers.APITokenController) {
+	tokens := api.Group("/profile/tokens")
+	tokens.Use(middlewares.AuthenticationMiddleware(authService))
+	// A token must not be able to mint other tokens
+	tokens.Use(middlewares.SessionTokenMiddleware())
+
+	tokens.Get("/", apiTokenController.GetTokens)
+	tokens.Post("/", apiTokenController.CreateToken)
+	tokens.Get("/:tokenId", apiTokenController.GetToken)
+	tokens.Patch("/:tokenId", apiTokenController.UpdateToken)
+	tokens.Delete("/:tokenId", apiTokenController.RevokeToken)
+}
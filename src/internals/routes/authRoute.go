@@ -10,6 +10,12 @@ import (
 func SetupAuthRoutes(api fiber.Router, authService *services.AuthService, authController *controllers.AuthController) {
 	api.Post("/register", authController.RegisterUser)
 	api.Post("/login", authController.Login)
-	api.Post("/logout", middlewares.AuthenticationMiddleware(authService), authController.Logout)
-	api.Get("/profile", middlewares.AuthenticationMiddleware(authService), authController.Profile)
+	api.Post("/login/2fa", authController.LoginTwoFactor)
+	api.Post("/token/refresh", authController.RefreshToken)
+	api.Get("/.well-known/jwks.json", authController.JWKS)
+	api.Post("/logout", middlewares.AuthenticationMiddleware(authService), middlewares.SessionTokenMiddleware(), authController.Logout)
+	api.Get("/profile", middlewares.AuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware(), authController.Profile)
+	// Changing and deleting the account needs a session, personal access tokens are refused
+	api.Patch("/profile", middlewares.AuthenticationMiddleware(authService), middlewares.SessionTokenMiddleware(), authController.UpdateProfile)
+	api.Delete("/profile", middlewares.AuthenticationMiddleware(authService), middlewares.SessionTokenMiddleware(), authController.DeleteProfile)
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Avatar of the authenticated user, personal access tokens cannot change it
+func SetupAvatarRoutes(api fiber.Router, authService *services.AuthService, avatarController *controllers.AvatarController) {
+	avatar := api.Group("/profile/avatar")
+	avatar.Use(middlewares.AuthenticationMiddleware(authService))
+	avatar.Use(middlewares.SessionTokenMiddleware())
+
+	avatar.Put("/", avatarController.UploadAvatar)
+	avatar.Delete("/", avatarController.RemoveAvatar)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Categories are public, managing them requires categories.manage
+func SetupCategoryRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, categoryController *controllers.CategoryController, postController *controllers.PostController) {
+	categories := api.Group("/categories")
+	authenticated := []fiber.Handler{middlewares.AuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+	optional := []fiber.Handler{middlewares.OptionalAuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+
+	categories.Get("/", categoryController.GetCategories)
+	categories.Get("/:slug/posts", append(optional, postController.GetCategoryPosts)...)
+
+	categories.Post("/", append(authenticated, middlewares.PermissionMiddleware(rbacService, "categories.manage"), categoryController.CreateCategory)...)
+	categories.Patch("/:slug", append(authenticated, middlewares.PermissionMiddleware(rbacService, "categories.manage"), categoryController.UpdateCategory)...)
+	categories.Delete("/:slug", append(authenticated, middlewares.PermissionMiddleware(rbacService, "categories.manage"), categoryController.DeleteCategory)...)
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Login log routes, they require the 'login-attempts.read' permission
+func SetupLoginAttemptRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, loginAttemptController *controllers.LoginAttemptController) {
+	attempts := api.Group("/login-attempts")
+	attempts.Use(middlewares.AuthenticationMiddleware(authService))
+	attempts.Use(middlewares.AuthorizationMiddleware())
+	attempts.Use(middlewares.PermissionMiddleware(rbacService, "login-attempts.read"))
+
+	attempts.Get("/", loginAttemptController.GetLoginAttempts)
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// All routes related to OpenID Connect login and account linking
+func SetupOIDCRoutes(api fiber.Router, authService *services.AuthService, oidcController *controllers.OIDCController) {
+	api.Get("/auth/providers", oidcController.GetProviders)
+	api.Get("/auth/:provider/start", oidcController.StartLogin)
+	api.Get("/auth/:provider/callback", oidcController.Callback)
+
+	identities := api.Group("/profile/identities")
+	identities.Use(middlewares.AuthenticationMiddleware(authService))
+	identities.Use(middlewares.SessionTokenMiddleware())
+
+	identities.Get("/", oidcController.GetIdentities)
+	identities.Post("/:provider/start", oidcController.StartLink)
+	identities.Delete("/:identityId", oidcController.Unlink)
+}
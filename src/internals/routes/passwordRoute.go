@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+)
+
+// All routes related to password recovery, they do not require authentication
+func SetupPasswordRoutes(api fiber.Router, passwordController *controllers.PasswordController) {
+	api.Post("/password/forgot", passwordController.ForgotPassword)
+	api.Post("/password/reset", passwordController.ResetPassword)
+}
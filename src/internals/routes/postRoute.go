@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Posts, their comments and reactions, published posts are readable without authentication
+func SetupPostRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, postController *controllers.PostController, commentController *controllers.CommentController, reactionController *controllers.ReactionController) {
+	posts := api.Group("/posts")
+	authenticated := []fiber.Handler{middlewares.AuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+	optional := []fiber.Handler{middlewares.OptionalAuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+
+	posts.Get("/", append(optional, postController.GetPosts)...)
+	posts.Get("/:postId", append(optional, postController.GetPost)...)
+
+	// Writing requires a verified email, editing is left to the author and the users holding posts.manage
+	posts.Post("/", append(authenticated, middlewares.VerifiedEmailMiddleware(), middlewares.PermissionMiddleware(rbacService, "posts.create"), postController.CreatePost)...)
+	posts.Patch("/:postId", append(authenticated, postController.UpdatePost)...)
+	posts.Delete("/:postId", append(authenticated, postController.DeletePost)...)
+	posts.Put("/:postId/restore", append(authenticated, postController.RestorePost)...)
+
+	// Comments of the posts readers can see, writing them requires a verified email
+	posts.Get("/:postId/comments", append(optional, commentController.GetComments)...)
+	posts.Post("/:postId/comments", append(authenticated, middlewares.VerifiedEmailMiddleware(), commentController.CreateComment)...)
+	posts.Patch("/:postId/comments/:commentId", append(authenticated, commentController.UpdateComment)...)
+	posts.Delete("/:postId/comments/:commentId", append(authenticated, commentController.DeleteComment)...)
+
+	// Reactions to the published posts and their comments, the type to remove is given as ?type=
+	posts.Get("/:postId/reactions", append(optional, reactionController.GetReactions)...)
+	posts.Post("/:postId/reactions", append(authenticated, middlewares.VerifiedEmailMiddleware(), reactionController.AddReaction)...)
+	posts.Delete("/:postId/reactions", append(authenticated, reactionController.RemoveReaction)...)
+	posts.Get("/:postId/comments/:commentId/reactions", append(optional, reactionController.GetReactions)...)
+	posts.Post("/:postId/comments/:commentId/reactions", append(authenticated, middlewares.VerifiedEmailMiddleware(), reactionController.AddReaction)...)
+	posts.Delete("/:postId/comments/:commentId/reactions", append(authenticated, reactionController.RemoveReaction)...)
+}
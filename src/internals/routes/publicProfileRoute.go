@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+	"github.com/timebetov/readerblog/internals/controllers"
+)
+
+// Public profiles, readable without authentication
+func SetupPublicProfileRoutes(api fiber.Router, userController *controllers.UserController) {
+	profiles := api.Group("/u")
+
+	// The ETag of the answer lets clients revalidate it with If-None-Match
+	profiles.Get("/:username", etag.New(), userController.GetPublicProfile)
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// All routes managing roles and permissions
+func SetupRoleRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, roleController *controllers.RoleController) {
+	api.Get("/permissions",
+		middlewares.AuthenticationMiddleware(authService),
+		middlewares.AuthorizationMiddleware(),
+		middlewares.PermissionMiddleware(rbacService, "roles.read"),
+		roleController.GetPermissions)
+
+	roles := api.Group("/roles")
+	roles.Use(middlewares.AuthenticationMiddleware(authService))
+	roles.Use(middlewares.AuthorizationMiddleware())
+
+	roles.Get("/", middlewares.PermissionMiddleware(rbacService, "roles.read"), roleController.GetRoles)
+	roles.Post("/", middlewares.PermissionMiddleware(rbacService, "roles.manage"), roleController.CreateRole)
+	roles.Get("/:roleId", middlewares.PermissionMiddleware(rbacService, "roles.read"), roleController.GetRole)
+	roles.Patch("/:roleId", middlewares.PermissionMiddleware(rbacService, "roles.manage"), roleController.UpdateRole)
+	roles.Delete("/:roleId", middlewares.PermissionMiddleware(rbacService, "roles.manage"), roleController.DeleteRole)
+}
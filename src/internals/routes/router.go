@@ -1,15 +1,25 @@
 package routes
 
 import (
+	"strings"
+
 	fiber "github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/timebetov/readerblog/database"
 	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/mailer"
+	"github.com/timebetov/readerblog/internals/middlewares"
 	"github.com/timebetov/readerblog/internals/repositories"
 	"github.com/timebetov/readerblog/internals/services"
+	"github.com/timebetov/readerblog/internals/storage"
 )
 
 func SetupRoutes(app *fiber.App) {
+	// Only user imports read bodies larger than the body limit
+	app.Use(middlewares.BodyLimitMiddleware(app.Config().BodyLimit, func(c *fiber.Ctx) bool {
+		return c.Method() == fiber.MethodPost && strings.TrimSuffix(c.Path(), "/") == "/api/users/import"
+	}))
+
 	api := app.Group("/api", logger.New())
 
 	api.Get("/", func(c *fiber.Ctx) error {
@@ -20,21 +30,104 @@ func SetupRoutes(app *fiber.App) {
 
 	// Initializing redis client
 	redisClient := database.NewRedisClient()
+	// Initializing the mailer
+	mail := mailer.NewMailer()
+	// Initializing the storage of uploaded files
+	store := storage.NewStorage()
+	// Files of a local storage are served by the app itself
+	if local, ok := store.(*storage.LocalStorage); ok {
+		app.Static(storage.LocalURLPath, local.Dir)
+	}
 
 	// Initializing repositories
 	authRepo := repositories.NewAuthRepository(database.DB)
 	userRepo := repositories.NewUserRepository(database.DB)
+	sessionRepo := repositories.NewSessionRepository(database.DB)
+	twoFactorRepo := repositories.NewTwoFactorRepository(database.DB)
+	passwordResetRepo := repositories.NewPasswordResetRepository(database.DB)
+	loginAttemptRepo := repositories.NewLoginAttemptRepository(database.DB)
+	identityRepo := repositories.NewIdentityRepository(database.DB)
+	apiTokenRepo := repositories.NewAPITokenRepository(database.DB)
+	roleRepo := repositories.NewRoleRepository(database.DB)
+	followRepo := repositories.NewFollowRepository(database.DB)
+	importJobRepo := repositories.NewImportJobRepository(database.DB)
+	postRepo := repositories.NewPostRepository(database.DB)
+	commentRepo := repositories.NewCommentRepository(database.DB)
+	reactionRepo := repositories.NewReactionRepository(database.DB)
+	tagRepo := repositories.NewTagRepository(database.DB)
+	categoryRepo := repositories.NewCategoryRepository(database.DB)
 
 	// Initializing services
-	userService := services.NewUserService(userRepo)
-	authService := services.NewAuthService(authRepo, userService, redisClient)
+	sessionService := services.NewSessionService(sessionRepo, redisClient)
+	userService := services.NewUserService(userRepo, sessionService)
+	twoFactorService := services.NewTwoFactorService(userRepo, twoFactorRepo, redisClient)
+	verificationService := services.NewVerificationService(userRepo, mail, redisClient)
+	passwordResetService := services.NewPasswordResetService(userRepo, passwordResetRepo, sessionService, mail, redisClient)
+	loginThrottleService := services.NewLoginThrottleService(loginAttemptRepo, redisClient)
+	apiTokenService := services.NewAPITokenService(apiTokenRepo, userRepo)
+	authService := services.NewAuthService(authRepo, userService, sessionService, twoFactorService, verificationService, loginThrottleService, apiTokenService, redisClient)
+	oidcService := services.NewOIDCService(identityRepo, userRepo, authService, redisClient)
+	rbacService := services.NewRBACService(roleRepo, userRepo)
+	followService := services.NewFollowService(followRepo, userRepo)
+	avatarService := services.NewAvatarService(userRepo, store)
+	importService := services.NewImportService(importJobRepo, userRepo, roleRepo, userService, rbacService)
+	userBatchService := services.NewUserBatchService(userRepo, roleRepo, rbacService, sessionService)
+	postService := services.NewPostService(postRepo, userRepo, tagRepo, categoryRepo, rbacService)
+	commentService := services.NewCommentService(commentRepo, userRepo, postService, rbacService)
+	reactionService := services.NewReactionService(reactionRepo, postService, commentService)
+	tagService := services.NewTagService(tagRepo)
+	categoryService := services.NewCategoryService(categoryRepo)
+	// Jobs of a previous process cannot go on, their files are gone
+	importService.FailInterruptedJobs()
 
 	// Initializing controllers
 	authController := controllers.NewAuthController(authService)
 	userController := controllers.NewUserController(userService)
+	sessionController := controllers.NewSessionController(sessionService)
+	twoFactorController := controllers.NewTwoFactorController(twoFactorService)
+	verificationController := controllers.NewVerificationController(verificationService)
+	passwordController := controllers.NewPasswordController(passwordResetService)
+	loginAttemptController := controllers.NewLoginAttemptController(loginThrottleService, userService)
+	oidcController := controllers.NewOIDCController(oidcService)
+	apiTokenController := controllers.NewAPITokenController(apiTokenService)
+	roleController := controllers.NewRoleController(rbacService)
+	followController := controllers.NewFollowController(followService)
+	avatarController := controllers.NewAvatarController(avatarService)
+	importController := controllers.NewImportController(importService)
+	userBatchController := controllers.NewUserBatchController(userBatchService)
+	postController := controllers.NewPostController(postService)
+	commentController := controllers.NewCommentController(commentService)
+	reactionController := controllers.NewReactionController(reactionService)
+	tagController := controllers.NewTagController(tagService)
+	categoryController := controllers.NewCategoryController(categoryService)
 
 	// Authentication routes
 	SetupAuthRoutes(api, authService, authController)
-	// Setting up user routes only 'admins' can access
-	SetupUserRoutes(api, authService, userController)
+	// Email verification routes
+	SetupVerificationRoutes(api, verificationController)
+	// Password recovery routes
+	SetupPasswordRoutes(api, passwordController)
+	// OpenID Connect login and account linking routes
+	SetupOIDCRoutes(api, authService, oidcController)
+	// Personal access tokens of the authenticated user
+	SetupAPITokenRoutes(api, authService, apiTokenController)
+	// Avatar upload of the authenticated user
+	SetupAvatarRoutes(api, authService, avatarController)
+	// Session management routes of the authenticated user
+	SetupSessionRoutes(api, authService, sessionController)
+	// Two-factor authentication management of the authenticated user
+	SetupTwoFactorRoutes(api, authService, twoFactorController)
+	// Public profiles of the users
+	SetupPublicProfileRoutes(api, userController)
+	// User management routes, every route requires its own permission, and follows
+	SetupUserRoutes(api, authService, rbacService, userController, loginAttemptController, roleController, followController, importController, userBatchController)
+	// Posts, their comments and reactions, published ones are public
+	SetupPostRoutes(api, authService, rbacService, postController, commentController, reactionController)
+	// Tags and categories of the posts, admins manage them
+	SetupTagRoutes(api, authService, rbacService, tagController, postController)
+	SetupCategoryRoutes(api, authService, rbacService, categoryController, postController)
+	// Login log routes
+	SetupLoginAttemptRoutes(api, authService, rbacService, loginAttemptController)
+	// Role and permission management routes
+	SetupRoleRoutes(api, authService, rbacService, roleController)
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// All routes related to the sessions of the authenticated user
+func SetupSessionRoutes(api fiber.Router, authService *services.AuthService, sessionController *controllers.SessionController) {
+	sessions := api.Group("/sessions")
+	sessions.Use(middlewares.AuthenticationMiddleware(authService))
+	sessions.Use(middlewares.SessionTokenMiddleware())
+
+	sessions.Get("/", sessionController.GetSessions)
+	sessions.Delete("/", sessionController.RevokeAllSessions)
+	sessions.Delete("/:sessionId", sessionController.RevokeSession)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// Tags are public, renaming and merging them is left to the users holding tags.manage
+func SetupTagRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, tagController *controllers.TagController, postController *controllers.PostController) {
+	tags := api.Group("/tags")
+	authenticated := []fiber.Handler{middlewares.AuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+	optional := []fiber.Handler{middlewares.OptionalAuthenticationMiddleware(authService), middlewares.AuthorizationMiddleware()}
+
+	tags.Get("/", tagController.GetTags)
+	tags.Get("/:slug", tagController.GetTag)
+	tags.Get("/:slug/posts", append(optional, postController.GetTagPosts)...)
+
+	tags.Patch("/:slug", append(authenticated, middlewares.PermissionMiddleware(rbacService, "tags.manage"), tagController.RenameTag)...)
+	tags.Post("/:slug/merge", append(authenticated, middlewares.PermissionMiddleware(rbacService, "tags.manage"), tagController.MergeTag)...)
+}
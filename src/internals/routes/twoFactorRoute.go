@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+	"github.com/timebetov/readerblog/internals/middlewares"
+	"github.com/timebetov/readerblog/internals/services"
+)
+
+// All routes related to the two-factor authentication of the authenticated user
+func SetupTwoFactorRoutes(api fiber.Router, authService *services.AuthService, twoFactorController *controllers.TwoFactorController) {
+	twoFactor := api.Group("/profile/2fa")
+	twoFactor.Use(middlewares.AuthenticationMiddleware(authService))
+	twoFactor.Use(middlewares.SessionTokenMiddleware())
+
+	twoFactor.Post("/enroll", twoFactorController.Enroll)
+	twoFactor.Post("/confirm", twoFactorController.Confirm)
+	twoFactor.Post("/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+	twoFactor.Delete("/", twoFactorController.Disable)
+}
@@ -8,15 +8,31 @@ import (
 )
 
 // All routes related to user
-func SetupUserRoutes(api fiber.Router, authService *services.AuthService, userController *controllers.UserController) {
+func SetupUserRoutes(api fiber.Router, authService *services.AuthService, rbacService *services.RBACService, userController *controllers.UserController, loginAttemptController *controllers.LoginAttemptController, roleController *controllers.RoleController, followController *controllers.FollowController, importController *controllers.ImportController, userBatchController *controllers.UserBatchController) {
 	users := api.Group("/users")
 	users.Use(middlewares.AuthenticationMiddleware(authService))
 	users.Use(middlewares.AuthorizationMiddleware())
+	users.Use(middlewares.VerifiedEmailMiddleware())
 
-	users.Post("/", userController.CreateUser)
-	users.Get("/", userController.GetUsers)
-	users.Get("/:userId", userController.GetUser)
-	users.Patch("/:userId", userController.UpdateUser)
-	users.Delete("/:userId", userController.DeleteUser)
-	users.Put("/:userId/restore", userController.RestoreUser)
+	users.Post("/", middlewares.PermissionMiddleware(rbacService, "users.create"), userController.CreateUser)
+	users.Get("/", middlewares.PermissionMiddleware(rbacService, "users.read"), userController.GetUsers)
+	// Bulk routes come before the ones matching any user ID
+	users.Get("/export", middlewares.PermissionMiddleware(rbacService, "users.export"), userController.ExportUsers)
+	users.Post("/import", middlewares.PermissionMiddleware(rbacService, "users.import"), importController.ImportUsers)
+	users.Get("/import/:jobId", middlewares.PermissionMiddleware(rbacService, "users.import"), importController.GetImportJob)
+	// Every batch action checks its own permission on top of this one
+	users.Post("/batch", middlewares.PermissionMiddleware(rbacService, "users.read"), userBatchController.RunBatch)
+	users.Get("/:userId", middlewares.PermissionMiddleware(rbacService, "users.read"), userController.GetUser)
+	users.Patch("/:userId", middlewares.PermissionMiddleware(rbacService, "users.update"), userController.UpdateUser)
+	users.Delete("/:userId", middlewares.PermissionMiddleware(rbacService, "users.delete"), userController.DeleteUser)
+	users.Put("/:userId/restore", middlewares.PermissionMiddleware(rbacService, "users.restore"), userController.RestoreUser)
+	users.Post("/:userId/unlock", middlewares.PermissionMiddleware(rbacService, "users.unlock"), loginAttemptController.UnlockUser)
+	users.Get("/:userId/roles", middlewares.PermissionMiddleware(rbacService, "users.read"), roleController.GetUserRoles)
+	users.Put("/:userId/roles", middlewares.PermissionMiddleware(rbacService, "roles.assign"), roleController.SetUserRoles)
+
+	// Following other users only requires being logged in
+	users.Post("/:username/follow", followController.Follow)
+	users.Delete("/:username/follow", followController.Unfollow)
+	users.Get("/:username/followers", followController.GetFollowers)
+	users.Get("/:username/following", followController.GetFollowing)
 }
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/timebetov/readerblog/internals/controllers"
+)
+
+// All routes related to email verification, they do not require authentication
+func SetupVerificationRoutes(api fiber.Router, verificationController *controllers.VerificationController) {
+	api.Get("/verify-email", verificationController.VerifyEmail)
+	api.Post("/verify-email/resend", verificationController.ResendVerification)
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Personal access tokens start with this prefix so they can be told apart from JWTs and spotted in leaks
+const APITokenPrefix = "rbp_"
+
+// Number of characters of a token shown back to the user to recognize it
+const apiTokenDisplayLength = len(APITokenPrefix) + 8
+
+// Scopes a personal access token can be granted. A scope is the first segment of the
+// route after "/api" followed by ":read" for GET requests or ":write" for the others.
+var APITokenScopes = []string{
+	"users:read",
+	"users:write",
+	"profile:read",
+	"login-attempts:read",
+	"roles:read",
+	"roles:write",
+	"permissions:read",
+	"posts:read",
+	"posts:write",
+	"tags:read",
+	"tags:write",
+	"categories:read",
+	"categories:write",
+}
+
+type APITokenService struct {
+	repo     repositories.APITokenRepository
+	userRepo repositories.UserRepository
+}
+
+func NewAPITokenService(repo repositories.APITokenRepository, userRepo repositories.UserRepository) *APITokenService {
+	return &APITokenService{repo, userRepo}
+}
+
+// CreateToken issues a new token for the user, the raw token is only returned here.
+// mfa tells whether the session creating the token used a second factor.
+func (ats *APITokenService) CreateToken(userID string, mfa bool, tokenDTO *dtos.CreateAPITokenDTO) (*dtos.CreatedAPITokenDTO, error) {
+	if err := utils.ValidateUser(tokenDTO); err != nil {
+		return nil, err
+	}
+
+	scopes, err := normalizeScopes(tokenDTO.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if tokenDTO.ExpiresAt != nil && !tokenDTO.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	user, err := ats.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	raw := APITokenPrefix + secret
+
+	token := &models.APIToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(tokenDTO.Name),
+		Prefix:    raw[:apiTokenDisplayLength],
+		TokenHash: utils.HashToken(raw),
+		Scopes:    strings.Join(scopes, " "),
+		MFA:       mfa,
+		ExpiresAt: tokenDTO.ExpiresAt,
+	}
+	if err := ats.repo.CreateAPIToken(token); err != nil {
+		return nil, err
+	}
+
+	return &dtos.CreatedAPITokenDTO{APITokenDTO: *apiTokenDto(token), Token: raw}, nil
+}
+
+func (ats *APITokenService) ListTokens(userID string) ([]dtos.APITokenDTO, error) {
+	user, err := ats.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := ats.repo.FindUserAPITokens(user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	tokenDtos := make([]dtos.APITokenDTO, 0, len(tokens))
+	for i := range tokens {
+		tokenDtos = append(tokenDtos, *apiTokenDto(&tokens[i]))
+	}
+	return tokenDtos, nil
+}
+
+func (ats *APITokenService) GetToken(userID, id string) (*dtos.APITokenDTO, error) {
+	token, err := ats.findUserToken(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return apiTokenDto(token), nil
+}
+
+// UpdateToken renames the token or replaces its scopes, the secret and expiry never change
+func (ats *APITokenService) UpdateToken(userID, id string, tokenDTO *dtos.UpdateAPITokenDTO) (*dtos.APITokenDTO, error) {
+	if err := utils.ValidateUser(tokenDTO); err != nil {
+		return nil, err
+	}
+
+	token, err := ats.findUserToken(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name := strings.TrimSpace(tokenDTO.Name); name != "" {
+		token.Name = name
+	}
+	if tokenDTO.Scopes != nil {
+		scopes, err := normalizeScopes(tokenDTO.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Join(scopes, " ")
+	}
+
+	if err := ats.repo.UpdateAPIToken(token); err != nil {
+		return nil, err
+	}
+	return apiTokenDto(token), nil
+}
+
+func (ats *APITokenService) RevokeToken(userID, id string) error {
+	user, err := ats.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return errors.New("token not found")
+	}
+
+	deleted, err := ats.repo.DeleteAPIToken(id, user.ID.String())
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// Authenticate resolves a raw personal access token to the claims of its owner
+func (ats *APITokenService) Authenticate(raw, ip string) (*utils.Claims, error) {
+	token, err := ats.repo.FindAPITokenByHash(utils.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invalid token")
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	user, err := ats.userRepo.FindUserById(token.UserID.String())
+	if err != nil || user.DeletedAt.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// Like sessions, the last use is only written once in a while
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > sessionTouchInterval || token.LastUsedIP != ip {
+		if err := ats.repo.TouchAPIToken(token.ID.String(), now, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	// A token minted after a second factor only counts as such while the owner keeps 2FA enabled
+	claims := &utils.Claims{
+		Username:      user.Username,
+		Roles:         roleNames(user.Roles),
+		MFA:           token.MFA && user.TOTPEnabled,
+		EmailVerified: user.VerifiedAt != nil,
+		APITokenID:    token.ID.String(),
+		Scopes:        strings.Fields(token.Scopes),
+	}
+	claims.Subject = user.ID.String()
+	return claims, nil
+}
+
+func (ats *APITokenService) findUser(userID string) (*models.User, error) {
+	user, err := ats.userRepo.FindUserById(userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (ats *APITokenService) findUserToken(userID, id string) (*models.APIToken, error) {
+	user, err := ats.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("token not found")
+	}
+
+	token, err := ats.repo.FindUserAPIToken(id, user.ID.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("token not found")
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// normalizeScopes checks every scope is known and removes duplicates
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = utils.TrimAndLower(scope)
+		if !isKnownScope(scope) {
+			return nil, errors.New("unknown scope: " + scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range APITokenScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+func apiTokenDto(token *models.APIToken) *dtos.APITokenDTO {
+	return &dtos.APITokenDTO{
+		ID:         token.ID.String(),
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     strings.Fields(token.Scopes),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		LastUsedIP: token.LastUsedIP,
+		CreatedAt:  token.CreatedAt,
+	}
+}
@@ -2,10 +2,14 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/timebetov/readerblog/internals/models"
 	"github.com/timebetov/readerblog/internals/models/dtos"
 	"github.com/timebetov/readerblog/internals/repositories"
 	"github.com/timebetov/readerblog/internals/utils"
@@ -13,65 +17,288 @@ import (
 )
 
 type AuthService struct {
-	repo        repositories.AuthRepository
-	userService *UserService
-	redisClient *redis.Client
+	repo             repositories.AuthRepository
+	userService      *UserService
+	sessionService   *SessionService
+	twoFactorService *TwoFactorService
+	verification     *VerificationService
+	throttle         *LoginThrottleService
+	apiTokens        *APITokenService
+	redisClient      *redis.Client
 }
 
 var ctx = context.Background()
 
-func NewAuthService(repo repositories.AuthRepository, userService *UserService, redisClient *redis.Client) *AuthService {
-	return &AuthService{repo, userService, redisClient}
+// Redis key prefixes used for refresh tokens
+const (
+	refreshTokenPrefix  = "refresh:"
+	refreshUsedPrefix   = "refresh_used:"
+	refreshFamilyPrefix = "refresh_family:"
+)
+
+// Second login step: lifetime of the challenge token and the number of codes that can be tried with it
+const (
+	twoFactorChallengeTTL      = 5 * time.Minute
+	twoFactorChallengeAttempts = 5
+	twoFactorChallengePrefix   = "2fa_challenge:"
+)
+
+// refreshTokenRecord is what gets stored in Redis for every refresh token issued.
+// The family of a refresh token is the id of the session it was issued for.
+type refreshTokenRecord struct {
+	Family string `json:"family"`
+}
+
+func NewAuthService(repo repositories.AuthRepository, userService *UserService, sessionService *SessionService, twoFactorService *TwoFactorService, verification *VerificationService, throttle *LoginThrottleService, apiTokens *APITokenService, redisClient *redis.Client) *AuthService {
+	return &AuthService{repo, userService, sessionService, twoFactorService, verification, throttle, apiTokens, redisClient}
 }
 
-func (as *AuthService) RegisterUser(userDTO *dtos.CreateUserDTO) (*dtos.ProfileDTO, string, error) {
+func (as *AuthService) RegisterUser(userDTO *dtos.CreateUserDTO, client *dtos.ClientInfoDTO) (*dtos.ProfileDTO, *dtos.TokenDTO, error) {
 	user, err := as.userService.CreateUser(userDTO)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 
-	// Generating the token
-	token, err := utils.GenerateToken(user.Username, user.Role)
-	if err != nil {
-		return nil, "", err
+	// Sending the verification link, a failure must not lose the account that was just created
+	if err := as.verification.SendVerification(user); err != nil {
+		log.Printf("Could not send verification email to %s: %v", user.Email, err)
 	}
 
-	userDto := &dtos.ProfileDTO{
-		Username:    user.Username,
-		Email:       user.Email,
-		Role:        user.Role,
-		Subscribers: user.Subscribers,
-		Followed:    user.Followed,
-		Image:       user.Image,
-		CreatedAt:   user.CreatedAt,
+	userDto := profileDto(user)
+
+	// Users who cannot log in before verifying their email do not get tokens either
+	if EmailVerificationPolicy() == VerificationPolicyLogin {
+		return userDto, nil, nil
+	}
+
+	// Generating the tokens, each registration starts a new session
+	tokens, err := as.startSession(user, client, false)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return userDto, token, nil
+	return userDto, tokens, nil
 }
 
-func (as *AuthService) Authenticate(userDTO *dtos.LoginUserDTO) (string, error) {
+// Authenticate checks the credentials of the user. When 2FA is enabled no tokens are issued,
+// a challenge token to be exchanged with a code through CompleteTwoFactorLogin is returned instead.
+func (as *AuthService) Authenticate(userDTO *dtos.LoginUserDTO, client *dtos.ClientInfoDTO) (*dtos.TokenDTO, *dtos.TwoFactorChallengeDTO, error) {
 	// Converting the username field to lowercase and trim any spaces before and after
 	userDTO.Username = utils.TrimAndLower(userDTO.Username)
 
 	// Validate the user data
 	if err := utils.ValidateUser(userDTO); err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	// Refusing to even check the password while the username or the client is locked out
+	if err := as.throttle.CheckLocked(userDTO.Username, client.IP); err != nil {
+		as.throttle.RecordAttempt(userDTO.Username, client, LoginReasonLocked)
+		return nil, nil, err
 	}
 
 	user, err := as.repo.FindUserByCredentials(userDTO.Username, userDTO.Password)
+	// Deleted accounts only log in while their owner can still restore them
+	if err == nil && user.DeletedAt.Valid && !as.userService.IsRestorable(user) {
+		err = gorm.ErrRecordNotFound
+	}
 	if err != nil {
-		return "", errors.New("unfortunately, User not found")
+		as.throttle.RecordAttempt(userDTO.Username, client, LoginReasonInvalidCredentials)
+		if err := as.throttle.RegisterFailure(userDTO.Username, client.IP); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("unfortunately, User not found")
+	}
+
+	if err := as.throttle.RegisterSuccess(userDTO.Username); err != nil {
+		return nil, nil, err
+	}
+
+	if user.DeletedAt.Valid && !userDTO.Restore {
+		as.throttle.RecordAttempt(user.Username, client, LoginReasonPendingDeletion)
+		return nil, nil, &AccountPendingDeletionError{PurgeAt: *user.PurgeAt}
 	}
 
-	// Generating JWT Token
-	token, err := utils.GenerateToken(user.Username, user.Role)
+	// Hashes made with another algorithm or outdated parameters are upgraded while the password is at hand
+	if !user.DeletedAt.Valid && utils.PasswordNeedsRehash(user.Password) {
+		if err := as.rehashPassword(user, userDTO.Password); err != nil {
+			log.Printf("Could not upgrade the password hash of %s: %v", user.Username, err)
+		}
+	}
+
+	return as.completeLogin(user, client)
+}
+
+// CompleteExternalLogin logs in a user authenticated by an external identity provider,
+// the same email and second factor rules as for a password login apply
+func (as *AuthService) CompleteExternalLogin(user *models.User, client *dtos.ClientInfoDTO) (*dtos.TokenDTO, *dtos.TwoFactorChallengeDTO, error) {
+	return as.completeLogin(user, client)
+}
+
+// completeLogin runs the checks following a successful first factor and issues tokens or a 2FA challenge.
+// Deleted users only get here when they asked to restore their account, which happens after the last factor.
+func (as *AuthService) completeLogin(user *models.User, client *dtos.ClientInfoDTO) (*dtos.TokenDTO, *dtos.TwoFactorChallengeDTO, error) {
+	if user.VerifiedAt == nil && EmailVerificationPolicy() == VerificationPolicyLogin {
+		as.throttle.RecordAttempt(user.Username, client, LoginReasonEmailNotVerified)
+		return nil, nil, errors.New("email not verified")
+	}
+
+	if user.TOTPEnabled {
+		as.throttle.RecordAttempt(user.Username, client, LoginReasonTwoFactorRequired)
+
+		challenge, err := utils.GenerateChallengeToken(user.ID.String(), &utils.ChallengeClaims{
+			Purpose:  "2fa",
+			Username: user.Username,
+			Restore:  user.DeletedAt.Valid,
+		}, twoFactorChallengeTTL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &dtos.TwoFactorChallengeDTO{
+			ChallengeToken: challenge,
+			ExpiresIn:      int64(twoFactorChallengeTTL.Seconds()),
+		}, nil
+	}
+
+	user, err := as.restoreAccount(user)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	return token, nil
+	as.throttle.RecordAttempt(user.Username, client, LoginReasonSuccess)
+
+	// Generating the tokens, each login starts a new session
+	tokens, err := as.startSession(user, client, false)
+	return tokens, nil, err
 }
 
+// CompleteTwoFactorLogin exchanges a challenge token and a TOTP or recovery code for tokens
+func (as *AuthService) CompleteTwoFactorLogin(loginDTO *dtos.TwoFactorLoginDTO, client *dtos.ClientInfoDTO) (*dtos.TokenDTO, error) {
+	if err := utils.ValidateUser(loginDTO); err != nil {
+		return nil, err
+	}
+
+	claims, err := utils.ParseChallengeToken(loginDTO.ChallengeToken, "2fa")
+	if err != nil {
+		return nil, errors.New("invalid challenge token")
+	}
+
+	// Limiting the number of codes that can be guessed with one challenge
+	key := twoFactorChallengePrefix + claims.ID
+	attempts, err := as.redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	as.redisClient.Expire(ctx, key, twoFactorChallengeTTL)
+	if attempts > twoFactorChallengeAttempts {
+		as.throttle.RecordAttempt(claims.Username, client, LoginReasonTwoFactorFailed)
+		return nil, errors.New("invalid challenge token")
+	}
+
+	var user *models.User
+	if claims.Restore {
+		user, err = as.userService.GetUserById(claims.Subject)
+		if err == nil && user.DeletedAt.Valid && !as.userService.IsRestorable(user) {
+			err = errors.New("user not found")
+		}
+	} else {
+		user, err = as.repo.FindSelf(claims.Subject)
+	}
+	if err != nil || !user.TOTPEnabled {
+		return nil, errors.New("invalid challenge token")
+	}
+
+	// Wrong codes lock the username across challenges, a locked username cannot keep guessing
+	if err := as.throttle.CheckLocked(user.Username, client.IP); err != nil {
+		as.throttle.RecordAttempt(user.Username, client, LoginReasonLocked)
+		return nil, err
+	}
+
+	if err := as.twoFactorService.Verify(user, &dtos.TwoFactorCodeDTO{
+		Code:         loginDTO.Code,
+		RecoveryCode: loginDTO.RecoveryCode,
+	}); err != nil {
+		if err.Error() == "invalid two-factor code" {
+			as.throttle.RecordAttempt(user.Username, client, LoginReasonTwoFactorFailed)
+			if err := as.throttle.RegisterTwoFactorFailure(user.Username, client.IP); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := as.throttle.RegisterTwoFactorSuccess(user.Username); err != nil {
+		return nil, err
+	}
+
+	// The challenge cannot be used a second time once it succeeded
+	as.redisClient.Set(ctx, key, twoFactorChallengeAttempts+1, twoFactorChallengeTTL)
+
+	if user, err = as.restoreAccount(user); err != nil {
+		return nil, err
+	}
+
+	as.throttle.RecordAttempt(user.Username, client, LoginReasonSuccess)
+	return as.startSession(user, client, true)
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair, rotating the refresh token.
+// Presenting a refresh token that was already used revokes its whole family.
+func (as *AuthService) RefreshTokens(refreshDTO *dtos.RefreshTokenDTO) (*dtos.TokenDTO, error) {
+	if err := utils.ValidateUser(refreshDTO); err != nil {
+		return nil, err
+	}
+
+	hash := utils.HashToken(refreshDTO.RefreshToken)
+	raw, err := as.redisClient.Get(ctx, refreshTokenPrefix+hash).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errors.New("invalid refresh token")
+		}
+		return nil, err
+	}
+
+	var record refreshTokenRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, err
+	}
+
+	// The session could have been revoked by logout, by the user or by reuse detection
+	session, err := as.sessionService.GetActiveSession(record.Family)
+	if err != nil {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	// Marking the token as used, SETNX makes sure only one request can win the rotation
+	firstUse, err := as.redisClient.SetNX(ctx, refreshUsedPrefix+hash, time.Now().Unix(), utils.RefreshTokenTTL()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !firstUse {
+		if err := as.revokeTokenFamily(record.Family); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("refresh token reuse detected")
+	}
+
+	// Reloading the user so that role changes and deletions are taken into account
+	user, err := as.repo.FindSelf(session.UserID.String())
+	if err != nil {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	// The session can be revoked by a concurrent request presenting the same token
+	if err := as.sessionService.ExtendSession(session); err != nil {
+		if err.Error() == "session revoked" {
+			return nil, errors.New("invalid refresh token")
+		}
+		return nil, err
+	}
+
+	return as.issueTokens(user, session)
+}
+
+// Logout blacklists the access token and ends its session, which also invalidates its refresh token
 func (as *AuthService) Logout(token string) error {
 	claims, err := utils.ParseToken(token)
 	if err != nil {
@@ -83,7 +310,11 @@ func (as *AuthService) Logout(token string) error {
 		expiration = 0
 	}
 
-	return as.redisClient.Set(ctx, token, "blacklisted", expiration).Err()
+	if err := as.redisClient.Set(ctx, token, "blacklisted", expiration).Err(); err != nil {
+		return err
+	}
+
+	return as.revokeTokenFamily(claims.SessionID)
 }
 
 func (as *AuthService) IsTokenBlacklisted(token string) bool {
@@ -91,8 +322,30 @@ func (as *AuthService) IsTokenBlacklisted(token string) bool {
 	return err == nil
 }
 
-func (as *AuthService) GetUserProfile(username string) (*dtos.ProfileDTO, error) {
-	user, err := as.repo.FindSelf(username)
+// IsTokenVersionCurrent reports whether the token was issued after the last change of the user
+// invalidating tokens (role or password change, deletion)
+func (as *AuthService) IsTokenVersionCurrent(claims *utils.Claims) bool {
+	version, err := as.userService.GetTokenVersion(claims.Subject)
+	return err == nil && version == claims.TokenVersion
+}
+
+// IsSessionActive reports whether the session the token was issued for is still alive
+func (as *AuthService) IsSessionActive(claims *utils.Claims) bool {
+	return as.sessionService.IsSessionActive(claims.SessionID)
+}
+
+// IsAPIToken tells whether a bearer token is a personal access token rather than a JWT
+func (as *AuthService) IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// AuthenticateAPIToken resolves a personal access token to the claims of its owner
+func (as *AuthService) AuthenticateAPIToken(token, ip string) (*utils.Claims, error) {
+	return as.apiTokens.Authenticate(token, ip)
+}
+
+func (as *AuthService) GetUserProfile(userID string) (*dtos.ProfileDTO, error) {
+	user, err := as.repo.FindSelf(userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("user not found")
@@ -100,15 +353,135 @@ func (as *AuthService) GetUserProfile(username string) (*dtos.ProfileDTO, error)
 		return nil, err
 	}
 
-	userDto := &dtos.ProfileDTO{
-		Username:    user.Username,
-		Email:       user.Email,
-		Role:        user.Role,
-		Subscribers: user.Subscribers,
-		Followed:    user.Followed,
-		Image:       user.Image,
-		CreatedAt:   user.CreatedAt,
+	return profileDto(user), nil
+}
+
+// UpdateProfile applies the changes of the user to their own account, a new email address gets a verification link
+func (as *AuthService) UpdateProfile(userID, sessionID string, profileDTO *dtos.UpdateProfileDTO) (*dtos.ProfileDTO, error) {
+	user, err := as.userService.UpdateProfile(userID, sessionID, profileDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	if profileDTO.Email != nil && user.VerifiedAt == nil {
+		if err := as.verification.SendVerification(user); err != nil {
+			log.Printf("Could not send verification email to %s: %v", user.Email, err)
+		}
+	}
+
+	return profileDto(user), nil
+}
+
+// DeleteProfile deletes the account of the user, it can be restored by logging in during the grace period
+func (as *AuthService) DeleteProfile(userID string, deleteDTO *dtos.DeleteProfileDTO) (*time.Time, error) {
+	user, err := as.userService.DeleteProfile(userID, deleteDTO)
+	if err != nil {
+		return nil, err
+	}
+	return user.PurgeAt, nil
+}
+
+// restoreAccount restores the account deleted by its owner once every login factor was checked
+func (as *AuthService) restoreAccount(user *models.User) (*models.User, error) {
+	if !user.DeletedAt.Valid {
+		return user, nil
+	}
+	return as.userService.RestoreUser(user.ID.String())
+}
+
+func (as *AuthService) rehashPassword(user *models.User, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return as.repo.UpdatePassword(user, hashedPassword)
+}
+
+// startSession records a new session for the user and issues its first token pair
+func (as *AuthService) startSession(user *models.User, client *dtos.ClientInfoDTO, mfa bool) (*dtos.TokenDTO, error) {
+	session, err := as.sessionService.CreateSession(user, client, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return as.issueTokens(user, session)
+}
+
+// issueTokens generates an access token and a new refresh token belonging to the given session
+func (as *AuthService) issueTokens(user *models.User, session *models.Session) (*dtos.TokenDTO, error) {
+	family := session.ID.String()
+	// A session logged in with 2FA no longer counts as such once its user disabled 2FA
+	accessToken, err := utils.GenerateToken(user.ID.String(), &utils.Claims{
+		Username:      user.Username,
+		Roles:         roleNames(user.Roles),
+		SessionID:     family,
+		MFA:           session.MFA && user.TOTPEnabled,
+		EmailVerified: user.VerifiedAt != nil,
+		TokenVersion:  user.TokenVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := json.Marshal(refreshTokenRecord{Family: family})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := utils.HashToken(refreshToken)
+	ttl := utils.RefreshTokenTTL()
+
+	pipe := as.redisClient.TxPipeline()
+	pipe.Set(ctx, refreshTokenPrefix+hash, record, ttl)
+	pipe.SAdd(ctx, refreshFamilyPrefix+family, hash)
+	pipe.Expire(ctx, refreshFamilyPrefix+family, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return &dtos.TokenDTO{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// revokeTokenFamily deletes every refresh token of a family and ends its session
+func (as *AuthService) revokeTokenFamily(family string) error {
+	hashes, err := as.redisClient.SMembers(ctx, refreshFamilyPrefix+family).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := as.redisClient.TxPipeline()
+	for _, hash := range hashes {
+		pipe.Del(ctx, refreshTokenPrefix+hash)
+	}
+	pipe.Del(ctx, refreshFamilyPrefix+family)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
 	}
 
-	return userDto, nil
+	return as.sessionService.EndSession(family)
+}
+
+func profileDto(user *models.User) *dtos.ProfileDTO {
+	return &dtos.ProfileDTO{
+		Username:      user.Username,
+		Email:         user.Email,
+		Roles:         roleNames(user.Roles),
+		EmailVerified: user.VerifiedAt != nil,
+		Subscribers:   user.Subscribers,
+		Followed:      user.Followed,
+		Image:         user.Image,
+		Avatars:       user.Avatars,
+		Bio:           user.Bio,
+		Privacy:       dtos.ProfilePrivacyDTO(user.Privacy),
+		CreatedAt:     user.CreatedAt,
+	}
 }
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type fakeAuthRepository struct {
+	repositories.AuthRepository
+	user *models.User
+	// Number of times the password hash was replaced
+	passwordUpdates int
+}
+
+func (r *fakeAuthRepository) FindUserByCredentials(username, password string) (*models.User, error) {
+	if r.user.Username != username {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := utils.CheckPassword(r.user.Password, password); err != nil {
+		return nil, err
+	}
+	user := *r.user
+	return &user, nil
+}
+
+func (r *fakeAuthRepository) UpdatePassword(user *models.User, hashedPassword string) error {
+	r.passwordUpdates++
+	r.user.Password = hashedPassword
+	user.Password = hashedPassword
+	return nil
+}
+
+func (r *fakeAuthRepository) FindSelf(id string) (*models.User, error) {
+	if r.user.ID.String() != id {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := *r.user
+	return &user, nil
+}
+
+// fakeSessionRepository keeps the sessions in memory, it is used by concurrent requests
+type fakeSessionRepository struct {
+	repositories.SessionRepository
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func (r *fakeSessionRepository) CreateSession(session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID.String()] = *session
+	return nil
+}
+
+func (r *fakeSessionRepository) FindSessionById(id string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (r *fakeSessionRepository) UpdateSession(session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID.String()] = *session
+	return nil
+}
+
+func (r *fakeSessionRepository) ExtendSession(id string, seenAt, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return false, nil
+	}
+	session.LastSeenAt, session.ExpiresAt = seenAt, expiresAt
+	r.sessions[id] = session
+	return true, nil
+}
+
+type fakeLoginAttemptRepository struct {
+	repositories.LoginAttemptRepository
+}
+
+func (r *fakeLoginAttemptRepository) CreateLoginAttempt(attempt *models.LoginAttempt) error {
+	return nil
+}
+
+type authTestEnv struct {
+	auth     *AuthService
+	repo     *fakeAuthRepository
+	sessions *fakeSessionRepository
+	user     *models.User
+}
+
+func newAuthTestEnv(t *testing.T) *authTestEnv {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := utils.InitKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	user := &models.User{ID: uuid.New(), Username: "frankhill01", Email: "frank@example.com"}
+	sessions := &fakeSessionRepository{sessions: make(map[string]models.Session)}
+	repo := &fakeAuthRepository{user: user}
+	throttle := NewLoginThrottleService(&fakeLoginAttemptRepository{}, redisClient)
+	auth := NewAuthService(repo, nil, NewSessionService(sessions, redisClient), nil, nil, throttle, nil, redisClient)
+	return &authTestEnv{auth: auth, repo: repo, sessions: sessions, user: user}
+}
+
+func (env *authTestEnv) login(t *testing.T, mfa bool) *dtos.TokenDTO {
+	tokens, err := env.auth.startSession(env.user, &dtos.ClientInfoDTO{IP: "192.0.2.1"}, mfa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func (env *authTestEnv) authenticate(password string) (*dtos.TokenDTO, *dtos.TwoFactorChallengeDTO, error) {
+	return env.auth.Authenticate(&dtos.LoginUserDTO{Username: env.user.Username, Password: password, PasswordConfirmation: password},
+		&dtos.ClientInfoDTO{IP: "192.0.2.1"})
+}
+
+func (env *authTestEnv) refresh(token string) (*dtos.TokenDTO, error) {
+	return env.auth.RefreshTokens(&dtos.RefreshTokenDTO{RefreshToken: token})
+}
+
+func (env *authTestEnv) sessionOf(t *testing.T, tokens *dtos.TokenDTO) *models.Session {
+	claims, err := utils.ParseToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := env.sessions.FindSessionById(claims.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestRefreshTokensRotate(t *testing.T) {
+	env := newAuthTestEnv(t)
+	first := env.login(t, false)
+
+	second, err := env.refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := env.refresh(second.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.RefreshToken == second.RefreshToken || second.RefreshToken == third.RefreshToken {
+		t.Fatal("refreshing did not rotate the refresh token")
+	}
+	// Every token of the rotation belongs to the session of the login
+	if env.sessionOf(t, first).ID != env.sessionOf(t, third).ID {
+		t.Error("refreshing started another session")
+	}
+	if env.sessionOf(t, third).RevokedAt != nil {
+		t.Error("refreshing revoked the session")
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	env := newAuthTestEnv(t)
+	first := env.login(t, false)
+
+	second, err := env.refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := env.refresh(second.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying a rotated token means it leaked, the whole family is revoked
+	if _, err := env.refresh(first.RefreshToken); err == nil || err.Error() != "refresh token reuse detected" {
+		t.Fatalf("replaying the first token returned %v, want reuse detected", err)
+	}
+	if _, err := env.refresh(third.RefreshToken); err == nil || err.Error() != "invalid refresh token" {
+		t.Errorf("the latest token of the family still refreshes: %v", err)
+	}
+	if env.sessionOf(t, third).RevokedAt == nil {
+		t.Error("the session of the family was not revoked")
+	}
+	if env.auth.sessionService.IsSessionActive(env.sessionOf(t, third).ID.String()) {
+		t.Error("the access tokens of the family are still accepted")
+	}
+
+	// Other sessions of the user are left alone
+	other := env.login(t, false)
+	if _, err := env.refresh(other.RefreshToken); err != nil {
+		t.Errorf("refreshing another session failed: %v", err)
+	}
+}
+
+func TestConcurrentRefreshesOfOneToken(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		env := newAuthTestEnv(t)
+		login := env.login(t, false)
+
+		var wg sync.WaitGroup
+		results := make([]*dtos.TokenDTO, 2)
+		errs := make([]error, 2)
+		for j := range results {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				results[j], errs[j] = env.refresh(login.RefreshToken)
+			}(j)
+		}
+		wg.Wait()
+
+		// Only one request can rotate the token, the other one is a reuse
+		reused := 0
+		for _, err := range errs {
+			if err != nil && err.Error() == "refresh token reuse detected" {
+				reused++
+			} else if err != nil && err.Error() != "invalid refresh token" {
+				t.Fatalf("refresh failed: %v", err)
+			}
+		}
+		if reused != 1 {
+			t.Fatalf("got errors %v, want exactly one reuse detected", errs)
+		}
+
+		// The reuse revoked the family, the token the winner got does not refresh either
+		if env.sessionOf(t, login).RevokedAt == nil {
+			t.Fatal("the session was not revoked")
+		}
+		for _, tokens := range results {
+			if tokens == nil {
+				continue
+			}
+			if _, err := env.refresh(tokens.RefreshToken); err == nil {
+				t.Fatal("a token issued to the concurrent request still refreshes")
+			}
+		}
+	}
+}
+
+func TestRefreshDropsMFAOnceTwoFactorDisabled(t *testing.T) {
+	env := newAuthTestEnv(t)
+	env.user.TOTPEnabled = true
+	login := env.login(t, true)
+
+	tokens, err := env.refresh(login.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ := utils.ParseToken(tokens.AccessToken); !claims.MFA {
+		t.Fatal("a session logged in with 2FA lost its MFA claim")
+	}
+
+	env.user.TOTPEnabled = false
+	tokens, err = env.refresh(tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ := utils.ParseToken(tokens.AccessToken); claims.MFA {
+		t.Fatal("the MFA claim outlived 2FA")
+	}
+}
+
+func TestAuthenticateUpgradesBcryptHash(t *testing.T) {
+	env := newAuthTestEnv(t)
+	legacy, err := (&utils.BcryptHasher{Cost: bcrypt.MinCost}).Hash("legacy-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.Password = legacy
+
+	// A wrong password leaves the hash alone
+	if _, _, err := env.authenticate("wrong-password"); err == nil || err.Error() != "unfortunately, User not found" {
+		t.Fatalf("a wrong password returned %v", err)
+	}
+	if env.repo.passwordUpdates != 0 {
+		t.Fatal("a failed login replaced the password hash")
+	}
+
+	tokens, _, err := env.authenticate("legacy-password")
+	if err != nil || tokens == nil {
+		t.Fatalf("logging in with the bcrypt hash returned %v, %v", tokens, err)
+	}
+	if env.repo.passwordUpdates != 1 || !strings.HasPrefix(env.user.Password, "$argon2id$") {
+		t.Fatalf("the hash was replaced %d times by %q, want once by an argon2id hash", env.repo.passwordUpdates, env.user.Password)
+	}
+
+	// The upgraded hash logs in and is not replaced again
+	if _, _, err := env.authenticate("legacy-password"); err != nil {
+		t.Fatalf("logging in with the upgraded hash failed: %v", err)
+	}
+	if env.repo.passwordUpdates != 1 {
+		t.Errorf("the upgraded hash was replaced again")
+	}
+}
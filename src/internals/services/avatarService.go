@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/storage"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// Defaults of the avatar uploads, AVATAR_* env variables override them
+const (
+	defaultAvatarSizes   = "64,128,256"
+	defaultAvatarMaxSize = 2 << 20
+	// Larger images are refused before being decoded, about 4000 x 4000 pixels
+	avatarMaxPixels = 16_000_000
+)
+
+type AvatarService struct {
+	userRepo repositories.UserRepository
+	storage  storage.Storage
+}
+
+func NewAvatarService(userRepo repositories.UserRepository, storage storage.Storage) *AvatarService {
+	return &AvatarService{userRepo, storage}
+}
+
+// AvatarMaxSize returns the largest file accepted, in bytes (AVATAR_MAX_SIZE)
+func AvatarMaxSize() int {
+	return utils.IntFromEnv("AVATAR_MAX_SIZE", defaultAvatarMaxSize)
+}
+
+// AvatarSizes returns the sizes in pixels of the squares produced from an upload, largest first (AVATAR_SIZES)
+func AvatarSizes() []int {
+	if sizes := parseAvatarSizes(os.Getenv("AVATAR_SIZES")); len(sizes) > 0 {
+		return sizes
+	}
+	return parseAvatarSizes(defaultAvatarSizes)
+}
+
+func parseAvatarSizes(value string) []int {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 16 || size > 2048 {
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes
+}
+
+// SetAvatar turns the uploaded image into square JPEG thumbnails, stores them and makes the
+// largest one the image of the user. The files of the previous avatar are removed.
+func (as *AvatarService) SetAvatar(userID string, data []byte) (*dtos.ProfileDTO, error) {
+	user, err := as.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > AvatarMaxSize() {
+		return nil, errors.New("image too large")
+	}
+	square, err := utils.DecodeSquareImage(data, avatarMaxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every upload gets new keys, so cached copies of the previous avatar never show up
+	random, err := utils.GenerateOpaqueToken(9)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "avatars/" + user.ID.String() + "/" + random
+
+	sizes := AvatarSizes()
+	avatars := make(map[string]string, len(sizes))
+	var stored []string
+	for _, size := range sizes {
+		content, err := utils.EncodeJPEG(utils.ResizeSquare(square, size))
+		if err != nil {
+			as.remove(stored)
+			return nil, err
+		}
+
+		key := avatarKey(prefix, size)
+		url, err := as.storage.Put(key, content, "image/jpeg")
+		if err != nil {
+			as.remove(stored)
+			return nil, err
+		}
+		stored = append(stored, key)
+		avatars[strconv.Itoa(size)] = url
+	}
+
+	previous := avatarKeys(user)
+	user.Image = avatars[strconv.Itoa(sizes[0])]
+	user.Avatars = avatars
+	user.AvatarKey = prefix
+	if err := as.userRepo.UpdateAvatar(user); err != nil {
+		as.remove(stored)
+		return nil, err
+	}
+
+	as.remove(previous)
+	return profileDto(user), nil
+}
+
+// RemoveAvatar clears the image of the user and removes the files of the uploaded avatar
+func (as *AvatarService) RemoveAvatar(userID string) (*dtos.ProfileDTO, error) {
+	user, err := as.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := avatarKeys(user)
+	user.Image = ""
+	user.Avatars = nil
+	user.AvatarKey = ""
+	if err := as.userRepo.UpdateAvatar(user); err != nil {
+		return nil, err
+	}
+
+	as.remove(previous)
+	return profileDto(user), nil
+}
+
+func (as *AvatarService) findUser(id string) (*models.User, error) {
+	user, err := as.userRepo.FindUserById(id)
+	if err != nil || user.DeletedAt.Valid {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// remove deletes stored files, a failure only leaves an unused file behind
+func (as *AvatarService) remove(keys []string) {
+	for _, key := range keys {
+		if err := as.storage.Delete(key); err != nil {
+			log.Printf("Could not delete avatar file %s: %v", key, err)
+		}
+	}
+}
+
+// avatarKeys lists the storage keys of the current avatar files of the user
+func avatarKeys(user *models.User) []string {
+	if user.AvatarKey == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(user.Avatars))
+	for size := range user.Avatars {
+		if n, err := strconv.Atoi(size); err == nil {
+			keys = append(keys, avatarKey(user.AvatarKey, n))
+		}
+	}
+	return keys
+}
+
+func avatarKey(prefix string, size int) string {
+	return fmt.Sprintf("%s-%d.jpg", prefix, size)
+}
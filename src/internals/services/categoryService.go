@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// CategoryService manages the categories posts are filed under, admins create them
+type CategoryService struct {
+	repo repositories.CategoryRepository
+}
+
+func NewCategoryService(repo repositories.CategoryRepository) *CategoryService {
+	return &CategoryService{repo}
+}
+
+func (cs *CategoryService) ListCategories() ([]dtos.CategoryDTO, error) {
+	categories, err := cs.repo.FindCategories()
+	if err != nil {
+		return nil, err
+	}
+	categoryDtos := make([]dtos.CategoryDTO, 0, len(categories))
+	for i := range categories {
+		categoryDtos = append(categoryDtos, *categoryDto(&categories[i]))
+	}
+	return categoryDtos, nil
+}
+
+// CreateCategory adds a category, its slug is made from its name
+func (cs *CategoryService) CreateCategory(categoryDTO *dtos.CreateCategoryDTO) (*dtos.CategoryDTO, error) {
+	name, slug := normalizeCategoryName(categoryDTO.Name)
+	if err := cs.checkSlugFree(slug); err != nil {
+		return nil, err
+	}
+
+	category := &models.Category{Name: name, Slug: slug, Description: strings.TrimSpace(categoryDTO.Description)}
+	if err := cs.repo.CreateCategory(category); err != nil {
+		return nil, err
+	}
+	return categoryDto(category), nil
+}
+
+// UpdateCategory changes the given fields, a new name changes the slug too
+func (cs *CategoryService) UpdateCategory(slug string, categoryDTO *dtos.UpdateCategoryDTO) (*dtos.CategoryDTO, error) {
+	category, err := cs.findCategory(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if categoryDTO.Name != nil {
+		name, newSlug := normalizeCategoryName(*categoryDTO.Name)
+		if newSlug != category.Slug {
+			if err := cs.checkSlugFree(newSlug); err != nil {
+				return nil, err
+			}
+		}
+		category.Name, category.Slug = name, newSlug
+	}
+	if categoryDTO.Description != nil {
+		category.Description = strings.TrimSpace(*categoryDTO.Description)
+	}
+
+	if err := cs.repo.UpdateCategory(category); err != nil {
+		return nil, err
+	}
+	return categoryDto(category), nil
+}
+
+// DeleteCategory removes a category, its posts are left without category
+func (cs *CategoryService) DeleteCategory(slug string) (*models.Category, error) {
+	category, err := cs.findCategory(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.repo.DeleteCategory(category); err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+func (cs *CategoryService) findCategory(slug string) (*models.Category, error) {
+	category, err := cs.repo.FindCategoryBySlug(utils.TrimAndLower(slug))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("category not found")
+		}
+		return nil, err
+	}
+	return category, nil
+}
+
+// checkSlugFree refuses empty slugs and the slugs of other categories
+func (cs *CategoryService) checkSlugFree(slug string) error {
+	if slug == "" {
+		return errors.New("invalid category name")
+	}
+	_, err := cs.repo.FindCategoryBySlug(slug)
+	if err == nil {
+		return errors.New("category already exists")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// normalizeCategoryName works like NormalizeTagName
+func normalizeCategoryName(name string) (string, string) {
+	return NormalizeTagName(name)
+}
+
+func categoryDto(category *models.Category) *dtos.CategoryDTO {
+	return &dtos.CategoryDTO{Name: category.Name, Slug: category.Slug, Description: category.Description}
+}
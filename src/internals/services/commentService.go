@@ -0,0 +1,306 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Deepest reply of a thread, the comments at this depth cannot be replied to
+const commentMaxDepth = 8
+
+// Views of the comment list
+const (
+	CommentViewTree = "tree"
+	CommentViewFlat = "flat"
+)
+
+// CommentService manages the comments of the posts readers can see. The list is paginated
+// by top level comment, every page holds the whole threads of its comments.
+type CommentService struct {
+	repo        repositories.CommentRepository
+	userRepo    repositories.UserRepository
+	postService *PostService
+	rbacService *RBACService
+}
+
+func NewCommentService(repo repositories.CommentRepository, userRepo repositories.UserRepository, postService *PostService, rbacService *RBACService) *CommentService {
+	return &CommentService{repo, userRepo, postService, rbacService}
+}
+
+// commentCursor is the position of the last top level comment of a page, encoded into the next cursor
+type commentCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// commentEditWindow is how long the author can edit a comment after writing it (COMMENT_EDIT_WINDOW)
+func commentEditWindow() time.Duration {
+	return utils.DurationFromEnv("COMMENT_EDIT_WINDOW", 15*time.Minute)
+}
+
+// ListComments lists the threads of a post, oldest first, as a tree or flattened in reading order
+func (cs *CommentService) ListComments(claims *utils.Claims, postRef string, listQuery *dtos.CommentListQueryDTO) ([]dtos.CommentDTO, *dtos.PageMetaDTO, error) {
+	limit, err := parseLimitQuery(listQuery.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	view := CommentViewTree
+	switch listQuery.View {
+	case "", CommentViewTree:
+	case CommentViewFlat:
+		view = CommentViewFlat
+	default:
+		return nil, nil, errors.New("invalid view query")
+	}
+
+	page := &repositories.CommentPage{Limit: limit + 1}
+	if listQuery.Cursor != "" {
+		var cursor commentCursor
+		if err := utils.DecodeCursor(listQuery.Cursor, &cursor); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		if _, err := uuid.Parse(cursor.ID); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		page.After = &repositories.CommentKeyset{CreatedAt: cursor.CreatedAt, ID: cursor.ID}
+	}
+
+	post, err := cs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// One more comment than asked for tells whether there is a next page
+	roots, err := cs.repo.FindRootComments(post.ID.String(), page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &dtos.PageMetaDTO{Limit: limit}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		last := &roots[len(roots)-1]
+		meta.HasMore = true
+		meta.NextCursor, err = utils.EncodeCursor(&commentCursor{CreatedAt: last.CreatedAt, ID: last.ID.String()})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rootIDs := make([]string, 0, len(roots))
+	for i := range roots {
+		rootIDs = append(rootIDs, roots[i].ID.String())
+	}
+	replies, err := cs.repo.FindReplies(rootIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Replies are sorted by creation, so every list of children is too
+	children := make(map[uuid.UUID][]*models.Comment)
+	for i := range replies {
+		parentID := *replies[i].ParentID
+		children[parentID] = append(children[parentID], &replies[i])
+	}
+
+	commentDtos := make([]dtos.CommentDTO, 0, len(roots))
+	for i := range roots {
+		if view == CommentViewFlat {
+			commentDtos = appendFlatThread(commentDtos, &roots[i], children)
+		} else {
+			commentDtos = append(commentDtos, treeThread(&roots[i], children))
+		}
+	}
+	return commentDtos, meta, nil
+}
+
+// CreateComment writes a comment on a published post, or a reply to one of its comments
+func (cs *CommentService) CreateComment(claims *utils.Claims, postRef string, commentDTO *dtos.CreateCommentDTO) (*dtos.CommentDTO, error) {
+	post, err := cs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return nil, err
+	}
+	if post.Status != models.PostPublished || post.DeletedAt.Valid || post.CommentsClosed {
+		return nil, errors.New("comments closed")
+	}
+
+	author, err := cs.userRepo.FindUserById(claims.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	comment := &models.Comment{
+		PostID:   post.ID,
+		AuthorID: &author.ID,
+		Author:   author,
+		Body:     commentDTO.Body,
+	}
+	if commentDTO.ParentID != "" {
+		parent, err := cs.findComment(post, commentDTO.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.DeletedAt.Valid {
+			return nil, errors.New("comment not found")
+		}
+		if parent.Depth >= commentMaxDepth {
+			return nil, errors.New("thread too deep")
+		}
+		comment.ParentID = &parent.ID
+		comment.RootID = parent.RootID
+		if comment.RootID == nil {
+			comment.RootID = &parent.ID
+		}
+		comment.Depth = parent.Depth + 1
+	}
+
+	if err := cs.repo.CreateComment(comment); err != nil {
+		return nil, err
+	}
+	return commentDto(comment), nil
+}
+
+// UpdateComment changes the body of a comment, only its author can, within the edit window
+func (cs *CommentService) UpdateComment(claims *utils.Claims, postRef, id string, commentDTO *dtos.UpdateCommentDTO) (*dtos.CommentDTO, error) {
+	post, err := cs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := cs.findComment(post, id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.DeletedAt.Valid {
+		return nil, errors.New("comment not found")
+	}
+	if comment.AuthorID == nil || comment.AuthorID.String() != claims.Subject {
+		return nil, errors.New("insufficient permissions")
+	}
+	if post.CommentsClosed {
+		return nil, errors.New("comments closed")
+	}
+	if time.Since(comment.CreatedAt) > commentEditWindow() {
+		return nil, errors.New("edit window closed")
+	}
+
+	now := time.Now()
+	comment.Body = commentDTO.Body
+	comment.EditedAt = &now
+	if err := cs.repo.UpdateComment(comment); err != nil {
+		return nil, err
+	}
+	return commentDto(comment), nil
+}
+
+// DeleteComment turns a comment into a tombstone. Its author, the author of the post
+// and the users holding comments.moderate can delete it.
+func (cs *CommentService) DeleteComment(claims *utils.Claims, postRef, id string) error {
+	post, err := cs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return err
+	}
+	comment, err := cs.findComment(post, id)
+	if err != nil {
+		return err
+	}
+	if comment.DeletedAt.Valid {
+		return errors.New("comment not found")
+	}
+
+	ownComment := comment.AuthorID != nil && comment.AuthorID.String() == claims.Subject
+	if !ownComment && post.AuthorID.String() != claims.Subject {
+		if err := cs.rbacService.Authorize(claims.Subject, claims.MFA, "comments.moderate"); err != nil {
+			return err
+		}
+	}
+
+	return cs.repo.DeleteComment(comment)
+}
+
+// FindVisibleComment loads a comment, not deleted, of a post the user may read
+func (cs *CommentService) FindVisibleComment(claims *utils.Claims, postRef, id string) (*models.Post, *models.Comment, error) {
+	post, err := cs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	comment, err := cs.findComment(post, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if comment.DeletedAt.Valid {
+		return nil, nil, errors.New("comment not found")
+	}
+	return post, comment, nil
+}
+
+// findComment loads a comment of the post, deleted or not
+func (cs *CommentService) findComment(post *models.Post, id string) (*models.Comment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("comment not found")
+	}
+	comment, err := cs.repo.FindCommentById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("comment not found")
+		}
+		return nil, err
+	}
+	if comment.PostID != post.ID {
+		return nil, errors.New("comment not found")
+	}
+	return comment, nil
+}
+
+// treeThread nests the replies of the comment in it
+func treeThread(comment *models.Comment, children map[uuid.UUID][]*models.Comment) dtos.CommentDTO {
+	commentDTO := *commentDto(comment)
+	for _, child := range children[comment.ID] {
+		commentDTO.Replies = append(commentDTO.Replies, treeThread(child, children))
+	}
+	return commentDTO
+}
+
+// appendFlatThread lists the comment then its replies, depth first, as they are read
+func appendFlatThread(commentDtos []dtos.CommentDTO, comment *models.Comment, children map[uuid.UUID][]*models.Comment) []dtos.CommentDTO {
+	commentDtos = append(commentDtos, *commentDto(comment))
+	for _, child := range children[comment.ID] {
+		commentDtos = appendFlatThread(commentDtos, child, children)
+	}
+	return commentDtos
+}
+
+// commentDto shows a deleted comment as a tombstone, without author nor body
+func commentDto(comment *models.Comment) *dtos.CommentDTO {
+	commentDTO := &dtos.CommentDTO{
+		ID:        comment.ID.String(),
+		Depth:     comment.Depth,
+		CreatedAt: comment.CreatedAt,
+	}
+	if comment.ParentID != nil {
+		parentID := comment.ParentID.String()
+		commentDTO.ParentID = &parentID
+	}
+	if comment.DeletedAt.Valid {
+		commentDTO.Deleted = true
+		return commentDTO
+	}
+
+	commentDTO.Body = comment.Body
+	commentDTO.Reactions = reactionCounts(comment.Reactions)
+	commentDTO.EditedAt = comment.EditedAt
+	// Deleted authors are not shown
+	if comment.Author != nil && !comment.Author.DeletedAt.Valid {
+		commentDTO.Author = &dtos.PostAuthorDTO{Username: comment.Author.Username, Image: comment.Author.Image}
+	}
+	return commentDTO
+}
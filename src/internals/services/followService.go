@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+type FollowService struct {
+	repo     repositories.FollowRepository
+	userRepo repositories.UserRepository
+}
+
+func NewFollowService(repo repositories.FollowRepository, userRepo repositories.UserRepository) *FollowService {
+	return &FollowService{repo, userRepo}
+}
+
+// followCursor is the position of the last follow of a page, encoded into the next cursor
+type followCursor struct {
+	CreatedAt time.Time `json:"t"`
+	UserID    string    `json:"id"`
+}
+
+// Follow subscribes the user with the given ID to the user with the given username
+func (fs *FollowService) Follow(followerID, username string) error {
+	followed, err := fs.findUser(username)
+	if err != nil {
+		return err
+	}
+	if followed.ID.String() == followerID {
+		return errors.New("cannot follow yourself")
+	}
+
+	created, err := fs.repo.CreateFollow(followerID, followed.ID.String())
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errors.New("already following")
+	}
+	return nil
+}
+
+func (fs *FollowService) Unfollow(followerID, username string) error {
+	followed, err := fs.findUser(username)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := fs.repo.DeleteFollow(followerID, followed.ID.String())
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("not following")
+	}
+	return nil
+}
+
+// ListFollowers lists the users following the user, newest first. The privacy of the user applies
+// to anybody but themselves, and hidden profiles are never listed.
+func (fs *FollowService) ListFollowers(viewerID, username, limitQuery, cursorQuery string) ([]dtos.FollowDTO, *dtos.PageMetaDTO, error) {
+	return fs.list(viewerID, username, limitQuery, cursorQuery, fs.repo.FindFollowers, func(f *models.Follow) *models.User { return &f.Follower })
+}
+
+// ListFollowing lists the users the user follows, newest first, with the privacy rules of ListFollowers
+func (fs *FollowService) ListFollowing(viewerID, username, limitQuery, cursorQuery string) ([]dtos.FollowDTO, *dtos.PageMetaDTO, error) {
+	return fs.list(viewerID, username, limitQuery, cursorQuery, fs.repo.FindFollowing, func(f *models.Follow) *models.User { return &f.Followed })
+}
+
+// ReconcileCounters recomputes the follow counters of every user, returns how many were wrong
+func (fs *FollowService) ReconcileCounters() (int64, error) {
+	return fs.repo.ReconcileFollowCounters()
+}
+
+func (fs *FollowService) list(viewerID, username, limitQuery, cursorQuery string, find func(string, *repositories.FollowPage) ([]models.Follow, error), other func(*models.Follow) *models.User) ([]dtos.FollowDTO, *dtos.PageMetaDTO, error) {
+	limit, err := parseLimitQuery(limitQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	page := &repositories.FollowPage{Limit: limit + 1}
+	if cursorQuery != "" {
+		var cursor followCursor
+		if err := utils.DecodeCursor(cursorQuery, &cursor); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		if _, err := uuid.Parse(cursor.UserID); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		page.After = &repositories.FollowKeyset{CreatedAt: cursor.CreatedAt, UserID: cursor.UserID}
+	}
+
+	user, err := fs.findUser(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Like the public profile, hidden users are not found and hidden counts hide the lists as well
+	if user.ID.String() != viewerID {
+		if user.Privacy.Hidden {
+			return nil, nil, errors.New("user not found")
+		}
+		if user.Privacy.HideFollowCounts {
+			return nil, nil, errors.New("follows hidden")
+		}
+	}
+
+	// One more follow than asked for tells whether there is a next page
+	follows, err := find(user.ID.String(), page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &dtos.PageMetaDTO{Limit: limit}
+	if len(follows) > limit {
+		follows = follows[:limit]
+		last := &follows[len(follows)-1]
+		meta.HasMore = true
+		meta.NextCursor, err = utils.EncodeCursor(&followCursor{CreatedAt: last.CreatedAt, UserID: other(last).ID.String()})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	followDtos := make([]dtos.FollowDTO, 0, len(follows))
+	for i := range follows {
+		user := other(&follows[i])
+		followDtos = append(followDtos, dtos.FollowDTO{
+			Username:   user.Username,
+			Image:      user.Image,
+			Bio:        user.Bio,
+			FollowedAt: follows[i].CreatedAt,
+		})
+	}
+	return followDtos, meta, nil
+}
+
+func (fs *FollowService) findUser(username string) (*models.User, error) {
+	user, err := fs.userRepo.FindUserByUsername(utils.TrimAndLower(username))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,543 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Formats of the user imports and exports
+const (
+	TransferFormatCSV    = "csv"
+	TransferFormatNDJSON = "ndjson"
+)
+
+const (
+	// Largest file accepted by an import, IMPORT_MAX_SIZE overrides it
+	defaultImportMaxSize = 100 << 20
+	// Row errors kept on the job, the following ones are only counted
+	importMaxErrors = 1000
+	// The counters of a running job are saved every so many rows
+	importProgressEvery = 100
+	// Longest line of a NDJSON import
+	importMaxLineSize = 1 << 20
+	// Roles of a CSV cell are separated by a pipe, e.g. "writer|moderator"
+	csvRoleSeparator = "|"
+)
+
+// ImportService imports users from CSV or NDJSON files. The file is spooled to disk by the
+// controller and read row by row by a background job, one job runs at a time.
+type ImportService struct {
+	repo        repositories.ImportJobRepository
+	userRepo    repositories.UserRepository
+	roleRepo    repositories.RoleRepository
+	userService *UserService
+	rbacService *RBACService
+	running     sync.Mutex
+}
+
+func NewImportService(repo repositories.ImportJobRepository, userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, userService *UserService, rbacService *RBACService) *ImportService {
+	return &ImportService{repo: repo, userRepo: userRepo, roleRepo: roleRepo, userService: userService, rbacService: rbacService}
+}
+
+// ImportMaxSize returns the largest file accepted, in bytes (IMPORT_MAX_SIZE)
+func ImportMaxSize() int64 {
+	return int64(utils.IntFromEnv("IMPORT_MAX_SIZE", defaultImportMaxSize))
+}
+
+// ParseTransferFormat picks the format of an import or an export from the format query,
+// falling back to the content type of the request
+func ParseTransferFormat(formatQuery, contentType string) (string, error) {
+	switch utils.TrimAndLower(formatQuery) {
+	case TransferFormatCSV:
+		return TransferFormatCSV, nil
+	case TransferFormatNDJSON, "jsonl":
+		return TransferFormatNDJSON, nil
+	case "":
+	default:
+		return "", errors.New("invalid format query")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return TransferFormatCSV, nil
+	case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+		return TransferFormatNDJSON, nil
+	}
+	return "", errors.New("invalid format query")
+}
+
+// StartImport creates the job of the file and processes it in the background, the job owns
+// the file from then on and removes it once done
+func (is *ImportService) StartImport(claims *utils.Claims, path, format, dryRunQuery string) (*models.ImportJob, error) {
+	var dryRun bool
+	if dryRunQuery != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(dryRunQuery); err != nil {
+			os.Remove(path)
+			return nil, errors.New("invalid dry_run query")
+		}
+	}
+
+	createdBy, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	// Rows setting roles require the permission to assign them, rows updating existing users
+	// the permission to update them, users.import alone only creates users
+	var grants importGrants
+	if grants.assignRoles, err = is.holds(claims, "roles.assign"); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if grants.updateUsers, err = is.holds(claims, "users.update"); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	job := &models.ImportJob{
+		ID:        uuid.New(),
+		CreatedBy: createdBy,
+		Format:    format,
+		DryRun:    dryRun,
+		Status:    models.ImportJobPending,
+		Errors:    []models.ImportRowError{},
+	}
+	if err := is.repo.CreateImportJob(job); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	// The job keeps changing in the background, the caller gets it as created
+	created := *job
+	go is.run(job, path, grants)
+
+	return &created, nil
+}
+
+func (is *ImportService) GetImportJob(id string) (*models.ImportJob, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("import job not found")
+	}
+
+	job, err := is.repo.FindImportJobById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("import job not found")
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// FailInterruptedJobs fails the jobs a previous process left unfinished
+func (is *ImportService) FailInterruptedJobs() {
+	count, err := is.repo.FailUnfinishedImportJobs("interrupted by a restart, import the file again")
+	if err != nil {
+		log.Printf("Could not fail the interrupted import jobs: %v", err)
+	} else if count > 0 {
+		log.Printf("Failed %d interrupted import jobs", count)
+	}
+}
+
+// run processes the file of the job once the previous jobs are done
+func (is *ImportService) run(job *models.ImportJob, path string, grants importGrants) {
+	defer os.Remove(path)
+
+	is.running.Lock()
+	defer is.running.Unlock()
+
+	// A bug in the processing fails the job instead of the whole server
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Import job %s panicked: %v", job.ID, r)
+			finishedAt := time.Now()
+			job.FinishedAt = &finishedAt
+			job.Status = models.ImportJobFailed
+			job.Error = "internal error"
+			is.saveJob(job)
+		}
+	}()
+
+	now := time.Now()
+	job.Status = models.ImportJobRunning
+	job.StartedAt = &now
+	is.saveJob(job)
+
+	err := is.process(job, path, grants)
+
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.Status = models.ImportJobCompleted
+	if err != nil {
+		job.Status = models.ImportJobFailed
+		job.Error = err.Error()
+	}
+	is.saveJob(job)
+}
+
+func (is *ImportService) process(job *models.ImportJob, path string, grants importGrants) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	batch := &importBatch{
+		dryRun:    job.DryRun,
+		grants:    grants,
+		emails:    make(map[string]int),
+		usernames: make(map[string]int),
+		roles:     make(map[string]*models.Role),
+	}
+
+	read := readCSVRows
+	if job.Format == TransferFormatNDJSON {
+		read = readNDJSONRows
+	}
+
+	return read(bufio.NewReader(file), func(line int, row *dtos.UserImportDTO, rowErr error) {
+		job.Rows++
+		if rowErr == nil {
+			var created bool
+			if created, rowErr = is.importRow(batch, line, row); rowErr == nil {
+				if created {
+					job.Created++
+				} else {
+					job.Updated++
+				}
+			}
+		}
+		if rowErr != nil {
+			job.Failed++
+			if len(job.Errors) < importMaxErrors {
+				rowError := models.ImportRowError{Line: line, Error: rowErr.Error()}
+				if row != nil {
+					rowError.Email = row.Email
+				}
+				job.Errors = append(job.Errors, rowError)
+			}
+		}
+
+		if job.Rows%importProgressEvery == 0 {
+			is.saveJob(job)
+		}
+	})
+}
+
+// importGrants tells what the rows of a job may do besides creating users
+type importGrants struct {
+	assignRoles bool
+	updateUsers bool
+}
+
+// importBatch is what a job remembers across its rows
+type importBatch struct {
+	dryRun bool
+	grants importGrants
+	// Line of the row importing each email and username, a file cannot import them twice
+	emails    map[string]int
+	usernames map[string]int
+	// Roles already loaded, nil for the unknown ones
+	roles map[string]*models.Role
+}
+
+// importRow creates the user of the row or updates the one holding its email, a dry run
+// only checks the row. It tells whether the user is a new one.
+func (is *ImportService) importRow(batch *importBatch, line int, row *dtos.UserImportDTO) (bool, error) {
+	row.Username = utils.TrimAndLower(row.Username)
+	row.Email = utils.TrimAndLower(row.Email)
+	row.Image = strings.TrimSpace(row.Image)
+
+	if err := utils.ValidateUser(row); err != nil {
+		return false, err
+	}
+	if row.Password != "" {
+		if err := utils.ValidatePassword(row.Password, row.Username, row.Email); err != nil {
+			return false, err
+		}
+	}
+
+	if first, ok := batch.emails[row.Email]; ok {
+		return false, errors.New("duplicate email, first seen on line " + strconv.Itoa(first))
+	}
+	if first, ok := batch.usernames[row.Username]; ok {
+		return false, errors.New("duplicate username, first seen on line " + strconv.Itoa(first))
+	}
+
+	roles, err := is.importRoles(batch, row.Roles)
+	if err != nil {
+		return false, err
+	}
+
+	users, err := is.userRepo.FindUsersByEmailOrUsername(row.Email, row.Username)
+	if err != nil {
+		return false, err
+	}
+	var existing *models.User
+	for i := range users {
+		if users[i].Email == row.Email {
+			existing = &users[i]
+		}
+	}
+	for i := range users {
+		if users[i].Username == row.Username && (existing == nil || users[i].ID != existing.ID) {
+			return false, errors.New("username already in use")
+		}
+	}
+	if existing != nil && existing.DeletedAt.Valid {
+		return false, errors.New("email belongs to a deleted user, restore it first")
+	}
+	if existing != nil && !batch.grants.updateUsers {
+		return false, errors.New("updating existing users requires the users.update permission")
+	}
+
+	if !batch.dryRun {
+		if existing == nil {
+			err = is.createImportedUser(row, roles)
+		} else {
+			err = is.updateImportedUser(existing, row, roles)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Only the rows imported hold their email and username, a row fixed later in the file can use them
+	batch.emails[row.Email] = line
+	batch.usernames[row.Username] = line
+	return existing == nil, nil
+}
+
+func (is *ImportService) createImportedUser(row *dtos.UserImportDTO, roles []models.Role) error {
+	// Users imported without a password set one through the password reset
+	password := row.Password
+	if password == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(secret)
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return is.userRepo.CreateUser(&models.User{
+		ID:       uuid.New(),
+		Username: row.Username,
+		Email:    row.Email,
+		Password: hashedPassword,
+		Image:    row.Image,
+		Bio:      row.Bio,
+		Roles:    roles,
+	})
+}
+
+func (is *ImportService) updateImportedUser(user *models.User, row *dtos.UserImportDTO, roles []models.Role) error {
+	// Access tokens carry the username and the roles, changing them makes the user refresh
+	outdated := user.Username != row.Username
+	user.Username = row.Username
+	if row.Image != "" && row.Image != user.Image {
+		user.Image = row.Image
+		user.Avatars = nil
+	}
+	if row.Bio != "" {
+		user.Bio = row.Bio
+	}
+	if row.Password != "" {
+		hashedPassword, err := utils.HashPassword(row.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashedPassword
+	}
+
+	if err := is.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		if err := is.roleRepo.ReplaceUserRoles(user, roles); err != nil {
+			return err
+		}
+		outdated = true
+	}
+
+	// A new password logs the user out everywhere
+	if row.Password != "" {
+		return is.userService.logoutEverywhere(user)
+	}
+	if outdated {
+		return is.userRepo.IncrementTokenVersion(user.ID.String())
+	}
+	return nil
+}
+
+// importRoles loads the roles of a row, every name must exist
+func (is *ImportService) importRoles(batch *importBatch, names []string) ([]models.Role, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if !batch.grants.assignRoles {
+		return nil, errors.New("setting roles requires the roles.assign permission")
+	}
+
+	roles := make([]models.Role, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = utils.TrimAndLower(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		role, ok := batch.roles[name]
+		if !ok {
+			found, err := is.roleRepo.FindRolesByName([]string{name})
+			if err != nil {
+				return nil, err
+			}
+			if len(found) > 0 {
+				role = &found[0]
+			}
+			batch.roles[name] = role
+		}
+		if role == nil {
+			return nil, errors.New("unknown role: " + name)
+		}
+		roles = append(roles, *role)
+	}
+	return roles, nil
+}
+
+// holds tells whether the user of the claims holds the permission, the refusals are not errors
+func (is *ImportService) holds(claims *utils.Claims, permission string) (bool, error) {
+	err := is.rbacService.Authorize(claims.Subject, claims.MFA, permission)
+	if err == nil {
+		return true, nil
+	}
+	if err.Error() == "insufficient permissions" || err.Error() == "two-factor authentication required" {
+		return false, nil
+	}
+	return false, err
+}
+
+func (is *ImportService) saveJob(job *models.ImportJob) {
+	if err := is.repo.UpdateImportJob(job); err != nil {
+		log.Printf("Could not save import job %s: %v", job.ID, err)
+	}
+}
+
+// importRowHandler receives every row of a file with its line number, or the reason it could not be read
+type importRowHandler func(line int, row *dtos.UserImportDTO, err error)
+
+// readCSVRows reads a CSV file starting with a header, the columns are matched by name
+func readCSVRows(r io.Reader, handle importRowHandler) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("empty file")
+		}
+		return errors.New("invalid header: " + err.Error())
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Spreadsheets often start their files with a byte order mark
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[utils.TrimAndLower(name)] = i
+	}
+	for _, required := range []string{"username", "email"} {
+		if _, ok := columns[required]; !ok {
+			return errors.New("missing column: " + required)
+		}
+	}
+
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			handle(parseErr.StartLine, nil, errors.New("invalid row: "+parseErr.Err.Error()))
+			continue
+		}
+		// The position of the fields is only known for the records read without errors
+		line, _ := reader.FieldPos(0)
+
+		row := &dtos.UserImportDTO{
+			Username: cell(record, "username"),
+			Email:    cell(record, "email"),
+			Password: cell(record, "password"),
+			Image:    csvUnsafeCell(cell(record, "image")),
+			Bio:      csvUnsafeCell(cell(record, "bio")),
+		}
+		if roles := strings.TrimSpace(cell(record, "roles")); roles != "" {
+			row.Roles = strings.Split(roles, csvRoleSeparator)
+		}
+		handle(line, row, nil)
+	}
+}
+
+// readNDJSONRows reads one JSON object per line, blank lines are skipped
+func readNDJSONRows(r io.Reader, handle importRowHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var row dtos.UserImportDTO
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			handle(line, nil, errors.New("invalid JSON: "+err.Error()))
+			continue
+		}
+		handle(line, &row, nil)
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return errors.New("line " + strconv.Itoa(line+1) + " is too long")
+	}
+	return scanner.Err()
+}
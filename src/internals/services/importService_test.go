@@ -0,0 +1,191 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+)
+
+// importedRow is what a reader handed to its handler
+type importedRow struct {
+	line int
+	row  *dtos.UserImportDTO
+	err  string
+}
+
+func readRows(t *testing.T, read func(r *strings.Reader, handle importRowHandler) error, input string) ([]importedRow, error) {
+	t.Helper()
+	var rows []importedRow
+	err := read(strings.NewReader(input), func(line int, row *dtos.UserImportDTO, err error) {
+		imported := importedRow{line: line, row: row}
+		if err != nil {
+			imported.err = err.Error()
+		}
+		rows = append(rows, imported)
+	})
+	return rows, err
+}
+
+func csvReader(r *strings.Reader, handle importRowHandler) error    { return readCSVRows(r, handle) }
+func ndjsonReader(r *strings.Reader, handle importRowHandler) error { return readNDJSONRows(r, handle) }
+
+func TestReadCSVRowsReportsMalformedRows(t *testing.T) {
+	for name, test := range map[string]struct {
+		input string
+		// Line of the malformed row, then the line of the valid row read after it if any
+		badLine, nextLine int
+	}{
+		"bare quote":              {"username,email\nali\"ce01,alice@example.com\nbobsmith01,bob@example.com\n", 2, 3},
+		"quote inside quotes":     {"username,email\n\"ali\"ce01\",alice@example.com\nbobsmith01,bob@example.com\n", 2, 3},
+		"unterminated quote":      {"username,email\n\"alice01,alice@example.com\nbobsmith01,bob@example.com\n", 2, 0},
+		"unterminated at the end": {"username,email\nbobsmith01,bob@example.com\n\"alice01", 3, 0},
+		"too many fields":         {"username,email\nalice001,alice@example.com,extra\nbobsmith01,bob@example.com\n", 2, 3},
+		"too few fields":          {"username,email\nalice001\nbobsmith01,bob@example.com\n", 2, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rows, err := readRows(t, csvReader, test.input)
+			if err != nil {
+				t.Fatalf("a malformed row failed the whole file: %v", err)
+			}
+
+			var bad *importedRow
+			for i := range rows {
+				if rows[i].err != "" {
+					bad = &rows[i]
+				}
+			}
+			if bad == nil || bad.line != test.badLine || !strings.HasPrefix(bad.err, "invalid row: ") || bad.row != nil {
+				t.Fatalf("got rows %+v, want an invalid row on line %d", rows, test.badLine)
+			}
+			if test.nextLine != 0 {
+				last := rows[len(rows)-1]
+				if last.err != "" || last.line != test.nextLine || last.row.Username != "bobsmith01" {
+					t.Errorf("the row after the malformed one was read as %+v", last)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCSVRowsMatchesColumnsByName(t *testing.T) {
+	// Byte order mark of spreadsheets, columns in any order and case, unknown columns ignored
+	input := "\ufeffEmail, Username ,Notes,Roles,bio\n" +
+		"alice@example.com,alice001,ignored,writer|moderator,\"Hello,\nworld\"\n" +
+		"bob@example.com,bobsmith01,,,\n"
+
+	rows, err := readRows(t, csvReader, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	alice := rows[0]
+	if alice.line != 2 || alice.row.Email != "alice@example.com" || alice.row.Username != "alice001" {
+		t.Errorf("first row read as line %d %+v", alice.line, alice.row)
+	}
+	if strings.Join(alice.row.Roles, ",") != "writer,moderator" || alice.row.Bio != "Hello,\nworld" {
+		t.Errorf("first row has roles %v and bio %q", alice.row.Roles, alice.row.Bio)
+	}
+	// The quoted bio spans two lines, the next row starts on line 4
+	if bob := rows[1]; bob.line != 4 || bob.row.Roles != nil {
+		t.Errorf("second row read as line %d %+v", bob.line, bob.row)
+	}
+}
+
+func TestReadCSVRowsRefusesBadHeaders(t *testing.T) {
+	for input, want := range map[string]string{
+		"":                              "empty file",
+		"username,password\nalice001,x": "missing column: email",
+		"\"username,email\n":            "invalid header: ",
+	} {
+		if _, err := readRows(t, csvReader, input); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("reading %q returned %v, want %q", input, err, want)
+		}
+	}
+}
+
+func TestReadNDJSONRows(t *testing.T) {
+	input := "{\"username\":\"alice001\",\"email\":\"alice@example.com\",\"roles\":[\"writer\"]}\n" +
+		"\n" +
+		"{\"username\": \"broken\"\n" +
+		"  {\"username\":\"bobsmith01\",\"email\":\"bob@example.com\"}  \r\n"
+
+	rows, err := readRows(t, ndjsonReader, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3 (blank lines are skipped)", len(rows))
+	}
+	if rows[0].line != 1 || rows[0].row.Username != "alice001" || len(rows[0].row.Roles) != 1 {
+		t.Errorf("first row read as %+v", rows[0])
+	}
+	if rows[1].line != 3 || !strings.HasPrefix(rows[1].err, "invalid JSON: ") {
+		t.Errorf("broken row read as %+v", rows[1])
+	}
+	if rows[2].line != 4 || rows[2].row.Email != "bob@example.com" {
+		t.Errorf("last row read as %+v", rows[2])
+	}
+}
+
+func TestReadNDJSONRowsRefusesOverlongLine(t *testing.T) {
+	input := "{\"username\":\"alice001\",\"email\":\"alice@example.com\"}\n" +
+		"{\"bio\":\"" + strings.Repeat("a", importMaxLineSize) + "\"}\n" +
+		"{\"username\":\"bobsmith01\",\"email\":\"bob@example.com\"}\n"
+
+	rows, err := readRows(t, ndjsonReader, input)
+	if err == nil || err.Error() != "line 2 is too long" {
+		t.Fatalf("got %v, want line 2 is too long", err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("got %d rows before the long line, want 1", len(rows))
+	}
+}
+
+// importUserRepository knows no user, every row creates one
+type importUserRepository struct {
+	repositories.UserRepository
+}
+
+func (r *importUserRepository) FindUsersByEmailOrUsername(email, username string) ([]models.User, error) {
+	return nil, nil
+}
+
+func TestImportRowReservesEmailOnlyOnceImported(t *testing.T) {
+	is := &ImportService{userRepo: &importUserRepository{}}
+	batch := &importBatch{
+		dryRun:    true,
+		emails:    make(map[string]int),
+		usernames: make(map[string]int),
+		roles:     make(map[string]*models.Role),
+	}
+
+	// Refused for its roles, the corrected row below holds the same email and username
+	refused := &dtos.UserImportDTO{Username: "alice001", Email: "alice@example.com", Roles: []string{"admin"}}
+	if _, err := is.importRow(batch, 2, refused); err == nil {
+		t.Fatal("a row setting roles without roles.assign was imported")
+	}
+	// Refused for its email
+	if _, err := is.importRow(batch, 3, &dtos.UserImportDTO{Username: "alice001", Email: "not an email"}); err == nil {
+		t.Fatal("a row with an invalid email was imported")
+	}
+
+	created, err := is.importRow(batch, 4, &dtos.UserImportDTO{Username: "Alice001", Email: "Alice@Example.com"})
+	if err != nil || !created {
+		t.Fatalf("the corrected row returned %v, %v", created, err)
+	}
+
+	_, err = is.importRow(batch, 5, &dtos.UserImportDTO{Username: "alice002", Email: "alice@example.com"})
+	if err == nil || err.Error() != "duplicate email, first seen on line 4" {
+		t.Errorf("a second row with the email returned %v", err)
+	}
+	_, err = is.importRow(batch, 6, &dtos.UserImportDTO{Username: "alice001", Email: "alice2@example.com"})
+	if err == nil || err.Error() != "duplicate username, first seen on line 4" {
+		t.Errorf("a second row with the username returned %v", err)
+	}
+}
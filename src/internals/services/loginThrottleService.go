@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// Redis key prefixes of the failed login counters and lockouts
+const (
+	loginFailuresPrefix = "login_fail:"
+	loginLockPrefix     = "login_lock:"
+)
+
+// Reasons written to the login log
+const (
+	LoginReasonSuccess            = "success"
+	LoginReasonTwoFactorRequired  = "two_factor_required"
+	LoginReasonTwoFactorFailed    = "two_factor_failed"
+	LoginReasonInvalidCredentials = "invalid_credentials"
+	LoginReasonLocked             = "locked"
+	LoginReasonEmailNotVerified   = "email_not_verified"
+	LoginReasonPendingDeletion    = "pending_deletion"
+)
+
+// LoginLockedError is returned while a username or client IP is locked out
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many login attempts"
+}
+
+// LoginThrottleService counts failed logins per username and per client IP in Redis. Once the
+// limit is reached every further failure locks the login for twice as long as the previous one.
+type LoginThrottleService struct {
+	repo        repositories.LoginAttemptRepository
+	redisClient *redis.Client
+}
+
+func NewLoginThrottleService(repo repositories.LoginAttemptRepository, redisClient *redis.Client) *LoginThrottleService {
+	return &LoginThrottleService{repo, redisClient}
+}
+
+// CheckLocked returns a LoginLockedError if the username or the IP is currently locked out
+func (ls *LoginThrottleService) CheckLocked(username, ip string) error {
+	var retryAfter time.Duration
+	for _, key := range []string{userLockKey(username), ipLockKey(ip)} {
+		ttl, err := ls.redisClient.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LoginLockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RegisterFailure counts a failed login and locks the username or IP once their limit is reached
+func (ls *LoginThrottleService) RegisterFailure(username, ip string) error {
+	if err := ls.registerFailure("user:"+username, userLockKey(username), loginLimitFromEnv("LOGIN_MAX_ATTEMPTS", 5)); err != nil {
+		return err
+	}
+	return ls.registerFailure("ip:"+ip, ipLockKey(ip), loginLimitFromEnv("LOGIN_MAX_ATTEMPTS_PER_IP", 20))
+}
+
+// RegisterSuccess forgets the failures of the username, the IP counter keeps running
+func (ls *LoginThrottleService) RegisterSuccess(username string) error {
+	return ls.redisClient.Del(ctx, loginFailuresPrefix+"user:"+username).Err()
+}
+
+// RegisterTwoFactorFailure counts a wrong second factor. The counter survives correct passwords so
+// new challenges do not give new guesses, reaching the limit locks the username like password failures.
+func (ls *LoginThrottleService) RegisterTwoFactorFailure(username, ip string) error {
+	if err := ls.registerFailure("2fa:"+username, userLockKey(username), loginLimitFromEnv("LOGIN_MAX_ATTEMPTS", 5)); err != nil {
+		return err
+	}
+	return ls.registerFailure("ip:"+ip, ipLockKey(ip), loginLimitFromEnv("LOGIN_MAX_ATTEMPTS_PER_IP", 20))
+}
+
+// RegisterTwoFactorSuccess forgets the failures of both factors of the username
+func (ls *LoginThrottleService) RegisterTwoFactorSuccess(username string) error {
+	return ls.redisClient.Del(ctx, loginFailuresPrefix+"user:"+username, loginFailuresPrefix+"2fa:"+username).Err()
+}
+
+// Unlock lifts the lockout of a username and resets its counters
+func (ls *LoginThrottleService) Unlock(username string) error {
+	return ls.redisClient.Del(ctx, loginFailuresPrefix+"user:"+username, loginFailuresPrefix+"2fa:"+username, userLockKey(username)).Err()
+}
+
+// RecordAttempt writes the attempt to the login log, failing to do so must not block the login
+func (ls *LoginThrottleService) RecordAttempt(username string, client *dtos.ClientInfoDTO, reason string) {
+	attempt := &models.LoginAttempt{
+		ID:       uuid.New(),
+		Username: username,
+		Success:  reason == LoginReasonSuccess || reason == LoginReasonTwoFactorRequired,
+		Reason:   reason,
+	}
+	if client != nil {
+		attempt.IP = client.IP
+		attempt.UserAgent = client.UserAgent
+	}
+
+	if err := ls.repo.CreateLoginAttempt(attempt); err != nil {
+		log.Printf("Could not record login attempt: %v", err)
+	}
+}
+
+// GetLoginAttempts lists the latest attempts, optionally filtered
+func (ls *LoginThrottleService) GetLoginAttempts(username, ip, successQuery, limitQuery string) ([]models.LoginAttempt, error) {
+	var success *bool
+	if successQuery != "" {
+		value, err := strconv.ParseBool(successQuery)
+		if err != nil {
+			return nil, errors.New("invalid success query")
+		}
+		success = &value
+	}
+
+	limit := 50
+	if limitQuery != "" {
+		value, err := strconv.Atoi(limitQuery)
+		if err != nil || value < 1 || value > 200 {
+			return nil, errors.New("invalid limit query")
+		}
+		limit = value
+	}
+
+	return ls.repo.FindLoginAttempts(utils.TrimAndLower(username), ip, success, limit)
+}
+
+func (ls *LoginThrottleService) registerFailure(counter, lockKey string, limit int64) error {
+	key := loginFailuresPrefix + counter
+
+	failures, err := ls.redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	// Failures are counted over a sliding window restarted by every failure
+	if err := ls.redisClient.Expire(ctx, key, loginFailureWindow()).Err(); err != nil {
+		return err
+	}
+
+	if failures < limit {
+		return nil
+	}
+
+	// Exponential backoff: base, 2*base, 4*base... capped
+	lock := utils.DurationFromEnv("LOGIN_LOCKOUT_BASE", time.Minute)
+	maxLock := utils.DurationFromEnv("LOGIN_LOCKOUT_MAX", time.Hour)
+	for i := limit; i < failures && lock < maxLock; i++ {
+		lock *= 2
+	}
+	if lock > maxLock {
+		lock = maxLock
+	}
+
+	return ls.redisClient.Set(ctx, lockKey, failures, lock).Err()
+}
+
+func loginFailureWindow() time.Duration {
+	return utils.DurationFromEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+}
+
+func loginLimitFromEnv(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+func userLockKey(username string) string {
+	return loginLockPrefix + "user:" + username
+}
+
+func ipLockKey(ip string) string {
+	return loginLockPrefix + "ip:" + ip
+}
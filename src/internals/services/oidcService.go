@@ -0,0 +1,402 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/oidc"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStatePrefix = "oidc_state:"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// Characters kept when deriving a username from the provider profile
+var usernameCleaner = regexp.MustCompile(`[^a-z0-9]+`)
+
+// oidcState is stored in Redis between the start of the flow and the callback
+type oidcState struct {
+	Provider   string `json:"provider"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+}
+
+type OIDCService struct {
+	providers   map[string]*oidc.Provider
+	repo        repositories.IdentityRepository
+	userRepo    repositories.UserRepository
+	authService *AuthService
+	redisClient *redis.Client
+}
+
+func NewOIDCService(repo repositories.IdentityRepository, userRepo repositories.UserRepository, authService *AuthService, redisClient *redis.Client) *OIDCService {
+	providers := oidc.LoadProviders(func(name string) string {
+		return appURL("/api/auth/" + name + "/callback")
+	})
+	return &OIDCService{providers, repo, userRepo, authService, redisClient}
+}
+
+// Providers returns the names of the configured providers
+func (s *OIDCService) Providers() []string {
+	return oidc.Names(s.providers)
+}
+
+// StartLogin returns the URL of the provider the user has to be redirected to and the state of the flow,
+// the state has to be kept in the browser starting the flow
+func (s *OIDCService) StartLogin(providerName string) (string, string, error) {
+	return s.start(providerName, "")
+}
+
+// StartLink starts the flow linking a provider account to the authenticated user
+func (s *OIDCService) StartLink(providerName, userID string) (string, string, error) {
+	user, err := s.userRepo.FindUserById(userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", errors.New("user not found")
+		}
+		return "", "", err
+	}
+
+	return s.start(providerName, user.ID.String())
+}
+
+// Callback finishes the flow: it either logs the user in, possibly provisioning the account,
+// or links the provider account when the flow was started with StartLink.
+// browserState is the state kept by the browser, the flow is only finished by the browser which started it.
+func (s *OIDCService) Callback(providerName, code, stateKey, browserState string, client *dtos.ClientInfoDTO) (*dtos.ExternalLoginDTO, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, errors.New("provider not found")
+	}
+	if code == "" || stateKey == "" {
+		return nil, errors.New("invalid state")
+	}
+	// Checked before the state is used, a forged callback does not spend it
+	if subtle.ConstantTimeCompare([]byte(stateKey), []byte(browserState)) != 1 {
+		return nil, errors.New("invalid state")
+	}
+
+	// The state can only be used once
+	raw, err := s.redisClient.GetDel(ctx, oidcStatePrefix+stateKey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errors.New("invalid state")
+		}
+		return nil, err
+	}
+
+	var state oidcState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil, err
+	}
+	if state.Provider != provider.Name {
+		return nil, errors.New("invalid state")
+	}
+
+	claims, err := provider.Exchange(code, state.Verifier, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.LinkUserID != "" {
+		identity, err := s.link(provider, claims, state.LinkUserID)
+		if err != nil {
+			return nil, err
+		}
+		return &dtos.ExternalLoginDTO{Linked: identityDto(identity)}, nil
+	}
+
+	user, err := s.findOrProvision(provider, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, challenge, err := s.authService.CompleteExternalLogin(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &dtos.ExternalLoginDTO{Tokens: tokens, Challenge: challenge}, nil
+}
+
+// GetIdentities lists the provider accounts linked to the user
+func (s *OIDCService) GetIdentities(userID string) ([]dtos.IdentityDTO, error) {
+	user, err := s.userRepo.FindUserById(userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	identities, err := s.repo.FindUserIdentities(user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	identityDtos := make([]dtos.IdentityDTO, 0, len(identities))
+	for i := range identities {
+		identityDtos = append(identityDtos, *identityDto(&identities[i]))
+	}
+	return identityDtos, nil
+}
+
+// Unlink removes a provider account from the user, the password keeps working
+func (s *OIDCService) Unlink(userID, identityID string) error {
+	user, err := s.userRepo.FindUserById(userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	if _, err := uuid.Parse(identityID); err != nil {
+		return errors.New("identity not found")
+	}
+
+	deleted, err := s.repo.DeleteIdentity(identityID, user.ID.String())
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("identity not found")
+	}
+	return nil
+}
+
+func (s *OIDCService) start(providerName, linkUserID string) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", errors.New("provider not found")
+	}
+
+	state, err := utils.GenerateOpaqueToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.GenerateOpaqueToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthorizationURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	record, err := json.Marshal(oidcState{
+		Provider:   provider.Name,
+		Verifier:   verifier,
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.redisClient.Set(ctx, oidcStatePrefix+state, record, oidcStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+func (s *OIDCService) link(provider *oidc.Provider, claims *oidc.IDTokenClaims, userID string) (*models.Identity, error) {
+	identity, err := s.repo.FindIdentity(provider.Name, claims.Subject)
+	if err == nil {
+		if identity.UserID.String() != userID {
+			return nil, errors.New("identity already linked")
+		}
+		return identity, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindUserById(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.createIdentity(provider, claims, user)
+}
+
+// findOrProvision returns the user of the provider account, linking by email or creating the
+// account when the provider configuration allows it
+func (s *OIDCService) findOrProvision(provider *oidc.Provider, claims *oidc.IDTokenClaims) (*models.User, error) {
+	identity, err := s.repo.FindIdentity(provider.Name, claims.Subject)
+	if err == nil {
+		user, err := s.findActiveUser(identity.UserID.String())
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		identity.LastLoginAt = &now
+		identity.Email = claims.Email
+		if err := s.repo.UpdateIdentity(identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Accounts are only matched or created from addresses the provider vouches for
+	email := utils.TrimAndLower(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return nil, errors.New("email not verified by provider")
+	}
+
+	existing, err := s.userRepo.FindUserByEmail(email)
+	if err == nil {
+		// An unverified address may have been registered by somebody else, its owner links from their profile
+		if !provider.LinkByEmail || existing.VerifiedAt == nil {
+			return nil, errors.New("account exists")
+		}
+		if _, err := s.createIdentity(provider, claims, existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !provider.AutoProvision || !provider.DomainAllowed(email) {
+		return nil, errors.New("provisioning not allowed")
+	}
+
+	user, err := s.provisionUser(claims, email)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.createIdentity(provider, claims, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *OIDCService) findActiveUser(id string) (*models.User, error) {
+	user, err := s.userRepo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletedAt.Valid {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// provisionUser creates an account for a provider user. It gets a random password,
+// the user can set one through the forgot password flow.
+func (s *OIDCService) provisionUser(claims *oidc.IDTokenClaims, email string) (*models.User, error) {
+	username, err := s.availableUsername(claims, email)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := utils.GenerateOpaqueToken(24)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:         uuid.New(),
+		Username:   username,
+		Email:      email,
+		Password:   hashedPassword,
+		VerifiedAt: &now,
+	}
+	if err := s.userRepo.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// availableUsername derives a username matching the registration rules from the profile
+func (s *OIDCService) availableUsername(claims *oidc.IDTokenClaims, email string) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+	base = usernameCleaner.ReplaceAllString(strings.ToLower(base), "")
+	for len(base) < 8 {
+		base += "user"
+	}
+	if len(base) > 26 {
+		base = base[:26]
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		// Deleted users keep their username, it cannot be taken before they are purged
+		taken, err := s.userRepo.UsernameTaken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%06d", base, rand.Intn(1000000))
+	}
+	return "", errors.New("could not find an available username")
+}
+
+func (s *OIDCService) createIdentity(provider *oidc.Provider, claims *oidc.IDTokenClaims, user *models.User) (*models.Identity, error) {
+	now := time.Now()
+	identity := &models.Identity{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		Provider:    provider.Name,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}
+	if err := s.repo.CreateIdentity(identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func identityDto(identity *models.Identity) *dtos.IdentityDTO {
+	return &dtos.IdentityDTO{
+		ID:          identity.ID.String(),
+		Provider:    identity.Provider,
+		Email:       identity.Email,
+		LinkedAt:    identity.CreatedAt,
+		LastLoginAt: identity.LastLoginAt,
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Items listed per page by default and at most
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// parseLimitQuery reads the page size of a list, the default one when not given
+func parseLimitQuery(limitQuery string) (int, error) {
+	if limitQuery == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(limitQuery)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, errors.New("invalid limit query")
+	}
+	return limit, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/mailer"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetTTL    = time.Hour
+	passwordResetDelay  = time.Minute
+	passwordResetPrefix = "password_reset:"
+)
+
+type PasswordResetService struct {
+	userRepo       repositories.UserRepository
+	repo           repositories.PasswordResetRepository
+	sessionService *SessionService
+	mailer         mailer.Mailer
+	redisClient    *redis.Client
+}
+
+func NewPasswordResetService(userRepo repositories.UserRepository, repo repositories.PasswordResetRepository, sessionService *SessionService, mailer mailer.Mailer, redisClient *redis.Client) *PasswordResetService {
+	return &PasswordResetService{userRepo, repo, sessionService, mailer, redisClient}
+}
+
+// ForgotPassword emails a reset link to the owner of the address. Whether the address exists or
+// not the outcome looks the same to the caller, and the email is sent in the background so the
+// response time does not tell either.
+func (ps *PasswordResetService) ForgotPassword(forgotDTO *dtos.ForgotPasswordDTO) error {
+	forgotDTO.Email = utils.TrimAndLower(forgotDTO.Email)
+	if err := utils.ValidateUser(forgotDTO); err != nil {
+		return err
+	}
+
+	// One email per address per passwordResetDelay
+	allowed, err := ps.redisClient.SetNX(ctx, passwordResetPrefix+forgotDTO.Email, 1, passwordResetDelay).Result()
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	go func(email string) {
+		if err := ps.sendResetLink(email); err != nil {
+			log.Printf("Could not send password reset email: %v", err)
+		}
+	}(forgotDTO.Email)
+
+	return nil
+}
+
+// ResetPassword sets the new password, consumes the token and logs the user out everywhere
+func (ps *PasswordResetService) ResetPassword(resetDTO *dtos.ResetPasswordDTO) error {
+	if err := utils.ValidateUser(resetDTO); err != nil {
+		return err
+	}
+
+	reset, err := ps.repo.FindPasswordResetByHash(utils.HashToken(resetDTO.Token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid reset token")
+		}
+		return err
+	}
+
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return errors.New("invalid reset token")
+	}
+
+	user, err := ps.userRepo.FindUserById(reset.UserID.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid reset token")
+		}
+		return err
+	}
+
+	// A refused password must not burn the token, it is checked before consuming it
+	if err := utils.ValidatePassword(resetDTO.Password, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(resetDTO.Password)
+	if err != nil {
+		return err
+	}
+
+	// The token is only spent along with the new password, the other links requested are invalidated
+	// and the token version goes up so whoever knew the old password does not stay logged in
+	used, err := ps.repo.UsePasswordReset(reset, hashedPassword)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errors.New("invalid reset token")
+	}
+
+	_, err = ps.sessionService.RevokeUserSessions(user.ID.String())
+	return err
+}
+
+func (ps *PasswordResetService) sendResetLink(email string) error {
+	user, err := ps.userRepo.FindUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+
+	reset := &models.PasswordReset{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if err := ps.repo.CreatePasswordReset(reset); err != nil {
+		return err
+	}
+
+	link := appURL("/reset-password?token=" + url.QueryEscape(token))
+	return ps.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open the link below:\n\n%s\n\n"+
+			"Or send this token to POST /api/password/reset: %s\n\nThe link expires in %d minutes. If you did not ask for it, ignore this email.\n",
+			user.Username, link, token, int(passwordResetTTL.Minutes())),
+	})
+}
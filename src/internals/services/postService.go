@@ -0,0 +1,546 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// postTransitions lists the statuses a post can move to from each status
+var postTransitions = map[string][]string{
+	models.PostDraft:     {models.PostPublished},
+	models.PostPublished: {models.PostDraft, models.PostArchived},
+	models.PostArchived:  {models.PostPublished, models.PostDraft},
+}
+
+// Formats the content of a post is returned in
+const (
+	PostFormatMarkdown = "markdown"
+	PostFormatHTML     = "html"
+	PostFormatText     = "text"
+)
+
+// PostService manages the posts. Only their author edits them, unless the user holds posts.manage.
+type PostService struct {
+	repo         repositories.PostRepository
+	userRepo     repositories.UserRepository
+	tagRepo      repositories.TagRepository
+	categoryRepo repositories.CategoryRepository
+	rbacService  *RBACService
+}
+
+func NewPostService(repo repositories.PostRepository, userRepo repositories.UserRepository, tagRepo repositories.TagRepository, categoryRepo repositories.CategoryRepository, rbacService *RBACService) *PostService {
+	return &PostService{repo, userRepo, tagRepo, categoryRepo, rbacService}
+}
+
+// postCursor is the position of the last post of a page, encoded into the next cursor.
+// The list it belongs to is kept so a cursor is not reused with other filters.
+type postCursor struct {
+	List string    `json:"l"`
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+// CreatePost writes a new post of the user, a draft unless another status is given.
+// The DTO is validated by the controller.
+func (ps *PostService) CreatePost(claims *utils.Claims, postDTO *dtos.CreatePostDTO, formatQuery string) (*dtos.PostDTO, error) {
+	format, err := parsePostFormat(formatQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := ps.userRepo.FindUserById(claims.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	slug, err := newPostSlug(postDTO.Title)
+	if err != nil {
+		return nil, err
+	}
+	post := &models.Post{
+		AuthorID:       author.ID,
+		Author:         *author,
+		Slug:           slug,
+		Title:          postDTO.Title,
+		Content:        postDTO.Content,
+		Status:         models.PostDraft,
+		CommentsClosed: postDTO.CommentsClosed,
+	}
+	if postDTO.Status != "" {
+		setPostStatus(post, postDTO.Status)
+	}
+	if err := renderPost(post); err != nil {
+		return nil, err
+	}
+	if err := ps.setCategory(post, postDTO.Category); err != nil {
+		return nil, err
+	}
+	if post.Tags, err = ps.findOrCreateTags(postDTO.Tags); err != nil {
+		return nil, err
+	}
+
+	if err := ps.repo.CreatePost(post); err != nil {
+		return nil, err
+	}
+	return postDto(post, format), nil
+}
+
+// ListPosts lists one page of posts, newest first. Published posts are public, the drafts,
+// archived and deleted posts are only listed to their author and to the users holding posts.manage.
+func (ps *PostService) ListPosts(claims *utils.Claims, listQuery *dtos.PostListQueryDTO) ([]dtos.PostDTO, *dtos.PageMetaDTO, error) {
+	limit, err := parseLimitQuery(listQuery.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := parsePostFormat(listQuery.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := &repositories.PostFilter{Limit: limit + 1, Status: models.PostPublished, SortColumn: "published_at"}
+	if listQuery.Status != "" {
+		if _, ok := postTransitions[listQuery.Status]; !ok {
+			return nil, nil, errors.New("invalid status query")
+		}
+		filter.Status = listQuery.Status
+	}
+	if listQuery.Deleted != "" {
+		if filter.Deleted, err = strconv.ParseBool(listQuery.Deleted); err != nil {
+			return nil, nil, errors.New("invalid deleted query")
+		}
+	}
+	// Posts which were never published are sorted by creation
+	if filter.Status != models.PostPublished || filter.Deleted {
+		filter.SortColumn = "created_at"
+	}
+
+	if listQuery.Author != "" {
+		author, err := ps.userRepo.FindUserByUsername(utils.TrimAndLower(listQuery.Author))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, errors.New("user not found")
+			}
+			return nil, nil, err
+		}
+		filter.AuthorID = author.ID.String()
+	}
+	if listQuery.Tag != "" {
+		tag, err := ps.tagRepo.FindTagBySlug(utils.TrimAndLower(listQuery.Tag))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, errors.New("tag not found")
+			}
+			return nil, nil, err
+		}
+		filter.TagID = tag.ID.String()
+	}
+	if listQuery.Category != "" {
+		category, err := ps.categoryRepo.FindCategoryBySlug(utils.TrimAndLower(listQuery.Category))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, errors.New("category not found")
+			}
+			return nil, nil, err
+		}
+		filter.CategoryID = category.ID.String()
+	}
+
+	if filter.Status != models.PostPublished || filter.Deleted {
+		if claims == nil {
+			return nil, nil, errors.New("authentication required")
+		}
+		manager, err := ps.canManage(claims)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Authors list their own hidden posts by default
+		switch {
+		case manager:
+		case filter.AuthorID == "":
+			filter.AuthorID = claims.Subject
+		case filter.AuthorID != claims.Subject:
+			return nil, nil, errors.New("insufficient permissions")
+		}
+	}
+
+	list := filter.Status + ":" + strconv.FormatBool(filter.Deleted)
+	if listQuery.Cursor != "" {
+		var cursor postCursor
+		if err := utils.DecodeCursor(listQuery.Cursor, &cursor); err != nil || cursor.List != list {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		if _, err := uuid.Parse(cursor.ID); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		filter.After = &repositories.PostKeyset{Time: cursor.Time, ID: cursor.ID}
+	}
+
+	// One more post than asked for tells whether there is a next page
+	posts, err := ps.repo.FindPosts(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &dtos.PageMetaDTO{Limit: limit}
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := &posts[len(posts)-1]
+		position := &postCursor{List: list, Time: last.CreatedAt, ID: last.ID.String()}
+		if filter.SortColumn == "published_at" {
+			position.Time = *last.PublishedAt
+		}
+		meta.HasMore = true
+		if meta.NextCursor, err = utils.EncodeCursor(position); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	postDtos := make([]dtos.PostDTO, 0, len(posts))
+	for i := range posts {
+		postDtos = append(postDtos, *postDto(&posts[i], format))
+	}
+	return postDtos, meta, nil
+}
+
+// GetPost finds a post by ID or slug. Posts which are not published, or whose author is deleted,
+// are only found by their author and by the users holding posts.manage.
+func (ps *PostService) GetPost(claims *utils.Claims, idOrSlug, formatQuery string) (*dtos.PostDTO, error) {
+	format, err := parsePostFormat(formatQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	post, err := ps.FindVisiblePost(claims, idOrSlug)
+	if err != nil {
+		return nil, err
+	}
+	return postDto(post, format), nil
+}
+
+// FindVisiblePost loads a post by ID or slug the user may read, claims are nil for anonymous users
+func (ps *PostService) FindVisiblePost(claims *utils.Claims, idOrSlug string) (*models.Post, error) {
+	post, err := ps.findPost(idOrSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	if post.Status != models.PostPublished || post.DeletedAt.Valid || post.Author.DeletedAt.Valid {
+		if claims == nil {
+			return nil, errors.New("post not found")
+		}
+		if err := ps.authorizeEdit(claims, post); err != nil {
+			if err.Error() == "insufficient permissions" {
+				return nil, errors.New("post not found")
+			}
+			return nil, err
+		}
+	}
+	return post, nil
+}
+
+// UpdatePost changes the given fields of a post, the status only along postTransitions
+func (ps *PostService) UpdatePost(claims *utils.Claims, id string, postDTO *dtos.UpdatePostDTO, formatQuery string) (*dtos.PostDTO, error) {
+	format, err := parsePostFormat(formatQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	post, err := ps.findEditablePost(claims, id)
+	if err != nil {
+		return nil, err
+	}
+	if post.DeletedAt.Valid {
+		return nil, errors.New("post not found")
+	}
+
+	if postDTO.Title != nil {
+		post.Title = *postDTO.Title
+	}
+	if postDTO.Content != nil && *postDTO.Content != post.Content {
+		post.Content = *postDTO.Content
+		if err := renderPost(post); err != nil {
+			return nil, err
+		}
+	}
+	if postDTO.CommentsClosed != nil {
+		post.CommentsClosed = *postDTO.CommentsClosed
+	}
+	if postDTO.Status != nil && *postDTO.Status != post.Status {
+		if !canTransition(post.Status, *postDTO.Status) {
+			return nil, errors.New("invalid status transition")
+		}
+		setPostStatus(post, *postDTO.Status)
+	}
+	if postDTO.Category != nil {
+		if err := ps.setCategory(post, *postDTO.Category); err != nil {
+			return nil, err
+		}
+	}
+	var tags []models.Tag
+	if postDTO.Tags != nil {
+		if tags, err = ps.findOrCreateTags(*postDTO.Tags); err != nil {
+			return nil, err
+		}
+		post.Tags = tags
+	}
+
+	if err := ps.repo.UpdatePost(post, tags); err != nil {
+		// Deleted since it was read
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("post not found")
+		}
+		return nil, err
+	}
+	return postDto(post, format), nil
+}
+
+// DeletePost deletes a post softly, or for good with force
+func (ps *PostService) DeletePost(claims *utils.Claims, forceQuery string, id string) (*models.Post, error) {
+	var force bool
+	if forceQuery != "" {
+		var err error
+		if force, err = strconv.ParseBool(forceQuery); err != nil {
+			return nil, errors.New("invalid force query")
+		}
+	}
+
+	post, err := ps.findEditablePost(claims, id)
+	if err != nil {
+		return nil, err
+	}
+	// A soft deleted post can only be deleted for good
+	if post.DeletedAt.Valid && !force {
+		return nil, errors.New("post not found")
+	}
+
+	if err := ps.repo.DeletePost(force, post); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("post not found")
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+// RestorePost brings back a soft deleted post, restoring a post which is not deleted does nothing
+func (ps *PostService) RestorePost(claims *utils.Claims, id string) (*models.Post, error) {
+	post, err := ps.findEditablePost(claims, id)
+	if err != nil {
+		return nil, err
+	}
+	if !post.DeletedAt.Valid {
+		return post, nil
+	}
+
+	if err := ps.repo.RestorePost(post); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("post not found")
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+// ReconcileCounters recomputes the published posts counter of every user, returns how many were wrong
+func (ps *PostService) ReconcileCounters() (int64, error) {
+	return ps.repo.ReconcilePostCounters()
+}
+
+// findPost loads a post by ID, or by slug when the reference is not a UUID
+func (ps *PostService) findPost(idOrSlug string) (*models.Post, error) {
+	var post *models.Post
+	var err error
+	if _, parseErr := uuid.Parse(idOrSlug); parseErr == nil {
+		post, err = ps.repo.FindPostById(idOrSlug)
+	} else {
+		post, err = ps.repo.FindPostBySlug(idOrSlug)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("post not found")
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+// findEditablePost loads a post the user may change, the others are not found
+// unless they are published, so their existence is not leaked
+func (ps *PostService) findEditablePost(claims *utils.Claims, id string) (*models.Post, error) {
+	post, err := ps.findPost(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.authorizeEdit(claims, post); err != nil {
+		if err.Error() == "insufficient permissions" && !post.IsCounted() {
+			return nil, errors.New("post not found")
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+// authorizeEdit lets the author change their post, anybody else needs posts.manage
+func (ps *PostService) authorizeEdit(claims *utils.Claims, post *models.Post) error {
+	if post.AuthorID.String() == claims.Subject {
+		return nil
+	}
+	return ps.rbacService.Authorize(claims.Subject, claims.MFA, "posts.manage")
+}
+
+// canManage tells whether the user holds posts.manage
+func (ps *PostService) canManage(claims *utils.Claims) (bool, error) {
+	err := ps.rbacService.Authorize(claims.Subject, claims.MFA, "posts.manage")
+	if err == nil {
+		return true, nil
+	}
+	if err.Error() == "insufficient permissions" || err.Error() == "two-factor authentication required" {
+		return false, nil
+	}
+	return false, err
+}
+
+// setCategory files the post under the category with the given slug, or under none when empty
+func (ps *PostService) setCategory(post *models.Post, slug string) error {
+	if slug = utils.TrimAndLower(slug); slug == "" {
+		post.CategoryID, post.Category = nil, nil
+		return nil
+	}
+	category, err := ps.categoryRepo.FindCategoryBySlug(slug)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("unknown category")
+		}
+		return err
+	}
+	post.CategoryID, post.Category = &category.ID, category
+	return nil
+}
+
+// findOrCreateTags loads the tags with the given names, creating the missing ones.
+// Names giving the same slug are the same tag, the first name is kept for a new tag.
+func (ps *PostService) findOrCreateTags(names []string) ([]models.Tag, error) {
+	tags := []models.Tag{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name, slug := NormalizeTagName(name)
+		if slug == "" {
+			return nil, errors.New("invalid tag")
+		}
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, models.Tag{Name: name, Slug: slug})
+	}
+	return ps.tagRepo.FindOrCreateTags(tags)
+}
+
+func canTransition(from, to string) bool {
+	for _, status := range postTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setPostStatus changes the status, the first publication is dated
+func setPostStatus(post *models.Post, status string) {
+	post.Status = status
+	if status == models.PostPublished && post.PublishedAt == nil {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
+}
+
+// newPostSlug makes a slug from the title, the random suffix keeps it unique
+func newPostSlug(title string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	slug := utils.Slugify(title)
+	if slug == "" {
+		slug = "post"
+	}
+	return slug + "-" + hex.EncodeToString(suffix), nil
+}
+
+// parsePostFormat reads the format the content of posts is returned in, Markdown by default
+func parsePostFormat(formatQuery string) (string, error) {
+	switch formatQuery {
+	case "":
+		return PostFormatMarkdown, nil
+	case PostFormatMarkdown, PostFormatHTML, PostFormatText:
+		return formatQuery, nil
+	}
+	return "", errors.New("invalid format query")
+}
+
+// renderPost renders the Markdown of the post into the HTML and the excerpt kept with it
+func renderPost(post *models.Post) error {
+	rendered, err := utils.RenderMarkdown(post.Content)
+	if err != nil {
+		return err
+	}
+	post.ContentHTML = rendered
+	post.Excerpt = utils.Excerpt(utils.HTMLToText(rendered))
+	return nil
+}
+
+func postDto(post *models.Post, format string) *dtos.PostDTO {
+	// Posts saved before the rendering existed are rendered when read
+	if post.ContentHTML == "" && post.Content != "" {
+		if err := renderPost(post); err != nil {
+			log.Printf("Could not render post %s: %v", post.ID, err)
+		}
+	}
+
+	postDTO := &dtos.PostDTO{
+		ID:             post.ID.String(),
+		Slug:           post.Slug,
+		Title:          post.Title,
+		Excerpt:        post.Excerpt,
+		Format:         format,
+		Content:        post.Content,
+		Status:         post.Status,
+		CommentsClosed: post.CommentsClosed,
+		Reactions:      reactionCounts(post.Reactions),
+		Author:         dtos.PostAuthorDTO{Username: post.Author.Username, Image: post.Author.Image},
+		PublishedAt:    post.PublishedAt,
+		CreatedAt:      post.CreatedAt,
+		UpdatedAt:      post.UpdatedAt,
+	}
+	if post.Category != nil {
+		postDTO.Category = &dtos.PostTagDTO{Name: post.Category.Name, Slug: post.Category.Slug}
+	}
+	postDTO.Tags = make([]dtos.PostTagDTO, 0, len(post.Tags))
+	for _, tag := range post.Tags {
+		postDTO.Tags = append(postDTO.Tags, dtos.PostTagDTO{Name: tag.Name, Slug: tag.Slug})
+	}
+	switch format {
+	case PostFormatHTML:
+		postDTO.Content = post.ContentHTML
+	case PostFormatText:
+		postDTO.Content = utils.HTMLToText(post.ContentHTML)
+	}
+	if post.DeletedAt.Valid {
+		postDTO.DeletedAt = &post.DeletedAt.Time
+	}
+	return postDTO
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+type RBACService struct {
+	repo     repositories.RoleRepository
+	userRepo repositories.UserRepository
+}
+
+func NewRBACService(repo repositories.RoleRepository, userRepo repositories.UserRepository) *RBACService {
+	return &RBACService{repo, userRepo}
+}
+
+// Authorize checks the roles of the user grant every permission. Roles requiring a second
+// factor refuse users who logged in without one, whatever the permissions asked for.
+func (rs *RBACService) Authorize(userID string, mfa bool, permissions ...string) error {
+	roles, err := rs.repo.FindUserRoles(userID)
+	if err != nil {
+		return err
+	}
+
+	granted := make(map[string]bool)
+	for _, role := range roles {
+		if role.RequireMFA && !mfa {
+			return errors.New("two-factor authentication required")
+		}
+		for _, permission := range role.Permissions {
+			granted[permission.Name] = true
+		}
+	}
+
+	for _, permission := range permissions {
+		if !granted[permission] {
+			return errors.New("insufficient permissions")
+		}
+	}
+	return nil
+}
+
+func (rs *RBACService) ListPermissions() ([]models.Permission, error) {
+	return rs.repo.FindPermissions()
+}
+
+func (rs *RBACService) ListRoles() ([]dtos.RoleDTO, error) {
+	roles, err := rs.repo.FindRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	roleDtos := make([]dtos.RoleDTO, 0, len(roles))
+	for i := range roles {
+		roleDtos = append(roleDtos, *roleDto(&roles[i]))
+	}
+	return roleDtos, nil
+}
+
+func (rs *RBACService) GetRole(id string) (*dtos.RoleDTO, error) {
+	role, err := rs.findRole(id)
+	if err != nil {
+		return nil, err
+	}
+	return roleDto(role), nil
+}
+
+func (rs *RBACService) CreateRole(roleDTO *dtos.CreateRoleDTO) (*dtos.RoleDTO, error) {
+	roleDTO.Name = utils.TrimAndLower(roleDTO.Name)
+	if err := utils.ValidateUser(roleDTO); err != nil {
+		return nil, err
+	}
+
+	existing, err := rs.repo.FindRolesByName([]string{roleDTO.Name})
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, errors.New("role already exists")
+	}
+
+	permissions, err := rs.findPermissions(roleDTO.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{
+		ID:          uuid.New(),
+		Name:        roleDTO.Name,
+		Description: roleDTO.Description,
+		IsDefault:   roleDTO.IsDefault,
+		RequireMFA:  roleDTO.RequireMFA,
+		Permissions: permissions,
+	}
+	if err := rs.repo.CreateRole(role); err != nil {
+		return nil, err
+	}
+	return roleDto(role), nil
+}
+
+// UpdateRole changes the given fields, the permissions are replaced when provided
+func (rs *RBACService) UpdateRole(id string, roleDTO *dtos.UpdateRoleDTO) (*dtos.RoleDTO, error) {
+	if err := utils.ValidateUser(roleDTO); err != nil {
+		return nil, err
+	}
+
+	role, err := rs.findRole(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleDTO.Name != nil {
+		name := utils.TrimAndLower(*roleDTO.Name)
+		if name != role.Name {
+			existing, err := rs.repo.FindRolesByName([]string{name})
+			if err != nil {
+				return nil, err
+			}
+			if len(existing) > 0 {
+				return nil, errors.New("role already exists")
+			}
+			role.Name = name
+		}
+	}
+	if roleDTO.Description != nil {
+		role.Description = *roleDTO.Description
+	}
+	if roleDTO.IsDefault != nil {
+		role.IsDefault = *roleDTO.IsDefault
+	}
+	if roleDTO.RequireMFA != nil {
+		role.RequireMFA = *roleDTO.RequireMFA
+	}
+	if roleDTO.Permissions != nil {
+		permissions, err := rs.findPermissions(roleDTO.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		role.Permissions = permissions
+	}
+
+	if err := rs.repo.UpdateRole(role, role.Permissions); err != nil {
+		return nil, err
+	}
+	return roleDto(role), nil
+}
+
+// DeleteRole removes a role nobody holds anymore
+func (rs *RBACService) DeleteRole(id string) error {
+	role, err := rs.findRole(id)
+	if err != nil {
+		return err
+	}
+
+	holders, err := rs.repo.CountRoleUsers(role.ID.String())
+	if err != nil {
+		return err
+	}
+	if holders > 0 {
+		return errors.New("role in use")
+	}
+
+	return rs.repo.DeleteRole(role)
+}
+
+func (rs *RBACService) GetUserRoles(userID string) ([]dtos.RoleDTO, error) {
+	user, err := rs.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := rs.repo.FindUserRoles(user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	roleDtos := make([]dtos.RoleDTO, 0, len(roles))
+	for i := range roles {
+		roleDtos = append(roleDtos, *roleDto(&roles[i]))
+	}
+	return roleDtos, nil
+}
+
+// SetUserRoles replaces every role of the user with the given ones
+func (rs *RBACService) SetUserRoles(userID string, rolesDTO *dtos.UserRolesDTO) ([]dtos.RoleDTO, error) {
+	if err := utils.ValidateUser(rolesDTO); err != nil {
+		return nil, err
+	}
+
+	user, err := rs.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rolesDTO.Roles))
+	for _, name := range rolesDTO.Roles {
+		names = append(names, utils.TrimAndLower(name))
+	}
+
+	roles, err := rs.repo.FindRolesByName(names)
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingNames(names, roles, func(r models.Role) string { return r.Name }); missing != "" {
+		return nil, errors.New("unknown role: " + missing)
+	}
+
+	if err := rs.repo.ReplaceUserRoles(user, roles); err != nil {
+		return nil, err
+	}
+	// Access tokens carry the roles, the user has to refresh them
+	if err := rs.userRepo.IncrementTokenVersion(user.ID.String()); err != nil {
+		return nil, err
+	}
+
+	roleDtos := make([]dtos.RoleDTO, 0, len(roles))
+	for i := range roles {
+		roleDtos = append(roleDtos, *roleDto(&roles[i]))
+	}
+	return roleDtos, nil
+}
+
+func (rs *RBACService) findRole(id string) (*models.Role, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("role not found")
+	}
+
+	role, err := rs.repo.FindRoleById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("role not found")
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+func (rs *RBACService) findUser(id string) (*models.User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	user, err := rs.userRepo.FindUserById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// findPermissions loads the permissions by name, every name must exist
+func (rs *RBACService) findPermissions(names []string) ([]models.Permission, error) {
+	if len(names) == 0 {
+		return []models.Permission{}, nil
+	}
+
+	permissions, err := rs.repo.FindPermissionsByName(names)
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingNames(names, permissions, func(p models.Permission) string { return p.Name }); missing != "" {
+		return nil, errors.New("unknown permission: " + missing)
+	}
+	return permissions, nil
+}
+
+// missingNames returns the first requested name that was not found
+func missingNames[T any](names []string, found []T, name func(T) string) string {
+	known := make(map[string]bool, len(found))
+	for _, item := range found {
+		known[name(item)] = true
+	}
+	for _, n := range names {
+		if !known[n] {
+			return n
+		}
+	}
+	return ""
+}
+
+// roleNames lists the names of the roles, e.g. for the claims of a token
+func roleNames(roles []models.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+func roleDto(role *models.Role) *dtos.RoleDTO {
+	permissions := make([]string, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		permissions = append(permissions, permission.Name)
+	}
+	sort.Strings(permissions)
+
+	return &dtos.RoleDTO{
+		ID:          role.ID.String(),
+		Name:        role.Name,
+		Description: role.Description,
+		IsDefault:   role.IsDefault,
+		RequireMFA:  role.RequireMFA,
+		Permissions: permissions,
+		CreatedAt:   role.CreatedAt,
+	}
+}
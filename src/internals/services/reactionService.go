@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// Reaction types offered when REACTION_TYPES is not set
+const defaultReactionTypes = "like,love,laugh,wow,sad"
+
+var reactionTypePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ReactionService lets users react to the posts and the comments they can read. A user reacts
+// at most once with every type, the counts are kept on the posts and the comments.
+type ReactionService struct {
+	repo           repositories.ReactionRepository
+	postService    *PostService
+	commentService *CommentService
+}
+
+func NewReactionService(repo repositories.ReactionRepository, postService *PostService, commentService *CommentService) *ReactionService {
+	return &ReactionService{repo, postService, commentService}
+}
+
+// reactionCursor is the position of the last reaction of a page, encoded into the next cursor
+type reactionCursor struct {
+	CreatedAt time.Time `json:"t"`
+	UserID    string    `json:"id"`
+	Type      string    `json:"type"`
+}
+
+// ReactionTypes returns the reaction types users can choose from (REACTION_TYPES, comma separated)
+func ReactionTypes() []string {
+	value := os.Getenv("REACTION_TYPES")
+	if value == "" {
+		value = defaultReactionTypes
+	}
+	var types []string
+	for _, reactionType := range strings.Split(value, ",") {
+		if reactionType = utils.TrimAndLower(reactionType); reactionTypePattern.MatchString(reactionType) {
+			types = append(types, reactionType)
+		}
+	}
+	return types
+}
+
+// AddReaction reacts to a post, or to one of its comments when commentID is given,
+// and returns the new counts
+func (rs *ReactionService) AddReaction(claims *utils.Claims, postRef, commentID, reactionType string) (map[string]int64, error) {
+	reactionType, err := parseReactionType(reactionType)
+	if err != nil {
+		return nil, err
+	}
+	target, targetID, err := rs.findTarget(claims, postRef, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := rs.repo.CreateReaction(target, targetID, claims.Subject, reactionType)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, errors.New("already reacted")
+	}
+	counts, err := rs.repo.FindReactionCounts(target, targetID)
+	if err != nil {
+		return nil, err
+	}
+	return reactionCounts(counts), nil
+}
+
+// RemoveReaction takes back a reaction of the user and returns the new counts
+func (rs *ReactionService) RemoveReaction(claims *utils.Claims, postRef, commentID, reactionType string) (map[string]int64, error) {
+	reactionType, err := parseReactionType(reactionType)
+	if err != nil {
+		return nil, err
+	}
+	target, targetID, err := rs.findTarget(claims, postRef, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := rs.repo.DeleteReaction(target, targetID, claims.Subject, reactionType)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, errors.New("not reacted")
+	}
+	counts, err := rs.repo.FindReactionCounts(target, targetID)
+	if err != nil {
+		return nil, err
+	}
+	return reactionCounts(counts), nil
+}
+
+// ListReactions lists who reacted to a post or a comment, newest first, only one type if given
+func (rs *ReactionService) ListReactions(claims *utils.Claims, postRef, commentID string, listQuery *dtos.ReactionListQueryDTO) ([]dtos.ReactorDTO, *dtos.PageMetaDTO, error) {
+	limit, err := parseLimitQuery(listQuery.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	page := &repositories.ReactionPage{Limit: limit + 1}
+	if listQuery.Type != "" {
+		if page.Type, err = parseReactionType(listQuery.Type); err != nil {
+			return nil, nil, err
+		}
+	}
+	if listQuery.Cursor != "" {
+		var cursor reactionCursor
+		if err := utils.DecodeCursor(listQuery.Cursor, &cursor); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		if _, err := uuid.Parse(cursor.UserID); err != nil {
+			return nil, nil, errors.New("invalid cursor query")
+		}
+		page.After = &repositories.ReactionKeyset{CreatedAt: cursor.CreatedAt, UserID: cursor.UserID, Type: cursor.Type}
+	}
+
+	target, targetID, err := rs.findTarget(claims, postRef, commentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// One more reaction than asked for tells whether there is a next page
+	reactors, err := rs.repo.FindReactors(target, targetID, page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &dtos.PageMetaDTO{Limit: limit}
+	if len(reactors) > limit {
+		reactors = reactors[:limit]
+		last := &reactors[len(reactors)-1]
+		meta.HasMore = true
+		meta.NextCursor, err = utils.EncodeCursor(&reactionCursor{CreatedAt: last.CreatedAt, UserID: last.UserID, Type: last.Type})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reactorDtos := make([]dtos.ReactorDTO, 0, len(reactors))
+	for _, reactor := range reactors {
+		reactorDtos = append(reactorDtos, dtos.ReactorDTO{
+			Username:  reactor.Username,
+			Image:     reactor.Image,
+			Type:      reactor.Type,
+			ReactedAt: reactor.CreatedAt,
+		})
+	}
+	return reactorDtos, meta, nil
+}
+
+// ReconcileCounters recomputes the reaction counts of every post and comment, returns how many were wrong
+func (rs *ReactionService) ReconcileCounters() (int64, error) {
+	return rs.repo.ReconcileReactionCounts()
+}
+
+// findTarget finds the post, or its comment when commentID is given, the user reacts to.
+// Only published posts and their comments take reactions.
+func (rs *ReactionService) findTarget(claims *utils.Claims, postRef, commentID string) (repositories.ReactionTarget, string, error) {
+	if commentID != "" {
+		post, comment, err := rs.commentService.FindVisibleComment(claims, postRef, commentID)
+		if err != nil {
+			return repositories.ReactionTarget{}, "", err
+		}
+		if post.Status != models.PostPublished || post.DeletedAt.Valid {
+			return repositories.ReactionTarget{}, "", errors.New("post not found")
+		}
+		return repositories.CommentReactions, comment.ID.String(), nil
+	}
+
+	post, err := rs.postService.FindVisiblePost(claims, postRef)
+	if err != nil {
+		return repositories.ReactionTarget{}, "", err
+	}
+	if post.Status != models.PostPublished || post.DeletedAt.Valid {
+		return repositories.ReactionTarget{}, "", errors.New("post not found")
+	}
+	return repositories.PostReactions, post.ID.String(), nil
+}
+
+func parseReactionType(reactionType string) (string, error) {
+	reactionType = utils.TrimAndLower(reactionType)
+	for _, known := range ReactionTypes() {
+		if reactionType == known {
+			return reactionType, nil
+		}
+	}
+	return "", errors.New("unknown reaction type")
+}
+
+// reactionCounts leaves out the types nobody reacted with anymore
+func reactionCounts(counts models.ReactionCounts) map[string]int64 {
+	nonZero := make(map[string]int64, len(counts))
+	for reactionType, count := range counts {
+		if count > 0 {
+			nonZero[reactionType] = count
+		}
+	}
+	return nonZero
+}
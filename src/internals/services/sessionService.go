@@ -0,0 +1,226 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Redis key prefixes used for sessions
+const (
+	sessionRevokedPrefix = "session_revoked:"
+	sessionSeenPrefix    = "session_seen:"
+)
+
+// How often the last seen time of a session gets written to the database
+const sessionTouchInterval = time.Minute
+
+type SessionService struct {
+	repo        repositories.SessionRepository
+	redisClient *redis.Client
+}
+
+func NewSessionService(repo repositories.SessionRepository, redisClient *redis.Client) *SessionService {
+	return &SessionService{repo, redisClient}
+}
+
+// CreateSession records a new login of the user, mfa tells whether a second factor was used
+func (ss *SessionService) CreateSession(user *models.User, client *dtos.ClientInfoDTO, mfa bool) (*models.Session, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		MFA:        mfa,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL()),
+	}
+	if client != nil {
+		session.IP = client.IP
+		session.UserAgent = client.UserAgent
+		session.Device = client.Device
+	}
+
+	if err := ss.repo.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetActiveSession returns the session if it was neither revoked nor has expired
+func (ss *SessionService) GetActiveSession(id string) (*models.Session, error) {
+	session, err := ss.repo.FindSessionById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("session not found")
+		}
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, errors.New("session revoked")
+	}
+	return session, nil
+}
+
+// ExtendSession pushes the expiry of the session when its refresh token is rotated. Only the
+// dates are written, a revocation made by a concurrent request is kept.
+func (ss *SessionService) ExtendSession(session *models.Session) error {
+	now := time.Now()
+	expiresAt := now.Add(utils.RefreshTokenTTL())
+	extended, err := ss.repo.ExtendSession(session.ID.String(), now, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !extended {
+		return errors.New("session revoked")
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// IsSessionActive is called for every authenticated request. Revocations are looked up in Redis,
+// the database is only hit once per sessionTouchInterval to refresh the last seen time.
+func (ss *SessionService) IsSessionActive(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	revoked, err := ss.redisClient.Exists(ctx, sessionRevokedPrefix+id).Result()
+	if err != nil || revoked > 0 {
+		return false
+	}
+
+	touch, err := ss.redisClient.SetNX(ctx, sessionSeenPrefix+id, 1, sessionTouchInterval).Result()
+	if err != nil || !touch {
+		return err == nil
+	}
+
+	if _, err := ss.GetActiveSession(id); err != nil {
+		if err.Error() == "session revoked" {
+			ss.markRevoked(id)
+		}
+		return false
+	}
+
+	return ss.repo.TouchSession(id, time.Now()) == nil
+}
+
+// ListSessions returns the active sessions of the owner of the current session
+func (ss *SessionService) ListSessions(currentID string) ([]dtos.SessionDTO, error) {
+	current, err := ss.GetActiveSession(currentID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := ss.repo.FindActiveSessions(current.UserID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	sessionDtos := make([]dtos.SessionDTO, 0, len(sessions))
+	for _, session := range sessions {
+		sessionDtos = append(sessionDtos, dtos.SessionDTO{
+			ID:         session.ID.String(),
+			Device:     session.Device,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			IssuedAt:   session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == current.ID,
+		})
+	}
+	return sessionDtos, nil
+}
+
+// RevokeSession revokes one session of the owner of the current session
+func (ss *SessionService) RevokeSession(currentID string, id string) error {
+	current, err := ss.GetActiveSession(currentID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return errors.New("session not found")
+	}
+
+	session, err := ss.repo.FindSessionById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("session not found")
+		}
+		return err
+	}
+
+	// Users can only see and revoke their own sessions
+	if session.UserID != current.UserID {
+		return errors.New("session not found")
+	}
+
+	return ss.revoke(session)
+}
+
+// EndSession revokes a session by id, used on logout and when refresh token reuse is detected
+func (ss *SessionService) EndSession(id string) error {
+	session, err := ss.repo.FindSessionById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return ss.revoke(session)
+}
+
+// RevokeAllSessions logs the owner of the current session out everywhere, current session included
+func (ss *SessionService) RevokeAllSessions(currentID string) (int, error) {
+	current, err := ss.GetActiveSession(currentID)
+	if err != nil {
+		return 0, err
+	}
+
+	return ss.RevokeUserSessions(current.UserID.String())
+}
+
+// RevokeUserSessions revokes every session of a user but the excepted ones and returns how many were revoked
+func (ss *SessionService) RevokeUserSessions(userID string, except ...string) (int, error) {
+	ids, err := ss.repo.RevokeUserSessions(userID, except...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		ss.markRevoked(id)
+	}
+	return len(ids), nil
+}
+
+func (ss *SessionService) revoke(session *models.Session) error {
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	session.RevokedAt = &now
+	if err := ss.repo.UpdateSession(session); err != nil {
+		return err
+	}
+	ss.markRevoked(session.ID.String())
+	return nil
+}
+
+// markRevoked caches the revocation so the middleware rejects the session's access tokens.
+// Access tokens cannot outlive their TTL so the key does not need to be kept longer.
+func (ss *SessionService) markRevoked(id string) {
+	ss.redisClient.Set(ctx, sessionRevokedPrefix+id, "revoked", utils.AccessTokenTTL())
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// TagService lists the tags and lets admins rename and merge them. Tags are created by the posts using them.
+type TagService struct {
+	repo repositories.TagRepository
+}
+
+func NewTagService(repo repositories.TagRepository) *TagService {
+	return &TagService{repo}
+}
+
+// NormalizeTagName collapses the whitespace of a tag name and returns it with its slug,
+// e.g. "  Go   Lang " gives "Go Lang" and "go-lang". The slug is empty for names without letters nor digits.
+func NormalizeTagName(name string) (string, string) {
+	name = strings.Join(strings.Fields(name), " ")
+	return name, utils.Slugify(utils.TrimAndLower(name))
+}
+
+// ListTags lists the most used tags, only the ones starting like the query when given, for autocompletion
+func (ts *TagService) ListTags(query, limitQuery string) ([]dtos.TagDTO, error) {
+	limit, err := parseLimitQuery(limitQuery)
+	if err != nil {
+		return nil, err
+	}
+	_, prefix := NormalizeTagName(query)
+
+	tags, err := ts.repo.FindTags(prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	tagDtos := make([]dtos.TagDTO, 0, len(tags))
+	for i := range tags {
+		tagDtos = append(tagDtos, *tagDto(&tags[i]))
+	}
+	return tagDtos, nil
+}
+
+func (ts *TagService) GetTag(slug string) (*dtos.TagDTO, error) {
+	tag, err := ts.findTag(slug)
+	if err != nil {
+		return nil, err
+	}
+	return tagDto(tag), nil
+}
+
+// RenameTag changes the name of a tag and so its slug, the slug of another tag cannot be taken
+func (ts *TagService) RenameTag(slug string, tagDTO *dtos.RenameTagDTO) (*dtos.TagDTO, error) {
+	tag, err := ts.findTag(slug)
+	if err != nil {
+		return nil, err
+	}
+	name, newSlug := NormalizeTagName(tagDTO.Name)
+	if newSlug == "" {
+		return nil, errors.New("invalid tag")
+	}
+
+	if newSlug != tag.Slug {
+		if _, err := ts.repo.FindTagBySlug(newSlug); err == nil {
+			return nil, errors.New("tag already exists")
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	tag.Name, tag.Slug = name, newSlug
+	if err := ts.repo.UpdateTag(tag); err != nil {
+		return nil, err
+	}
+	return tagDto(tag), nil
+}
+
+// MergeTag moves the posts of a tag to another one and deletes it
+func (ts *TagService) MergeTag(slug string, mergeDTO *dtos.MergeTagDTO) (*dtos.TagDTO, error) {
+	source, err := ts.findTag(slug)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ts.findTag(mergeDTO.Into)
+	if err != nil {
+		return nil, err
+	}
+	if source.ID == target.ID {
+		return nil, errors.New("cannot merge a tag into itself")
+	}
+
+	if err := ts.repo.MergeTags(source, target); err != nil {
+		return nil, err
+	}
+	return tagDto(target), nil
+}
+
+// ReconcileCounters recomputes the posts count of every tag, returns how many were wrong
+func (ts *TagService) ReconcileCounters() (int64, error) {
+	return ts.repo.ReconcileTagCounts()
+}
+
+func (ts *TagService) findTag(slug string) (*models.Tag, error) {
+	tag, err := ts.repo.FindTagBySlug(utils.TrimAndLower(slug))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("tag not found")
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+func tagDto(tag *models.Tag) *dtos.TagDTO {
+	return &dtos.TagDTO{Name: tag.Name, Slug: tag.Slug, PostsCount: tag.PostsCount}
+}
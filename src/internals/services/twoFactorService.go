@@ -0,0 +1,210 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Number of recovery codes handed out when 2FA is confirmed
+const recoveryCodesCount = 10
+
+// Redis key prefix remembering the TOTP time steps already used by a user
+const totpUsedPrefix = "totp_used:"
+
+type TwoFactorService struct {
+	userRepo    repositories.UserRepository
+	repo        repositories.TwoFactorRepository
+	redisClient *redis.Client
+}
+
+func NewTwoFactorService(userRepo repositories.UserRepository, repo repositories.TwoFactorRepository, redisClient *redis.Client) *TwoFactorService {
+	return &TwoFactorService{userRepo, repo, redisClient}
+}
+
+// Enroll generates a new TOTP secret for the user, it is only enabled once a code is confirmed
+func (ts *TwoFactorService) Enroll(userID string) (*dtos.TwoFactorEnrollmentDTO, error) {
+	user, err := ts.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = secret
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "readerblog"
+	}
+
+	return &dtos.TwoFactorEnrollmentDTO{
+		Secret:     secret,
+		OTPAuthURL: utils.TOTPURI(issuer, user.Username, secret),
+	}, nil
+}
+
+// Confirm enables 2FA once the user proves their authenticator works and returns the recovery codes
+func (ts *TwoFactorService) Confirm(userID string, codeDTO *dtos.TwoFactorCodeDTO) ([]string, error) {
+	if err := utils.ValidateUser(codeDTO); err != nil {
+		return nil, err
+	}
+
+	user, err := ts.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("two-factor authentication not enrolled")
+	}
+
+	// Recovery codes do not exist yet, only a TOTP code can confirm the enrollment
+	if err := ts.verifyCode(user, codeDTO.Code); err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	return ts.replaceRecoveryCodes(user)
+}
+
+// Disable turns 2FA off after checking a code, the secret and recovery codes are dropped.
+// The sessions of the user lose their 2FA login and the access tokens have to be refreshed,
+// so require_mfa roles are no longer satisfied.
+func (ts *TwoFactorService) Disable(userID string, codeDTO *dtos.TwoFactorCodeDTO) error {
+	user, err := ts.findEnabledUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := ts.Verify(user, codeDTO); err != nil {
+		return err
+	}
+
+	return ts.repo.DisableTwoFactor(user.ID.String())
+}
+
+// RegenerateRecoveryCodes invalidates the previous recovery codes and hands out new ones
+func (ts *TwoFactorService) RegenerateRecoveryCodes(userID string, codeDTO *dtos.TwoFactorCodeDTO) ([]string, error) {
+	user, err := ts.findEnabledUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ts.Verify(user, codeDTO); err != nil {
+		return nil, err
+	}
+
+	return ts.replaceRecoveryCodes(user)
+}
+
+// Verify checks a TOTP code or consumes a recovery code of the user
+func (ts *TwoFactorService) Verify(user *models.User, codeDTO *dtos.TwoFactorCodeDTO) error {
+	if err := utils.ValidateUser(codeDTO); err != nil {
+		return err
+	}
+
+	if codeDTO.Code != "" {
+		return ts.verifyCode(user, codeDTO.Code)
+	}
+
+	used, err := ts.repo.UseRecoveryCode(user.ID.String(), utils.HashToken(utils.NormalizeRecoveryCode(codeDTO.RecoveryCode)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errors.New("invalid two-factor code")
+	}
+	return nil
+}
+
+// verifyCode validates a TOTP code and refuses to accept the same code twice
+func (ts *TwoFactorService) verifyCode(user *models.User, code string) error {
+	if code == "" {
+		return errors.New("code is required")
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errors.New("invalid two-factor code")
+	}
+
+	// A code stays valid for a few periods, it is remembered until it cannot match anymore
+	key := fmt.Sprintf("%s%s:%d", totpUsedPrefix, user.ID, step)
+	firstUse, err := ts.redisClient.SetNX(ctx, key, 1, 3*30*time.Second).Result()
+	if err != nil {
+		return err
+	}
+	if !firstUse {
+		return errors.New("invalid two-factor code")
+	}
+	return nil
+}
+
+func (ts *TwoFactorService) replaceRecoveryCodes(user *models.User) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	}
+
+	if err := ts.repo.ReplaceRecoveryCodes(user.ID.String(), hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (ts *TwoFactorService) findUser(userID string) (*models.User, error) {
+	user, err := ts.userRepo.FindUserById(userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (ts *TwoFactorService) findEnabledUser(userID string) (*models.User, error) {
+	user, err := ts.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication not enabled")
+	}
+	return user, nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+// Most users a batch can act on, larger selections are refused rather than cut
+const batchMaxUsers = 200
+
+// Outcomes of a batch for one user
+const (
+	BatchStatusApplied    = "applied"
+	BatchStatusWouldApply = "would_apply"
+	BatchStatusSkipped    = "skipped"
+	BatchStatusFailed     = "failed"
+	BatchStatusAborted    = "aborted"
+)
+
+// batchAction is what an action of a batch changes, the permission it requires and whether it takes roles
+type batchAction struct {
+	change     repositories.UserBatchChange
+	permission string
+	roles      bool
+}
+
+var batchActions = map[string]batchAction{
+	"delete":       {repositories.BatchDelete, "users.delete", false},
+	"restore":      {repositories.BatchRestore, "users.restore", false},
+	"set_roles":    {repositories.BatchSetRoles, "roles.assign", true},
+	"add_roles":    {repositories.BatchAddRoles, "roles.assign", true},
+	"remove_roles": {repositories.BatchRemoveRoles, "roles.assign", true},
+}
+
+// UserBatchService applies one action to many users. Every user is checked first, then the
+// changes of the eligible ones are written in a single transaction.
+type UserBatchService struct {
+	userRepo       repositories.UserRepository
+	roleRepo       repositories.RoleRepository
+	rbacService    *RBACService
+	sessionService *SessionService
+}
+
+func NewUserBatchService(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, rbacService *RBACService, sessionService *SessionService) *UserBatchService {
+	return &UserBatchService{userRepo, roleRepo, rbacService, sessionService}
+}
+
+// RunBatch applies the action of the batch, or only reports what it would do with dry_run.
+// An atomic batch applies nothing when one of the users fails.
+func (bs *UserBatchService) RunBatch(claims *utils.Claims, batchDTO *dtos.UserBatchDTO) (*dtos.UserBatchResultDTO, error) {
+	if err := utils.ValidateUser(batchDTO); err != nil {
+		return nil, err
+	}
+	action := batchActions[batchDTO.Action]
+
+	// The batch route only requires reading users, every action requires its own permission
+	if err := bs.rbacService.Authorize(claims.Subject, claims.MFA, action.permission); err != nil {
+		return nil, err
+	}
+
+	var roles []models.Role
+	if action.roles {
+		var err error
+		if roles, err = bs.findRoles(batchDTO.Roles); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &dtos.UserBatchResultDTO{
+		Action:  batchDTO.Action,
+		DryRun:  batchDTO.DryRun,
+		Atomic:  batchDTO.Atomic,
+		Results: []dtos.UserBatchItemResultDTO{},
+	}
+
+	targets, err := bs.selectUsers(batchDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	var eligible []string
+	eligibleAt := make(map[string]int)
+	for _, target := range targets {
+		item := dtos.UserBatchItemResultDTO{ID: target.id}
+		if target.user == nil {
+			item.Status, item.Reason = BatchStatusFailed, "user not found"
+			result.Failed++
+		} else if failure, skip := checkBatchUser(action.change, target.user, claims.Subject, roles); failure != "" {
+			item.Username = target.user.Username
+			item.Status, item.Reason = BatchStatusFailed, failure
+			result.Failed++
+		} else if skip != "" {
+			item.Username = target.user.Username
+			item.Status, item.Reason = BatchStatusSkipped, skip
+			result.Skipped++
+		} else {
+			item.Username = target.user.Username
+			item.Status = BatchStatusWouldApply
+			eligibleAt[item.ID] = len(result.Results)
+			eligible = append(eligible, item.ID)
+		}
+		result.Results = append(result.Results, item)
+	}
+
+	switch {
+	case batchDTO.Atomic && result.Failed > 0:
+		result.Aborted = true
+		for _, i := range eligibleAt {
+			result.Results[i].Status = BatchStatusAborted
+		}
+		return result, nil
+	case batchDTO.DryRun:
+		return result, nil
+	}
+
+	if err := bs.userRepo.ApplyBatch(action.change, eligible, roles); err != nil {
+		return nil, err
+	}
+	for _, i := range eligibleAt {
+		result.Results[i].Status = BatchStatusApplied
+	}
+	result.Applied = len(eligible)
+
+	// Deleted users are logged out everywhere, their access tokens are already outdated
+	if action.change == repositories.BatchDelete {
+		for _, id := range eligible {
+			if _, err := bs.sessionService.RevokeUserSessions(id); err != nil {
+				log.Printf("Could not revoke the sessions of deleted user %s: %v", id, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// batchTarget is a user selected by a batch, user is nil when the ID matches nobody
+type batchTarget struct {
+	id   string
+	user *models.User
+}
+
+// selectUsers loads the users listed by ID, in the given order, or the ones matching the filter
+func (bs *UserBatchService) selectUsers(batchDTO *dtos.UserBatchDTO) ([]batchTarget, error) {
+	switch {
+	case batchDTO.Filter != nil && len(batchDTO.IDs) > 0:
+		return nil, errors.New("ids and filter cannot be combined")
+	case batchDTO.Filter != nil:
+		users, err := bs.filterUsers(batchDTO.Filter)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]batchTarget, 0, len(users))
+		for i := range users {
+			targets = append(targets, batchTarget{users[i].ID.String(), &users[i]})
+		}
+		return targets, nil
+	case len(batchDTO.IDs) == 0:
+		return nil, errors.New("ids or filter is required")
+	}
+
+	var targets []batchTarget
+	var ids []string
+	seen := make(map[string]bool, len(batchDTO.IDs))
+	for _, id := range batchDTO.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		targets = append(targets, batchTarget{id: id})
+		if _, err := uuid.Parse(id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(targets) > batchMaxUsers {
+		return nil, errors.New("too many users")
+	}
+
+	users, err := bs.userRepo.FindUsersByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID.String()] = &users[i]
+	}
+	for i := range targets {
+		targets[i].user = byID[targets[i].id]
+	}
+	return targets, nil
+}
+
+// filterUsers loads the users matching the filter, refusing more than a batch can hold
+func (bs *UserBatchService) filterUsers(filterDTO *dtos.UserBatchFilterDTO) ([]models.User, error) {
+	filter, err := parseUserFilter(&dtos.UserListQueryDTO{
+		Role:          filterDTO.Role,
+		CreatedAfter:  filterDTO.CreatedAfter,
+		CreatedBefore: filterDTO.CreatedBefore,
+		EmailDomain:   filterDTO.EmailDomain,
+		Deleted:       filterDTO.Deleted,
+		Sort:          "created_at",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := bs.userRepo.CountUsers(filter)
+	if err != nil {
+		return nil, err
+	}
+	if count > batchMaxUsers {
+		return nil, errors.New("too many users")
+	}
+
+	filter.Limit = batchMaxUsers
+	return bs.userRepo.FindUsers(filter)
+}
+
+// findRoles loads the roles of a role action, every name must exist
+func (bs *UserBatchService) findRoles(names []string) ([]models.Role, error) {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = utils.TrimAndLower(name); name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, errors.New("roles are required")
+	}
+
+	roles, err := bs.roleRepo.FindRolesByName(normalized)
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingNames(normalized, roles, func(r models.Role) string { return r.Name }); missing != "" {
+		return nil, errors.New("unknown role: " + missing)
+	}
+	return roles, nil
+}
+
+// checkBatchUser tells why the change cannot apply to the user, or why it has nothing to do
+func checkBatchUser(change repositories.UserBatchChange, user *models.User, selfID string, roles []models.Role) (failure, skip string) {
+	self := user.ID.String() == selfID
+	held := make(map[uuid.UUID]bool, len(user.Roles))
+	for _, role := range user.Roles {
+		held[role.ID] = true
+	}
+	holdsAny, holdsAll := false, true
+	for _, role := range roles {
+		holdsAny = holdsAny || held[role.ID]
+		holdsAll = holdsAll && held[role.ID]
+	}
+
+	switch change {
+	case repositories.BatchDelete:
+		if self {
+			return "cannot delete yourself", ""
+		}
+		if user.DeletedAt.Valid {
+			return "", "already deleted"
+		}
+	case repositories.BatchRestore:
+		if !user.DeletedAt.Valid {
+			return "", "not deleted"
+		}
+	case repositories.BatchSetRoles:
+		// Admins could lock themselves out by replacing their own roles
+		if self {
+			return "cannot change your own roles", ""
+		}
+		if holdsAll && len(user.Roles) == len(roles) {
+			return "", "unchanged"
+		}
+	case repositories.BatchAddRoles:
+		if holdsAll {
+			return "", "unchanged"
+		}
+	case repositories.BatchRemoveRoles:
+		if self {
+			return "cannot change your own roles", ""
+		}
+		if !holdsAny {
+			return "", "unchanged"
+		}
+	}
+	return "", ""
+}
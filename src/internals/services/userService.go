@@ -1,9 +1,13 @@
 package services
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
-	"os"
+	"io"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/timebetov/readerblog/internals/models"
@@ -13,12 +17,31 @@ import (
 	"gorm.io/gorm"
 )
 
+// How long users can restore the account they deleted by logging in again
+const defaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// AccountDeletionGracePeriod returns the grace period of deleted accounts (ACCOUNT_DELETION_GRACE_PERIOD, e.g. "720h"),
+// zero disables restoring accounts on login
+func AccountDeletionGracePeriod() time.Duration {
+	return utils.DurationFromEnv("ACCOUNT_DELETION_GRACE_PERIOD", defaultAccountDeletionGracePeriod)
+}
+
+// AccountPendingDeletionError is returned when the owner of a deleted account logs in without asking to restore it
+type AccountPendingDeletionError struct {
+	PurgeAt time.Time
+}
+
+func (e *AccountPendingDeletionError) Error() string {
+	return "account pending deletion"
+}
+
 type UserService struct {
-	repo repositories.UserRepository
+	repo           repositories.UserRepository
+	sessionService *SessionService
 }
 
-func NewUserService(repo repositories.UserRepository) *UserService {
-	return &UserService{repo}
+func NewUserService(repo repositories.UserRepository, sessionService *SessionService) *UserService {
+	return &UserService{repo, sessionService}
 }
 
 func (us *UserService) GetUserById(id string) (*models.User, error) {
@@ -32,27 +55,120 @@ func (us *UserService) GetUserById(id string) (*models.User, error) {
 	return user, nil
 }
 
-func (us *UserService) GetUsers(deletedQuery string) ([]models.User, error) {
-	var deleted bool
+// GetPublicProfile returns what anybody can see of an active user, hidden profiles are not found
+func (us *UserService) GetPublicProfile(username string) (*dtos.PublicProfileDTO, error) {
+	user, err := us.repo.FindUserByUsername(utils.TrimAndLower(username))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	if user.Privacy.Hidden {
+		return nil, errors.New("user not found")
+	}
 
-	if deletedQuery != "" {
-		var err error
-		// Converting the string to a boolean if provided
-		deleted, err = strconv.ParseBool(deletedQuery)
+	profile := &dtos.PublicProfileDTO{Username: user.Username}
+	if !user.Privacy.HideImage {
+		profile.Image = user.Image
+		profile.Avatars = user.Avatars
+	}
+	if !user.Privacy.HideBio {
+		profile.Bio = user.Bio
+	}
+	if !user.Privacy.HideFollowCounts {
+		profile.Subscribers = &user.Subscribers
+		profile.Followed = &user.Followed
+	}
+	if !user.Privacy.HidePostsCount {
+		profile.PublishedPosts = &user.PublishedPosts
+	}
+	if !user.Privacy.HideJoinDate {
+		profile.JoinedAt = &user.CreatedAt
+	}
+	return profile, nil
+}
+
+// GetTokenVersion returns the token version of an active user
+func (us *UserService) GetTokenVersion(id string) (uint, error) {
+	return us.repo.FindTokenVersion(id)
+}
+
+// GetUsers lists one page of users. Pages are cut with a keyset cursor so they stay
+// consistent while users are created or deleted between two requests.
+func (us *UserService) GetUsers(listQuery *dtos.UserListQueryDTO) ([]models.User, *dtos.PageMetaDTO, error) {
+	filter, err := parseUserFilter(listQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &dtos.PageMetaDTO{Limit: filter.Limit}
+	if listQuery.Count != "" {
+		count, err := strconv.ParseBool(listQuery.Count)
 		if err != nil {
-			return nil, errors.New("invalid deleted query")
+			return nil, nil, errors.New("invalid count query")
+		}
+		if count {
+			total, err := us.repo.CountUsers(filter)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta.Total = &total
 		}
-	} else {
-		// If query parameter is not provided, setting the default value
-		deleted = false
 	}
 
-	users, err := us.repo.FindUsers(deleted)
+	// One more user than asked for tells whether there is a next page
+	filter.Limit++
+	users, err := us.repo.FindUsers(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(users) > meta.Limit {
+		users = users[:meta.Limit]
+		meta.HasMore = true
+		meta.NextCursor, err = utils.EncodeCursor(userCursorOf(&users[len(users)-1], listQuery.Sort))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return users, meta, nil
+}
+
+// ExportUsers checks the filters of the export and returns the function writing the matching users.
+// They are read page by page so an export of any size never holds every user in memory.
+func (us *UserService) ExportUsers(listQuery *dtos.UserListQueryDTO, format string) (func(w io.Writer) error, error) {
+	if format != TransferFormatCSV && format != TransferFormatNDJSON {
+		return nil, errors.New("invalid format query")
+	}
+	// The whole list is exported in creation order, whatever the pagination asked for
+	listQuery.Limit, listQuery.Cursor, listQuery.Sort = "", "", "created_at"
+	filter, err := parseUserFilter(listQuery)
 	if err != nil {
 		return nil, err
 	}
+	filter.Limit = exportPageSize
 
-	return users, nil
+	return func(w io.Writer) error {
+		encode := newExportEncoder(w, format)
+		for {
+			users, err := us.repo.FindUsers(filter)
+			if err != nil {
+				return err
+			}
+			for i := range users {
+				if err := encode(userExportDto(&users[i])); err != nil {
+					return err
+				}
+			}
+			if len(users) < filter.Limit {
+				return encode(nil)
+			}
+			last := &users[len(users)-1]
+			filter.After = &repositories.UserKeyset{Value: last.CreatedAt, ID: last.ID.String()}
+		}
+	}, nil
 }
 
 func (us *UserService) CreateUser(userDTO *dtos.CreateUserDTO) (*models.User, error) {
@@ -65,6 +181,11 @@ func (us *UserService) CreateUser(userDTO *dtos.CreateUserDTO) (*models.User, er
 		return nil, err
 	}
 
+	// Checking the password against the password policy
+	if err := utils.ValidatePassword(userDTO.Password, userDTO.Username, userDTO.Email); err != nil {
+		return nil, err
+	}
+
 	// Hashing the password
 	hashedPassword, err := utils.HashPassword(userDTO.Password)
 	if err != nil {
@@ -100,36 +221,116 @@ func (us *UserService) UpdateUser(id string, userDTO *dtos.UpdateUserDTO) (*mode
 		return nil, err
 	}
 
-	// Updating the field if they are not nil
-	if userDTO.Email != nil {
-		user.Email = utils.TrimAndLower(*userDTO.Email)
+	if err := us.applyUpdate(user, userDTO); err != nil {
+		return nil, err
 	}
-	if userDTO.Role != nil {
-		newRole := utils.TrimAndLower(*userDTO.Role)
-		if newRole != os.Getenv("ADMIN_ROLE") && newRole != os.Getenv("WRITER_ROLE") {
-			return nil, errors.New("invalid role")
+
+	// A new password logs the user out everywhere
+	if userDTO.Password != nil {
+		if err := us.logoutEverywhere(user); err != nil {
+			return nil, err
 		}
-		user.Role = newRole
 	}
-	if userDTO.Password != nil {
-		if userDTO.PasswordConfirmation == nil || *userDTO.PasswordConfirmation != *userDTO.Password {
-			return nil, errors.New("passwords do not match")
+
+	return user, nil
+}
+
+// UpdateProfile applies the changes a user makes to their own account. Changing the email or the
+// password requires the current password and logs the other sessions out, the current one stays.
+func (us *UserService) UpdateProfile(id, sessionID string, profileDTO *dtos.UpdateProfileDTO) (*models.User, error) {
+	user, err := us.GetUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletedAt.Valid {
+		return nil, errors.New("user not found")
+	}
+
+	if err := utils.ValidateUser(profileDTO); err != nil {
+		return nil, err
+	}
+
+	emailChanged := profileDTO.Email != nil && utils.TrimAndLower(*profileDTO.Email) != user.Email
+	credentialsChanged := emailChanged || profileDTO.Password != nil
+	if credentialsChanged {
+		if profileDTO.CurrentPassword == nil {
+			return nil, errors.New("current password required")
 		}
-		hashedPassword, err := utils.HashPassword(*userDTO.Password)
-		if err != nil {
+		if err := utils.CheckPassword(user.Password, *profileDTO.CurrentPassword); err != nil {
+			return nil, errors.New("invalid current password")
+		}
+	}
+
+	if emailChanged {
+		if _, err := us.repo.FindUserByEmail(utils.TrimAndLower(*profileDTO.Email)); err == nil {
+			return nil, errors.New("email already in use")
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, err
 		}
-		user.Password = hashedPassword
+		// The new address has to be verified again
+		user.VerifiedAt = nil
 	}
 
-	// Saving the updated user to the database
-	if err := us.repo.UpdateUser(user); err != nil {
+	if profileDTO.Privacy != nil {
+		applyPrivacy(&user.Privacy, profileDTO.Privacy)
+	}
+
+	if err := us.applyUpdate(user, &profileDTO.UpdateUserDTO); err != nil {
 		return nil, err
 	}
 
+	// Access tokens carry the verification status, the current session has to refresh them
+	if credentialsChanged {
+		if err := us.repo.IncrementTokenVersion(user.ID.String()); err != nil {
+			return nil, err
+		}
+		if _, err := us.sessionService.RevokeUserSessions(user.ID.String(), sessionID); err != nil {
+			return nil, err
+		}
+	}
+
 	return user, nil
 }
 
+// DeleteProfile soft deletes the account of the user after checking their password, they can
+// restore it by logging in during the grace period
+func (us *UserService) DeleteProfile(id string, deleteDTO *dtos.DeleteProfileDTO) (*models.User, error) {
+	if err := utils.ValidateUser(deleteDTO); err != nil {
+		return nil, err
+	}
+
+	user, err := us.GetUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletedAt.Valid {
+		return nil, errors.New("user not found")
+	}
+	if err := utils.CheckPassword(user.Password, deleteDTO.Password); err != nil {
+		return nil, errors.New("invalid current password")
+	}
+
+	if grace := AccountDeletionGracePeriod(); grace > 0 {
+		purgeAt := time.Now().Add(grace)
+		if err := us.repo.SchedulePurge(user, &purgeAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := us.logoutEverywhere(user); err != nil {
+		return nil, err
+	}
+	if err := us.repo.DeleteUser(false, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// IsRestorable tells whether the owner of the deleted account can still restore it by logging in
+func (us *UserService) IsRestorable(user *models.User) bool {
+	return user.DeletedAt.Valid && user.PurgeAt != nil && time.Now().Before(*user.PurgeAt)
+}
+
 func (us *UserService) DeleteUser(forceQuery string, id string) (*models.User, error) {
 	user, err := us.GetUserById(id)
 	if err != nil {
@@ -149,6 +350,17 @@ func (us *UserService) DeleteUser(forceQuery string, id string) (*models.User, e
 		force = false
 	}
 
+	// Logging the user out before the account goes away
+	if err := us.logoutEverywhere(user); err != nil {
+		return nil, err
+	}
+	// Accounts deleted by an admin cannot be restored by their owner
+	if user.PurgeAt != nil {
+		if err := us.repo.SchedulePurge(user, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := us.repo.DeleteUser(force, user); err != nil {
 		return nil, err
 	}
@@ -156,6 +368,265 @@ func (us *UserService) DeleteUser(forceQuery string, id string) (*models.User, e
 	return user, nil
 }
 
+// applyUpdate sets the fields given in the DTO and saves the user
+func (us *UserService) applyUpdate(user *models.User, userDTO *dtos.UpdateUserDTO) error {
+	// Updating the field if they are not nil
+	if userDTO.Email != nil {
+		user.Email = utils.TrimAndLower(*userDTO.Email)
+	}
+	if userDTO.Password != nil {
+		if userDTO.PasswordConfirmation == nil || *userDTO.PasswordConfirmation != *userDTO.Password {
+			return errors.New("passwords do not match")
+		}
+		if err := utils.ValidatePassword(*userDTO.Password, user.Username, user.Email); err != nil {
+			return err
+		}
+		hashedPassword, err := utils.HashPassword(*userDTO.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashedPassword
+	}
+	if userDTO.Image != nil && *userDTO.Image != user.Image {
+		// The uploaded avatar is replaced by the given URL
+		user.Image = *userDTO.Image
+		user.Avatars = nil
+	}
+	if userDTO.Bio != nil {
+		user.Bio = *userDTO.Bio
+	}
+
+	// Saving the updated user to the database
+	return us.repo.UpdateUser(user)
+}
+
+// Users read from the database at once by an export
+const exportPageSize = 500
+
+// Columns of a CSV export, an import reads the ones it knows and ignores the others
+var userExportColumns = []string{"id", "username", "email", "roles", "image", "bio", "email_verified", "created_at", "deleted_at"}
+
+// First characters making spreadsheets read a cell as a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// newExportEncoder returns the function writing one user in the format, nil flushes the output
+func newExportEncoder(w io.Writer, format string) func(user *dtos.UserExportDTO) error {
+	if format == TransferFormatNDJSON {
+		encoder := json.NewEncoder(w)
+		return func(user *dtos.UserExportDTO) error {
+			if user == nil {
+				return nil
+			}
+			return encoder.Encode(user)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	header := true
+	return func(user *dtos.UserExportDTO) error {
+		if header {
+			header = false
+			if err := writer.Write(userExportColumns); err != nil {
+				return err
+			}
+		}
+		if user == nil {
+			writer.Flush()
+			return writer.Error()
+		}
+
+		deletedAt := ""
+		if user.DeletedAt != nil {
+			deletedAt = user.DeletedAt.Format(time.RFC3339)
+		}
+		return writer.Write([]string{
+			user.ID,
+			csvSafeCell(user.Username),
+			csvSafeCell(user.Email),
+			strings.Join(user.Roles, csvRoleSeparator),
+			csvSafeCell(user.Image),
+			csvSafeCell(user.Bio),
+			strconv.FormatBool(user.EmailVerified),
+			user.CreatedAt.Format(time.RFC3339),
+			deletedAt,
+		})
+	}
+}
+
+// csvSafeCell keeps spreadsheets from running a cell the user wrote as a formula, cells starting
+// like one are prefixed with a quote which an import removes again. Cells already quoted that
+// way get another quote so they are imported unchanged.
+func csvSafeCell(value string) string {
+	if csvFormulaLike(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvUnsafeCell undoes csvSafeCell
+func csvUnsafeCell(value string) string {
+	if strings.HasPrefix(value, "'") && csvFormulaLike(value) {
+		return value[1:]
+	}
+	return value
+}
+
+// csvFormulaLike tells whether the cell starts like a formula once its leading quotes are ignored
+func csvFormulaLike(value string) bool {
+	value = strings.TrimLeft(value, "'")
+	return value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0]))
+}
+
+func userExportDto(user *models.User) *dtos.UserExportDTO {
+	exported := &dtos.UserExportDTO{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		Roles:         roleNames(user.Roles),
+		Image:         user.Image,
+		Bio:           user.Bio,
+		EmailVerified: user.VerifiedAt != nil,
+		CreatedAt:     user.CreatedAt,
+	}
+	if user.DeletedAt.Valid {
+		exported.DeletedAt = &user.DeletedAt.Time
+	}
+	return exported
+}
+
+// applyPrivacy sets the privacy flags given in the DTO
+func applyPrivacy(privacy *models.ProfilePrivacy, privacyDTO *dtos.UpdatePrivacyDTO) {
+	for _, flag := range []struct {
+		value *bool
+		field *bool
+	}{
+		{privacyDTO.Hidden, &privacy.Hidden},
+		{privacyDTO.HideImage, &privacy.HideImage},
+		{privacyDTO.HideBio, &privacy.HideBio},
+		{privacyDTO.HideFollowCounts, &privacy.HideFollowCounts},
+		{privacyDTO.HideJoinDate, &privacy.HideJoinDate},
+		{privacyDTO.HidePostsCount, &privacy.HidePostsCount},
+	} {
+		if flag.value != nil {
+			*flag.field = *flag.value
+		}
+	}
+}
+
+// Sort fields of the user list, a leading "-" sorts in descending order
+var userSortColumns = map[string]string{
+	"created_at": "created_at",
+	"username":   "username",
+	"email":      "email",
+}
+
+// userCursor is the position of the last user of a page, encoded into the next cursor
+type userCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func userCursorOf(user *models.User, sort string) *userCursor {
+	cursor := &userCursor{Sort: sort, ID: user.ID.String()}
+	switch strings.TrimPrefix(sort, "-") {
+	case "username":
+		cursor.Value = user.Username
+	case "email":
+		cursor.Value = user.Email
+	default:
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// parseUserFilter checks the query parameters of the user list
+func parseUserFilter(listQuery *dtos.UserListQueryDTO) (*repositories.UserFilter, error) {
+	limit, err := parseLimitQuery(listQuery.Limit)
+	if err != nil {
+		return nil, err
+	}
+	filter := &repositories.UserFilter{Limit: limit}
+
+	// Kept compatible with the former boolean: true lists only the deleted users
+	switch listQuery.Deleted {
+	case "", "false":
+		filter.Deleted = repositories.ExcludeDeleted
+	case "true", "only":
+		filter.Deleted = repositories.OnlyDeleted
+	case "all":
+		filter.Deleted = repositories.IncludeDeleted
+	default:
+		return nil, errors.New("invalid deleted query")
+	}
+
+	filter.Role = utils.TrimAndLower(listQuery.Role)
+	filter.EmailDomain = strings.TrimPrefix(utils.TrimAndLower(listQuery.EmailDomain), "@")
+
+	if filter.CreatedAfter, err = parseDateQuery(listQuery.CreatedAfter); err != nil {
+		return nil, errors.New("invalid created_after query")
+	}
+	if filter.CreatedBefore, err = parseDateQuery(listQuery.CreatedBefore); err != nil {
+		return nil, errors.New("invalid created_before query")
+	}
+
+	if listQuery.Sort == "" {
+		listQuery.Sort = "-created_at"
+	}
+	column, ok := userSortColumns[strings.TrimPrefix(listQuery.Sort, "-")]
+	if !ok {
+		return nil, errors.New("invalid sort query")
+	}
+	filter.SortColumn = column
+	filter.Descending = strings.HasPrefix(listQuery.Sort, "-")
+
+	if listQuery.Cursor != "" {
+		var cursor userCursor
+		// A cursor only continues the list it was issued for
+		if err := utils.DecodeCursor(listQuery.Cursor, &cursor); err != nil || cursor.Sort != listQuery.Sort {
+			return nil, errors.New("invalid cursor query")
+		}
+		if _, err := uuid.Parse(cursor.ID); err != nil {
+			return nil, errors.New("invalid cursor query")
+		}
+
+		filter.After = &repositories.UserKeyset{Value: cursor.Value, ID: cursor.ID}
+		if column == "created_at" {
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, errors.New("invalid cursor query")
+			}
+			filter.After.Value = createdAt
+		}
+	}
+
+	return filter, nil
+}
+
+// parseDateQuery accepts a date (2006-01-02) or a full RFC 3339 timestamp, empty means no bound
+func parseDateQuery(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return &date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// logoutEverywhere revokes the sessions of the user and the access tokens already issued for them
+func (us *UserService) logoutEverywhere(user *models.User) error {
+	if err := us.repo.IncrementTokenVersion(user.ID.String()); err != nil {
+		return err
+	}
+	_, err := us.sessionService.RevokeUserSessions(user.ID.String())
+	return err
+}
+
 func (us *UserService) RestoreUser(id string) (*models.User, error) {
 	user, err := us.repo.FindUserById(id)
 	if err != nil {
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+)
+
+func TestCSVExportNeutralizesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	encode := newExportEncoder(&buf, TransferFormatCSV)
+	user := &dtos.UserExportDTO{
+		ID:        "8d7b7c33-5b3b-4bb0-8a4d-6f0f3f2c1d11",
+		Username:  "mallory01",
+		Email:     "mallory@example.com",
+		Image:     "@SUM(1+1)",
+		Bio:       `=HYPERLINK("http://evil.example","click")`,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := encode(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := records[1][4]; got != "'@SUM(1+1)" {
+		t.Errorf("image exported as %q", got)
+	}
+	if got := records[1][5]; got != `'=HYPERLINK("http://evil.example","click")` {
+		t.Errorf("bio exported as %q", got)
+	}
+
+	// Importing the export gives the values back
+	var rows []*dtos.UserImportDTO
+	if err := readCSVRows(bytes.NewReader(buf.Bytes()), func(line int, row *dtos.UserImportDTO, err error) {
+		if err != nil {
+			t.Fatalf("line %d: %v", line, err)
+		}
+		rows = append(rows, row)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Image != user.Image || rows[0].Bio != user.Bio {
+		t.Fatalf("imported %+v", rows)
+	}
+}
+
+func TestCSVSafeCell(t *testing.T) {
+	for value, want := range map[string]string{
+		"":             "",
+		"Hello":        "Hello",
+		"-1":           "'-1",
+		"+1 555":       "'+1 555",
+		"\tcmd":        "'\tcmd",
+		"\rcmd":        "'\rcmd",
+		"a=b":          "a=b",
+		"'=quoted":     "''=quoted",
+		"''=quoted":    "'''=quoted",
+		"'plain quote": "'plain quote",
+	} {
+		if got := csvSafeCell(value); got != want {
+			t.Errorf("csvSafeCell(%q) = %q, want %q", value, got, want)
+		}
+		if got := csvUnsafeCell(csvSafeCell(value)); got != value {
+			t.Errorf("csvUnsafeCell(csvSafeCell(%q)) = %q", value, got)
+		}
+	}
+}
+
+// pagedUserRepository lists users from memory the way FindUsers pages them in the database:
+// sorted by the column then the ID, starting after the keyset, at most filter.Limit users
+type pagedUserRepository struct {
+	repositories.UserRepository
+	users []models.User
+	// Limits the pages were asked with
+	limits []int
+}
+
+func (r *pagedUserRepository) FindUsers(filter *repositories.UserFilter) ([]models.User, error) {
+	r.limits = append(r.limits, filter.Limit)
+
+	// Compares a user with a sort value and an ID
+	compare := func(user *models.User, value any, id string) int {
+		var c int
+		switch filter.SortColumn {
+		case "created_at":
+			c = user.CreatedAt.Compare(value.(time.Time))
+		case "username":
+			c = strings.Compare(user.Username, value.(string))
+		case "email":
+			c = strings.Compare(user.Email, value.(string))
+		}
+		if c == 0 {
+			c = strings.Compare(user.ID.String(), id)
+		}
+		if filter.Descending {
+			c = -c
+		}
+		return c
+	}
+	sortValue := func(user *models.User) any {
+		switch filter.SortColumn {
+		case "username":
+			return user.Username
+		case "email":
+			return user.Email
+		}
+		return user.CreatedAt
+	}
+
+	users := append([]models.User{}, r.users...)
+	sort.Slice(users, func(i, j int) bool {
+		return compare(&users[i], sortValue(&users[j]), users[j].ID.String()) < 0
+	})
+	page := []models.User{}
+	for i := range users {
+		if filter.After != nil && compare(&users[i], filter.After.Value, filter.After.ID) <= 0 {
+			continue
+		}
+		if len(page) == filter.Limit {
+			break
+		}
+		page = append(page, users[i])
+	}
+	return page, nil
+}
+
+func newPagedUsers() []models.User {
+	created := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	users := make([]models.User, 0, 7)
+	for i, name := range []string{"grace", "alan", "edsger", "barbara", "donald", "ada", "linus"} {
+		user := models.User{ID: uuid.New(), Username: name + "0001", Email: fmt.Sprintf("%s@example.com", name)}
+		// Two users share each creation time, the ID orders them
+		user.CreatedAt = created.Add(time.Duration(i/2) * time.Hour).Add(123456789 * time.Nanosecond)
+		users = append(users, user)
+	}
+	return users
+}
+
+func TestGetUsersPagesThroughEveryUserOnce(t *testing.T) {
+	for _, sortQuery := range []string{"", "created_at", "-created_at", "username", "-username", "email", "-email"} {
+		t.Run("sort="+sortQuery, func(t *testing.T) {
+			repo := &pagedUserRepository{users: newPagedUsers()}
+			us := &UserService{repo: repo}
+
+			var listed []string
+			cursor := ""
+			for pages := 1; ; pages++ {
+				users, meta, err := us.GetUsers(&dtos.UserListQueryDTO{Limit: "3", Sort: sortQuery, Cursor: cursor})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, user := range users {
+					listed = append(listed, user.ID.String())
+				}
+				if meta.Limit != 3 || len(users) > 3 {
+					t.Fatalf("page %d has %d users and a limit of %d", pages, len(users), meta.Limit)
+				}
+				if !meta.HasMore {
+					if meta.NextCursor != "" || pages != 3 {
+						t.Fatalf("page %d is the last one with the cursor %q", pages, meta.NextCursor)
+					}
+					break
+				}
+				if meta.NextCursor == "" || len(users) != 3 {
+					t.Fatalf("page %d has more users but %d users and the cursor %q", pages, len(users), meta.NextCursor)
+				}
+				cursor = meta.NextCursor
+			}
+
+			// One more user than the page size tells whether there is a next page
+			for _, limit := range repo.limits {
+				if limit != 4 {
+					t.Fatalf("the pages were asked with limits %v, want 4", repo.limits)
+				}
+			}
+
+			// The pages follow each other in the order of a single query
+			if sortQuery == "" {
+				sortQuery = "-created_at"
+			}
+			all, err := repo.FindUsers(&repositories.UserFilter{
+				SortColumn: strings.TrimPrefix(sortQuery, "-"),
+				Descending: strings.HasPrefix(sortQuery, "-"),
+				Limit:      len(repo.users),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]string, 0, len(all))
+			for _, user := range all {
+				want = append(want, user.ID.String())
+			}
+			if !reflect.DeepEqual(listed, want) {
+				t.Errorf("listed %v, want %v", listed, want)
+			}
+		})
+	}
+}
+
+func TestGetUsersRefusesForeignCursors(t *testing.T) {
+	us := &UserService{repo: &pagedUserRepository{users: newPagedUsers()}}
+	_, meta, err := us.GetUsers(&dtos.UserListQueryDTO{Limit: "2", Sort: "username"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(cursor *userCursor) string {
+		encoded, err := utils.EncodeCursor(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	id := uuid.NewString()
+	for name, query := range map[string]*dtos.UserListQueryDTO{
+		// A cursor only continues the list it was issued for, not the same list reversed
+		"other direction": {Sort: "-username", Cursor: meta.NextCursor},
+		"other column":    {Sort: "email", Cursor: meta.NextCursor},
+		"default sort":    {Cursor: meta.NextCursor},
+		"not base64":      {Sort: "username", Cursor: "not a cursor!"},
+		"not JSON":        {Sort: "username", Cursor: "bm90IGpzb24"},
+		"invalid ID":      {Sort: "username", Cursor: encode(&userCursor{Sort: "username", Value: "ada0001", ID: "1 OR 1=1"})},
+		"invalid date":    {Sort: "-created_at", Cursor: encode(&userCursor{Sort: "-created_at", Value: "yesterday", ID: id})},
+	} {
+		if _, _, err := us.GetUsers(query); err == nil || err.Error() != "invalid cursor query" {
+			t.Errorf("%s: got %v, want invalid cursor query", name, err)
+		}
+	}
+
+	for _, limit := range []string{"0", "-1", "201", "ten"} {
+		if _, _, err := us.GetUsers(&dtos.UserListQueryDTO{Limit: limit}); err == nil || err.Error() != "invalid limit query" {
+			t.Errorf("limit %s: got %v, want invalid limit query", limit, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/timebetov/readerblog/internals/mailer"
+	"github.com/timebetov/readerblog/internals/models"
+	"github.com/timebetov/readerblog/internals/models/dtos"
+	"github.com/timebetov/readerblog/internals/repositories"
+	"github.com/timebetov/readerblog/internals/utils"
+	"gorm.io/gorm"
+)
+
+// Email verification policies (EMAIL_VERIFICATION_POLICY)
+const (
+	// Unverified users can do everything
+	VerificationPolicyNone = "none"
+	// Unverified users cannot log in
+	VerificationPolicyLogin = "login"
+	// Unverified users can log in but only read
+	VerificationPolicyWrite = "write"
+)
+
+const (
+	emailVerificationTTL     = 24 * time.Hour
+	verificationResendDelay  = time.Minute
+	verificationResendPrefix = "verify_resend:"
+	emailVerificationPurpose = "verify_email"
+)
+
+type VerificationService struct {
+	userRepo    repositories.UserRepository
+	mailer      mailer.Mailer
+	redisClient *redis.Client
+}
+
+func NewVerificationService(userRepo repositories.UserRepository, mailer mailer.Mailer, redisClient *redis.Client) *VerificationService {
+	return &VerificationService{userRepo, mailer, redisClient}
+}
+
+// EmailVerificationPolicy returns the configured policy, "none" when unset or unknown
+func EmailVerificationPolicy() string {
+	switch policy := os.Getenv("EMAIL_VERIFICATION_POLICY"); policy {
+	case VerificationPolicyLogin, VerificationPolicyWrite:
+		return policy
+	}
+	return VerificationPolicyNone
+}
+
+// SendVerification emails the user a signed link valid for emailVerificationTTL
+func (vs *VerificationService) SendVerification(user *models.User) error {
+	token, err := utils.GenerateChallengeToken(user.ID.String(), &utils.ChallengeClaims{
+		Purpose:  emailVerificationPurpose,
+		Username: user.Username,
+		Email:    user.Email,
+	}, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/api/verify-email?token=" + url.QueryEscape(token))
+	return vs.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			user.Username, link, int(emailVerificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail marks the email of the user as verified if the link is valid
+func (vs *VerificationService) VerifyEmail(token string) (*models.User, error) {
+	claims, err := utils.ParseChallengeToken(token, emailVerificationPurpose)
+	if err != nil {
+		return nil, errors.New("invalid verification link")
+	}
+
+	user, err := vs.userRepo.FindUserById(claims.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invalid verification link")
+		}
+		return nil, err
+	}
+	if user.DeletedAt.Valid {
+		return nil, errors.New("invalid verification link")
+	}
+
+	// The link is only good for the address it was sent to
+	if user.Email != claims.Email {
+		return nil, errors.New("invalid verification link")
+	}
+
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		user.VerifiedAt = &now
+		if err := vs.userRepo.UpdateUser(user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// ResendVerification sends a new link. Unknown and already verified addresses are silently
+// ignored so the endpoint cannot be used to find out which emails are registered.
+func (vs *VerificationService) ResendVerification(resendDTO *dtos.ResendVerificationDTO) error {
+	resendDTO.Email = utils.TrimAndLower(resendDTO.Email)
+	if err := utils.ValidateUser(resendDTO); err != nil {
+		return err
+	}
+
+	// One email per address per verificationResendDelay
+	allowed, err := vs.redisClient.SetNX(ctx, verificationResendPrefix+resendDTO.Email, 1, verificationResendDelay).Result()
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	user, err := vs.userRepo.FindUserByEmail(resendDTO.Email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if user.VerifiedAt != nil {
+		return nil
+	}
+
+	return vs.SendVerification(user)
+}
+
+// appURL builds an absolute link to the API from APP_URL
+func appURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path
+}
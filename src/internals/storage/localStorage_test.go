@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoragePathStaysInDir(t *testing.T) {
+	s := NewLocalStorage("/srv/uploads", "http://readerblog.test/uploads")
+
+	for key, want := range map[string]string{
+		"avatars/u/a.jpg":  "/srv/uploads/avatars/u/a.jpg",
+		"/avatars/u/a.jpg": "/srv/uploads/avatars/u/a.jpg",
+		"avatars//a.jpg":   "/srv/uploads/avatars/a.jpg",
+	} {
+		path, err := s.path(key)
+		if err != nil || path != filepath.FromSlash(want) {
+			t.Errorf("path(%q) = %q, %v, want %q", key, path, err, want)
+		}
+	}
+
+	for _, key := range []string{"", "/", "..", "../etc/passwd", "avatars/../../etc/passwd", "avatars/..", "/../uploads-other/a.jpg", `avatars\..\..\a.jpg`} {
+		if path, err := s.path(key); err == nil {
+			t.Errorf("path(%q) = %q, want an invalid storage key", key, path)
+		}
+	}
+}
+
+func TestLocalStoragePutAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStorage(dir, "http://readerblog.test/uploads")
+
+	url, err := s.Put("avatars/u/a.jpg", []byte("jpeg bytes"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "http://readerblog.test/uploads/avatars/u/a.jpg" {
+		t.Errorf("Put returned %q", url)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "avatars", "u", "a.jpg"))
+	if err != nil || string(content) != "jpeg bytes" {
+		t.Fatalf("stored %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "avatars", "u", "a.jpg.tmp")); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+
+	if _, err := s.Put("../a.jpg", []byte("x"), "image/jpeg"); err == nil {
+		t.Error("Put wrote outside of the directory")
+	}
+
+	if err := s.Delete("avatars/u/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "avatars", "u", "a.jpg")); !os.IsNotExist(err) {
+		t.Fatal("the file was not deleted")
+	}
+	// Deleting a missing file is not an error
+	if err := s.Delete("avatars/u/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+}
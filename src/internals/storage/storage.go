@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Storage keeps uploaded files under keys like "avatars/<user>/<name>.jpg",
+// implementations are picked with the STORAGE env variable
+type Storage interface {
+	// Put stores the content under the key and returns the public URL of the file
+	Put(key string, content []byte, contentType string) (string, error)
+	// Delete removes the file, deleting a missing file is not an error
+	Delete(key string) error
+}
+
+// NewStorage builds the storage configured by STORAGE: "s3" or "local" (default)
+func NewStorage() Storage {
+	switch os.Getenv("STORAGE") {
+	case "s3":
+		s, err := NewS3Storage()
+		if err != nil {
+			log.Fatalf("Could not configure S3 storage: %v", err)
+		}
+		return s
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocalStorage(dir, publicURL(os.Getenv("STORAGE_PUBLIC_URL"), appURL()+LocalURLPath))
+	default:
+		log.Fatalf("Unknown STORAGE %q, expected s3 or local", os.Getenv("STORAGE"))
+		return nil
+	}
+}
+
+// appURL returns the public URL of the API (APP_URL)
+func appURL() string {
+	if base := os.Getenv("APP_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// publicURL returns the configured base URL of the files, or the fallback
+func publicURL(configured, fallback string) string {
+	if configured != "" {
+		return strings.TrimRight(configured, "/")
+	}
+	return fallback
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+)
+
+// quadrantsImage draws a width x height image whose centered square is split in four colored
+// quadrants (red, green / blue, white) and whose margins are black
+func quadrantsImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	side := width
+	if height < side {
+		side = height
+	}
+	x0, y0 := (width-side)/2, (height-side)/2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := black
+			if x >= x0 && x < x0+side && y >= y0 && y < y0+side {
+				right, bottom := x-x0 >= side/2, y-y0 >= side/2
+				switch {
+				case !right && !bottom:
+					c = red
+				case right && !bottom:
+					c = green
+				case !right && bottom:
+					c = blue
+				default:
+					c = white
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeJPEG encodes the image and adds an EXIF segment holding the orientation when it is not 0
+func encodeJPEG(t *testing.T, img image.Image, orientation uint16, order binary.AppendByteOrder) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	// TIFF header, then an IFD holding the orientation tag alone
+	tiff := []byte("MM")
+	if order == binary.LittleEndian {
+		tiff = []byte("II")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	// Right after the start of image marker, before the segments of the encoder
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// assertQuadrants checks the color at the center of every quadrant of a square image,
+// JPEG compression only changes the colors a little
+func assertQuadrants(t *testing.T, img *image.RGBA, topLeft, topRight, bottomLeft, bottomRight color.RGBA) {
+	t.Helper()
+	side := img.Bounds().Dx()
+	quarter := side / 4
+	for _, check := range []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"top left", quarter, quarter, topLeft},
+		{"top right", side - quarter, quarter, topRight},
+		{"bottom left", quarter, side - quarter, bottomLeft},
+		{"bottom right", side - quarter, side - quarter, bottomRight},
+	} {
+		got := img.RGBAAt(check.x, check.y)
+		if !closeColor(got, check.want) {
+			t.Errorf("%s is %v, want %v", check.name, got, check.want)
+		}
+	}
+}
+
+func closeColor(a, b color.RGBA) bool {
+	near := func(x, y uint8) bool { return int(x)-int(y) < 40 && int(y)-int(x) < 40 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
+
+func TestDecodeSquareImageCutsCenteredSquare(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"png landscape":  encodePNG(t, quadrantsImage(96, 64)),
+		"png portrait":   encodePNG(t, quadrantsImage(64, 96)),
+		"jpeg landscape": encodeJPEG(t, quadrantsImage(96, 64), 0, nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			img, err := DecodeSquareImage(data, 10000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != 64 || bounds.Dy() != 64 {
+				t.Fatalf("got a %v image, want 64x64", bounds)
+			}
+			// The black margins are cut off
+			assertQuadrants(t, img, red, green, blue, white)
+		})
+	}
+}
+
+func TestDecodeSquareImageAppliesEXIFOrientation(t *testing.T) {
+	src := quadrantsImage(64, 64)
+	for _, test := range []struct {
+		orientation uint16
+		order       binary.AppendByteOrder
+		// Quadrants once upright: top left, top right, bottom left, bottom right
+		want [4]color.RGBA
+	}{
+		{1, binary.BigEndian, [4]color.RGBA{red, green, blue, white}},
+		{3, binary.LittleEndian, [4]color.RGBA{white, blue, green, red}},
+		// Stored rotated 90° counterclockwise, shown turned 90° clockwise
+		{6, binary.BigEndian, [4]color.RGBA{blue, red, white, green}},
+		{6, binary.LittleEndian, [4]color.RGBA{blue, red, white, green}},
+		// Stored rotated 90° clockwise, shown turned 90° counterclockwise
+		{8, binary.BigEndian, [4]color.RGBA{green, white, red, blue}},
+		{8, binary.LittleEndian, [4]color.RGBA{green, white, red, blue}},
+	} {
+		data := encodeJPEG(t, src, test.orientation, test.order)
+		if got := jpegOrientation(data); got != int(test.orientation) {
+			t.Errorf("jpegOrientation read %d, want %d", got, test.orientation)
+		}
+
+		img, err := DecodeSquareImage(data, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertQuadrants(t, img, test.want[0], test.want[1], test.want[2], test.want[3])
+	}
+}
+
+func TestOrientMatchesEveryEXIFOrientation(t *testing.T) {
+	src := quadrantsImage(4, 4)
+	for orientation, want := range map[int][4]color.RGBA{
+		0: {red, green, blue, white},
+		1: {red, green, blue, white},
+		2: {green, red, white, blue},
+		3: {white, blue, green, red},
+		4: {blue, white, red, green},
+		5: {red, blue, green, white},
+		6: {blue, red, white, green},
+		7: {white, green, blue, red},
+		8: {green, white, red, blue},
+		9: {red, green, blue, white},
+	} {
+		img := orient(src, orientation)
+		got := [4]color.RGBA{img.RGBAAt(0, 0), img.RGBAAt(3, 0), img.RGBAAt(0, 3), img.RGBAAt(3, 3)}
+		if got != want {
+			t.Errorf("orientation %d gives corners %v, want %v", orientation, got, want)
+		}
+	}
+}
+
+func TestJPEGOrientationIgnoresBrokenMetadata(t *testing.T) {
+	plain := encodeJPEG(t, quadrantsImage(8, 8), 0, nil)
+	withEXIF := encodeJPEG(t, quadrantsImage(8, 8), 6, binary.BigEndian)
+
+	for name, data := range map[string][]byte{
+		"no EXIF":         plain,
+		"not a JPEG":      encodePNG(t, quadrantsImage(8, 8)),
+		"empty":           nil,
+		"truncated EXIF":  withEXIF[:20],
+		"segment overrun": append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}, []byte("Exif\x00\x00MM")...),
+	} {
+		if got := jpegOrientation(data); got != 1 {
+			t.Errorf("%s: jpegOrientation read %d, want 1", name, got)
+		}
+	}
+}
+
+func TestDecodeSquareImageRefusesUnsupportedImages(t *testing.T) {
+	if _, err := DecodeSquareImage([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), 10000); err == nil || err.Error() != "unsupported image type" {
+		t.Errorf("decoding an SVG returned %v", err)
+	}
+	if _, err := DecodeSquareImage(encodePNG(t, quadrantsImage(200, 100)), 10000); err == nil || err.Error() != "image dimensions too large" {
+		t.Errorf("decoding a 200x100 image with a limit of 10000 pixels returned %v", err)
+	}
+	truncated := encodePNG(t, quadrantsImage(64, 64))
+	if _, err := DecodeSquareImage(truncated[:len(truncated)/2], 10000); err == nil || err.Error() != "invalid image" {
+		t.Errorf("decoding a truncated PNG returned %v", err)
+	}
+}
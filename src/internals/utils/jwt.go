@@ -1,37 +1,115 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// Default lifetimes used when the corresponding env variables are not set
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultTokenIssuer     = "readerblog"
+	defaultTokenAudience   = "readerblog-api"
+)
 
+// Claims of access tokens, the subject is the ID of the user
 type Claims struct {
+	Username      string   `json:"username"`
+	Roles         []string `json:"roles"`
+	SessionID     string   `json:"sid"`
+	MFA           bool     `json:"mfa,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	// Version of the user's tokens when this one was issued, see models.User.TokenVersion
+	TokenVersion uint `json:"ver"`
+	// Set when the request was authenticated with a personal access token instead of a JWT
+	APITokenID string   `json:"-"`
+	Scopes     []string `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// IsAPIToken tells whether the claims come from a personal access token
+func (c *Claims) IsAPIToken() bool {
+	return c.APITokenID != ""
+}
+
+// HasScope reports whether the credential may be used for the scope, JWTs carry every scope
+func (c *Claims) HasScope(scope string) bool {
+	if !c.IsAPIToken() {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ChallengeClaims are carried by short-lived tokens proving a first authentication step,
+// like a correct password before the second factor, or sent by email like verification links.
+// They can never be used as access tokens.
+type ChallengeClaims struct {
+	Purpose  string `json:"purpose"`
 	Username string `json:"username"`
-	Role     string `json:"role"`
+	Email    string `json:"email,omitempty"`
+	// The login restores the account its owner deleted once the second factor is checked
+	Restore bool `json:"restore,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(username, role string) (string, error) {
-	claims := &Claims{
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+// AccessTokenTTL returns the lifetime of access tokens (ACCESS_TOKEN_TTL, e.g. "15m")
+func AccessTokenTTL() time.Duration {
+	return DurationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL returns the lifetime of refresh tokens (REFRESH_TOKEN_TTL, e.g. "720h")
+func RefreshTokenTTL() time.Duration {
+	return DurationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// TokenIssuer returns the issuer of the tokens (JWT_ISSUER)
+func TokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
 	}
+	return defaultTokenIssuer
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+// TokenAudience returns the audience of the tokens (JWT_AUDIENCE)
+func TokenAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return defaultTokenAudience
 }
 
+// GenerateToken issues an access token for the user with the given ID, every token gets its own jti
+func GenerateToken(userID string, claims *Claims) (string, error) {
+	claims.RegisteredClaims = registeredClaims(userID, AccessTokenTTL())
+
+	if keyring == nil {
+		return "", errors.New("signing keys are not loaded")
+	}
+	return keyring.sign(claims)
+}
+
+// ParseToken verifies the token with the keyring entry selected by its kid header
 func ParseToken(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	if keyring == nil {
+		return nil, errors.New("signing keys are not loaded")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, keyring.verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -39,8 +117,100 @@ func ParseToken(tokenStr string) (*Claims, error) {
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// Every access token belongs to a session and a user, challenge tokens have no session
+	if claims.SessionID == "" || claims.Subject == "" {
+		return nil, errors.New("not an access token")
+	}
+
+	if !claims.VerifyIssuer(TokenIssuer(), true) || !claims.VerifyAudience(TokenAudience(), true) {
+		return nil, errors.New("token was issued for another service")
+	}
+
+	return claims, nil
+}
+
+// GenerateChallengeToken issues a token for the purpose of the claims, e.g. the second login step
+func GenerateChallengeToken(userID string, claims *ChallengeClaims, ttl time.Duration) (string, error) {
+	if keyring == nil {
+		return "", errors.New("signing keys are not loaded")
+	}
+
+	claims.RegisteredClaims = registeredClaims(userID, ttl)
+	return keyring.sign(claims)
+}
+
+// ParseChallengeToken verifies a challenge token and checks it was issued for the purpose
+func ParseChallengeToken(tokenStr, purpose string) (*ChallengeClaims, error) {
+	if keyring == nil {
+		return nil, errors.New("signing keys are not loaded")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenStr, &ChallengeClaims{}, keyring.verificationKey)
+	if err != nil {
 		return nil, err
 	}
 
+	claims, ok := token.Claims.(*ChallengeClaims)
+	if !ok || !token.Valid || claims.Purpose != purpose {
+		return nil, errors.New("invalid challenge token")
+	}
+	if !claims.VerifyIssuer(TokenIssuer(), true) || !claims.VerifyAudience(TokenAudience(), true) {
+		return nil, errors.New("invalid challenge token")
+	}
+
 	return claims, nil
 }
+
+// registeredClaims returns the standard claims of a new token living for ttl
+func registeredClaims(subject string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    TokenIssuer(),
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{TokenAudience()},
+		ID:        uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// GenerateOpaqueToken returns a random URL-safe token with n bytes of entropy
+func GenerateOpaqueToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of an opaque token, so raw tokens are never stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DurationFromEnv parses a duration env variable, falling back to the default on absence or error
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// IntFromEnv parses a positive integer env variable, falling back to the default on absence or error
+func IntFromEnv(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is one entry of the keyring. Retired keys only have the public part
+// and are kept around so tokens signed before a rotation stay valid until they expire.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// Keyring holds every key tokens can be verified with and the one new tokens are signed with
+type Keyring struct {
+	keys     map[string]*signingKey
+	activeID string
+}
+
+// JWK is the public representation of a key as published on the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var keyring *Keyring
+
+// InitKeyring loads the signing keys used for JWTs.
+//
+// When JWT_KEYS_DIR is set every *.pem file in it is loaded, the file name without
+// extension being the key id. Private keys (RSA or Ed25519, PKCS#1/PKCS#8) can sign,
+// public-only files hold retired keys. JWT_ACTIVE_KID selects the signing key, otherwise
+// the last private key in alphabetical order is used.
+// Without JWT_KEYS_DIR the legacy HS256 JWT_SECRET is used and the JWKS is empty.
+func InitKeyring() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return errors.New("either JWT_KEYS_DIR or JWT_SECRET must be set")
+		}
+		keyring = &Keyring{
+			keys: map[string]*signingKey{
+				"": {Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)},
+			},
+		}
+		return nil
+	}
+
+	ring, err := LoadKeyring(dir, os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		return err
+	}
+	keyring = ring
+	return nil
+}
+
+// LoadKeyring reads every PEM key of a directory into a keyring
+func LoadKeyring(dir string, activeID string) (*Keyring, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	ring := &Keyring{keys: make(map[string]*signingKey)}
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", file, err)
+		}
+		ring.keys[key.ID] = key
+
+		// Falling back to the last private key found
+		if activeID == "" && key.Private != nil {
+			ring.activeID = key.ID
+		}
+	}
+
+	if activeID != "" {
+		ring.activeID = activeID
+	}
+
+	active, ok := ring.keys[ring.activeID]
+	if !ok || active.Private == nil {
+		return nil, fmt.Errorf("no private key found for active key id %q in %s", ring.activeID, dir)
+	}
+
+	return ring, nil
+}
+
+// JWKS returns the public keys of the keyring in JSON Web Key Set format
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		key := k.keys[id]
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return set
+}
+
+// PublicKeySet returns the JWKS of the loaded keyring
+func PublicKeySet() JWKS {
+	if keyring == nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return keyring.JWKS()
+}
+
+// sign signs the claims with the active key, adding its id to the header
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	key := k.keys[k.activeID]
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Private)
+}
+
+// verificationKey selects the key matching the kid header of the token
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func loadKeyFile(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &signingKey{ID: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Private, key.Public = private, &private.PublicKey
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.Private, key.Public = private, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.Private, key.Public = private, private.Public()
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", private)
+		}
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Public = public
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	switch key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key.Public)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// Longest excerpt of a post, in characters
+const excerptLength = 280
+
+// CommonMark with tables, footnotes and strikethrough. Raw HTML is kept, the sanitizer removes what is unsafe.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Footnote,
+		extension.Strikethrough,
+	),
+	goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+)
+
+// htmlPolicy keeps the formatting of user content and removes scripts, styles, event handlers
+// and unsafe URLs. Links to other sites get rel="nofollow".
+var htmlPolicy = func() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.RequireNoFollowOnLinks(false)
+	policy.RequireNoFollowOnFullyQualifiedLinks(true)
+	// Classes and roles of the footnotes
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote(s|-ref|-backref)$`)).Globally()
+	policy.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).Globally()
+	return policy
+}()
+
+// textPolicy removes every tag, leaving the text
+var textPolicy = bluemonday.StrictPolicy()
+
+// RenderMarkdown turns the Markdown of a post into sanitized HTML
+func RenderMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return htmlPolicy.Sanitize(buf.String()), nil
+}
+
+// HTMLToText returns the text of rendered HTML, its whitespace collapsed into single spaces
+func HTMLToText(rendered string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(textPolicy.Sanitize(rendered))), " ")
+}
+
+// Excerpt cuts a text to excerptLength characters, at a word boundary when possible
+func Excerpt(text string) string {
+	if utf8.RuneCountInString(text) <= excerptLength {
+		return text
+	}
+	cut := string([]rune(text)[:excerptLength])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
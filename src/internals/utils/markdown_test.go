@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, source string) string {
+	t.Helper()
+	rendered, err := RenderMarkdown(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered
+}
+
+func TestRenderMarkdownRemovesUnsafeHTML(t *testing.T) {
+	for name, test := range map[string]struct {
+		source string
+		// Left of the source once sanitized, and what must not be left of it
+		keep, drop string
+	}{
+		"script":                {"<script>alert(1)</script>\n\nHello", "<p>Hello</p>", "alert"},
+		"style element":         {"<style>p { display: none }</style>\n\nHello", "<p>Hello</p>", "display"},
+		"event handler":         {"<img src=\"cat.png\" onerror=\"alert(1)\">", "<img src=\"cat.png\">", "onerror"},
+		"style attribute":       {"<p style=\"position: fixed\">Hello</p>", "<p>Hello</p>", "style"},
+		"javascript link":       {"[click](javascript:alert(1))", "<p>click</p>", "javascript"},
+		"javascript anchor":     {"<a href=\"JaVaScRiPt:alert(1)\">click</a>", "<p>click</p>", "alert"},
+		"data link":             {"[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>click</p>", "data:"},
+		"unknown class":         {"<p class=\"admin-banner\">Hello</p>", "<p>Hello</p>", "admin-banner"},
+		"iframe":                {"<iframe src=\"https://example.com\"></iframe>\n\nHello", "<p>Hello</p>", "iframe"},
+		"script in inline html": {"Hello <script>alert(1)</script> world", "Hello", "<script"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rendered := render(t, test.source)
+			if !strings.Contains(rendered, test.keep) || strings.Contains(strings.ToLower(rendered), strings.ToLower(test.drop)) {
+				t.Errorf("%q rendered as %q", test.source, rendered)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownLinks(t *testing.T) {
+	for source, want := range map[string]string{
+		// Links to other sites do not pass on the ranking of the blog
+		"[site](https://example.com/page)":        `<a href="https://example.com/page" rel="nofollow">site</a>`,
+		"<a href=\"http://example.com\">site</a>": `<a href="http://example.com" rel="nofollow">site</a>`,
+		// Links within the blog are left alone
+		"[post](/posts/hello-world)": `<a href="/posts/hello-world">post</a>`,
+		"[section](#comments)":       `<a href="#comments">section</a>`,
+	} {
+		if rendered := render(t, source); !strings.Contains(rendered, want) {
+			t.Errorf("%q rendered as %q, want %q", source, rendered, want)
+		}
+	}
+}
+
+func TestRenderMarkdownKeepsFootnotesAndTables(t *testing.T) {
+	rendered := render(t, "Text[^1]\n\n[^1]: Note")
+	for _, want := range []string{
+		`<a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a>`,
+		`<div class="footnotes" role="doc-endnotes">`,
+		`<a href="#fnref:1" class="footnote-backref" role="doc-backlink">`,
+	} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("footnotes rendered as %q, want %q", rendered, want)
+		}
+	}
+	if strings.Contains(rendered, "nofollow") {
+		t.Errorf("footnote links got rel=\"nofollow\": %q", rendered)
+	}
+
+	rendered = render(t, "| a | b |\n|:-|-:|\n| 1 | 2 |")
+	if !strings.Contains(rendered, `<th align="left">a</th>`) || !strings.Contains(rendered, `<td align="right">2</td>`) {
+		t.Errorf("table rendered as %q", rendered)
+	}
+}
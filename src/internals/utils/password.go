@@ -1,19 +1,243 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
-// HashPassword hashes the given password using bcrypt
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default argon2id parameters, memory is in KiB
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	argon2SaltLength         = 16
+	argon2KeyLength          = 32
+	// Largest memory accepted, 4 GiB
+	maxArgon2Memory     = 4 * 1024 * 1024
+	maxArgon2Iterations = 100
+)
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes passwords into an encoded string carrying the algorithm and its
+// parameters, so hashes made with other parameters or algorithms can still be verified
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Supports tells whether the encoded hash was produced by this algorithm
+	Supports(encoded string) bool
+	// Verify returns ErrPasswordMismatch when the password does not match
+	Verify(encoded, password string) error
+	// NeedsRehash tells whether the encoded hash uses other parameters than the hasher
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	hasherOnce     sync.Once
+	hasherErr      error
+	passwordHasher PasswordHasher
+	// Every algorithm stored hashes can be verified with
+	passwordVerifiers []PasswordHasher
+)
+
+// HashPassword hashes the password with the configured hasher (PASSWORD_HASHER, argon2id by default)
 func HashPassword(password string) (string, error) {
-	// Generating a hashed version of the password with a default cost of 10
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return currentHasher().Hash(password)
+}
+
+// CheckPassword compares the hashed password with the plaintext password, whatever supported algorithm produced it
+func CheckPassword(hashedPassword, password string) error {
+	currentHasher()
+	for _, hasher := range passwordVerifiers {
+		if hasher.Supports(hashedPassword) {
+			return hasher.Verify(hashedPassword, password)
+		}
+	}
+	return errors.New("unsupported password hash")
+}
+
+// PasswordNeedsRehash tells whether the hash should be replaced on the next successful login,
+// because it was made with another algorithm or with outdated parameters
+func PasswordNeedsRehash(hashedPassword string) bool {
+	hasher := currentHasher()
+	return !hasher.Supports(hashedPassword) || hasher.NeedsRehash(hashedPassword)
+}
+
+// InitPasswordHasher reads the hashing configuration, parameters out of range are refused
+// instead of producing hashes nobody can check or panicking on the first login
+func InitPasswordHasher() error {
+	hasherOnce.Do(func() {
+		hasherErr = loadPasswordHasher()
+	})
+	return hasherErr
+}
+
+func currentHasher() PasswordHasher {
+	if err := InitPasswordHasher(); err != nil {
+		// Only reached by the programs which did not check the configuration when starting
+		log.Fatalf("Invalid password hashing configuration: %v", err)
+	}
+	return passwordHasher
+}
+
+func loadPasswordHasher() error {
+	parallelism, err := hashParamFromEnv("ARGON2_PARALLELISM", defaultArgon2Parallelism, 1, 255)
 	if err != nil {
+		return err
+	}
+	// argon2 needs 8 KiB per lane
+	memory, err := hashParamFromEnv("ARGON2_MEMORY", defaultArgon2Memory, 8*parallelism, maxArgon2Memory)
+	if err != nil {
+		return err
+	}
+	iterations, err := hashParamFromEnv("ARGON2_ITERATIONS", defaultArgon2Iterations, 1, maxArgon2Iterations)
+	if err != nil {
+		return err
+	}
+	cost, err := hashParamFromEnv("BCRYPT_COST", bcrypt.DefaultCost, bcrypt.MinCost, bcrypt.MaxCost)
+	if err != nil {
+		return err
+	}
+
+	argon := &Argon2idHasher{Memory: uint32(memory), Iterations: uint32(iterations), Parallelism: uint8(parallelism)}
+	bcryptHasher := &BcryptHasher{Cost: cost}
+
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", "argon2id":
+		passwordHasher = argon
+	case "bcrypt":
+		passwordHasher = bcryptHasher
+	default:
+		return fmt.Errorf("unknown PASSWORD_HASHER %q, expected argon2id or bcrypt", os.Getenv("PASSWORD_HASHER"))
+	}
+	passwordVerifiers = []PasswordHasher{argon, bcryptHasher}
+	return nil
+}
+
+// hashParamFromEnv reads a hashing parameter, the default applies when it is not set
+func hashParamFromEnv(key string, fallback, min, max int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("%s must be a number from %d to %d", key, min, max)
+	}
+	return value, nil
+}
+
+// Argon2idHasher encodes hashes in the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return *params != *h || len(key) != argon2KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2id version")
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil ||
+		params.Parallelism == 0 || params.Iterations == 0 || params.Iterations > maxArgon2Iterations || params.Memory > maxArgon2Memory {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher is kept to verify the hashes created before argon2id became the default.
+// bcrypt only uses the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
 	return string(hashedPassword), nil
 }
 
-// CheckPassword compares the hashed password with the plaintext password
-func CheckPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
 }
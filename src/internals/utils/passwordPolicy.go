@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Default password policy, PASSWORD_* env variables override it
+const (
+	defaultPasswordMinLength  = 8
+	defaultPasswordMaxLength  = 128
+	defaultPasswordMinClasses = 1
+)
+
+// Length of the hash prefix naming the files of the breached password list
+const breachPrefixLength = 5
+
+// PasswordViolation is one reason a password was refused, the code is meant for clients
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password breaks
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Number of character classes (lowercase, uppercase, digits, symbols) the password must mix
+	MinClasses int
+	// Refuse passwords containing the username or the local part of the email
+	CheckSimilarity bool
+	// Directory of the breached password list, empty disables the check
+	BreachListDir string
+	// Number of breaches from which a password is refused
+	BreachMinCount int
+}
+
+var (
+	policyOnce     sync.Once
+	passwordPolicy *PasswordPolicy
+)
+
+// CurrentPasswordPolicy returns the policy configured through the environment
+func CurrentPasswordPolicy() *PasswordPolicy {
+	policyOnce.Do(func() {
+		passwordPolicy = &PasswordPolicy{
+			MinLength:       IntFromEnv("PASSWORD_MIN_LENGTH", defaultPasswordMinLength),
+			MaxLength:       IntFromEnv("PASSWORD_MAX_LENGTH", defaultPasswordMaxLength),
+			MinClasses:      IntFromEnv("PASSWORD_MIN_CLASSES", defaultPasswordMinClasses),
+			CheckSimilarity: os.Getenv("PASSWORD_CHECK_SIMILARITY") != "false",
+			BreachListDir:   os.Getenv("PASSWORD_BREACH_LIST_DIR"),
+			BreachMinCount:  IntFromEnv("PASSWORD_BREACH_MIN_COUNT", 1),
+		}
+	})
+	return passwordPolicy
+}
+
+// ValidatePassword checks the password against the current policy, the username and email
+// of the account are used for the similarity check
+func ValidatePassword(password, username, email string) error {
+	return CurrentPasswordPolicy().Validate(password, username, email)
+}
+
+// Validate returns a *PasswordPolicyError listing every broken rule, or another error
+// when the breached password list cannot be read
+func (p *PasswordPolicy) Validate(password, username, email string) error {
+	var violations []PasswordViolation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{"too_short", fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+	}
+	if length > p.MaxLength {
+		violations = append(violations, PasswordViolation{"too_long", fmt.Sprintf("Password must be at most %d characters long", p.MaxLength)})
+	}
+
+	if classes := characterClasses(password); classes < p.MinClasses {
+		violations = append(violations, PasswordViolation{"too_few_character_classes", fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)})
+	}
+
+	if p.CheckSimilarity {
+		lowered := strings.ToLower(password)
+		if similar(lowered, strings.ToLower(username)) {
+			violations = append(violations, PasswordViolation{"similar_to_username", "Password must not contain the username"})
+		}
+		local := strings.SplitN(strings.ToLower(email), "@", 2)[0]
+		if similar(lowered, local) {
+			violations = append(violations, PasswordViolation{"similar_to_email", "Password must not contain the email address"})
+		}
+	}
+
+	if p.BreachListDir != "" {
+		count, err := breachCount(p.BreachListDir, password)
+		if err != nil {
+			return err
+		}
+		if count >= p.BreachMinCount {
+			violations = append(violations, PasswordViolation{"breached", "Password appeared in a data breach, choose another one"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// similar tells whether the password contains the value or the value reversed,
+// values shorter than 3 characters are ignored
+func similar(password, value string) bool {
+	if utf8.RuneCountInString(value) < 3 {
+		return false
+	}
+
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return strings.Contains(password, value) || strings.Contains(password, string(runes))
+}
+
+// breachCount looks the password up in a list laid out like the k-anonymity range API of
+// Have I Been Pwned: the SHA-1 of the password is uppercased, its first 5 characters name
+// the file (e.g. "5BAA6.txt") and every line of the file is "SUFFIX:COUNT".
+// Only the file of the prefix is read, a missing file means no breach.
+func breachCount(dir, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, count, found := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		if !found {
+			return 1, nil
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 1, nil
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// violationCodes returns the codes of a policy error, nil when the password is accepted
+func violationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("got %v, want a *PasswordPolicyError", err)
+	}
+	codes := make([]string, 0, len(policyErr.Violations))
+	for _, violation := range policyErr.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyListsEveryViolation(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 10, MaxLength: 20, MinClasses: 3, CheckSimilarity: true}
+
+	for password, want := range map[string][]string{
+		"Kj8#mQ2v!xLp":          nil,
+		"short":                 {"too_short", "too_few_character_classes"},
+		"Kj8#mQ2v!xLpKj8#mQ2v!": {"too_long"},
+		"alllowercaseletters":   {"too_few_character_classes"},
+		// Longer than 10 bytes but not than 10 characters
+		"Ünïcödé1!": {"too_short"},
+		// The username and the local part of the email, also reversed and in another case
+		"Xy1!FrankHill01": {"similar_to_username"},
+		"Xy1!10llihknarf": {"similar_to_username"},
+		"Xy1!F.Hill-Mail": {"similar_to_email"},
+	} {
+		got := violationCodes(t, policy.Validate(password, "frankhill01", "f.hill@example.com"))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q broke %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestPasswordPolicyIgnoresShortIdentifiers(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 1, MaxLength: 128, MinClasses: 1, CheckSimilarity: true}
+	// Identifiers under 3 characters would refuse too many passwords
+	if err := policy.Validate("jo-password", "jo", "jo@example.com"); err != nil {
+		t.Errorf("a password containing a 2 characters username was refused: %v", err)
+	}
+
+	policy.CheckSimilarity = false
+	if err := policy.Validate("frankhill01", "frankhill01", "frank@example.com"); err != nil {
+		t.Errorf("the similarity check ran while disabled: %v", err)
+	}
+}
+
+// writeBreachList lays a breached password list out like the k-anonymity range API, one file per hash prefix
+func writeBreachList(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBreachCountReadsTheFileOfTheHashPrefix(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8,
+	// of "Tr0ub4dor&3" 874572E7A5AE6A49466A6AC578B98ADBA78C6AA6
+	dir := writeBreachList(t, map[string]string{
+		// Suffixes in any case, Windows line endings
+		"5BAA6.txt": "003D68EB55068C33ACE09247EE4C639306B:3\r\n1e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824\r\n",
+		// Files may also be named by the prefix alone, lines without a count are one breach
+		"87457": "2E7A5AE6A49466A6AC578B98ADBA78C6AA6\n",
+		// The suffix of "password" in the file of another prefix is not a match
+		"ABF7A.txt": "1E4C9B93F3F0682250B6CF8331B7EE68FD8:5\n",
+	})
+
+	for password, want := range map[string]int{
+		"password":    9545824,
+		"Tr0ub4dor&3": 1,
+		// ABF7AAD6..., its file does not hold its suffix
+		"correct horse battery staple": 0,
+		// No file for its prefix
+		"Kj8#mQ2v!xLp": 0,
+	} {
+		got, err := breachCount(dir, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%q found in %d breaches, want %d", password, got, want)
+		}
+	}
+}
+
+func TestPasswordPolicyRefusesBreachedPasswords(t *testing.T) {
+	dir := writeBreachList(t, map[string]string{
+		"5BAA6.txt": "1E4C9B93F3F0682250B6CF8331B7EE68FD8:3\n",
+	})
+	policy := &PasswordPolicy{MinLength: 1, MaxLength: 128, MinClasses: 1, BreachListDir: dir, BreachMinCount: 1}
+
+	if got := violationCodes(t, policy.Validate("password", "frankhill01", "frank@example.com")); !reflect.DeepEqual(got, []string{"breached"}) {
+		t.Errorf("a breached password broke %v, want breached", got)
+	}
+	if err := policy.Validate("Kj8#mQ2v!xLp", "frankhill01", "frank@example.com"); err != nil {
+		t.Errorf("a password missing from the list was refused: %v", err)
+	}
+
+	// Passwords found in fewer breaches than the threshold are accepted
+	policy.BreachMinCount = 4
+	if err := policy.Validate("password", "frankhill01", "frank@example.com"); err != nil {
+		t.Errorf("a password under the breach threshold was refused: %v", err)
+	}
+
+	// A list that cannot be read fails the check instead of accepting every password
+	policy.BreachListDir = filepath.Join(dir, "5BAA6.txt")
+	if err := policy.Validate("password", "frankhill01", "frank@example.com"); err == nil || errors.As(err, new(*PasswordPolicyError)) {
+		t.Errorf("an unreadable list returned %v, want a read error", err)
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep the tests fast, they are checked like the configured ones
+var testArgon2 = &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestArgon2idHashRoundTrip(t *testing.T) {
+	encoded, err := testArgon2.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PHC string format, a 16 bytes salt and a 32 bytes key in unpadded base64
+	phc := regexp.MustCompile(`^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`)
+	if !phc.MatchString(encoded) {
+		t.Fatalf("hash %q is not in the PHC string format", encoded)
+	}
+	if !testArgon2.Supports(encoded) || (&BcryptHasher{}).Supports(encoded) {
+		t.Error("the hash is not recognized as argon2id only")
+	}
+
+	if err := testArgon2.Verify(encoded, "correct horse battery staple"); err != nil {
+		t.Errorf("the password does not verify: %v", err)
+	}
+	if err := testArgon2.Verify(encoded, "correct horse battery stapler"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("another password returned %v, want ErrPasswordMismatch", err)
+	}
+
+	// Every hash gets its own salt
+	again, err := testArgon2.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encoded {
+		t.Error("hashing the password twice gave the same hash")
+	}
+}
+
+func TestArgon2idVerifiesHashesOfOtherParameters(t *testing.T) {
+	encoded, err := testArgon2.Hash("s3cret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The parameters are read from the hash, a hasher configured otherwise still verifies it
+	configured := &Argon2idHasher{Memory: 2048, Iterations: 2, Parallelism: 2}
+	if err := configured.Verify(encoded, "s3cret-password"); err != nil {
+		t.Errorf("a hash of other parameters does not verify: %v", err)
+	}
+	if !configured.NeedsRehash(encoded) {
+		t.Error("a hash of other parameters does not need a rehash")
+	}
+	if testArgon2.NeedsRehash(encoded) {
+		t.Error("a hash of the current parameters needs a rehash")
+	}
+}
+
+func TestArgon2idRefusesMalformedHashes(t *testing.T) {
+	const salt, key = "c29tZXNhbHRzb21lc2FsdA", "CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	for name, encoded := range map[string]string{
+		"missing parts":     "$argon2id$v=19$m=1024,t=1,p=1$" + salt,
+		"other algorithm":   "$argon2i$v=19$m=1024,t=1,p=1$" + salt + "$" + key,
+		"old version":       "$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key,
+		"no parallelism":    "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key,
+		"no iterations":     "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key,
+		"too many passes":   "$argon2id$v=19$m=1024,t=101,p=1$" + salt + "$" + key,
+		"too much memory":   "$argon2id$v=19$m=4194305,t=1,p=1$" + salt + "$" + key,
+		"parallelism > 255": "$argon2id$v=19$m=1024,t=1,p=256$" + salt + "$" + key,
+		"garbled params":    "$argon2id$v=19$t=1,m=1024,p=1$" + salt + "$" + key,
+		"invalid salt":      "$argon2id$v=19$m=1024,t=1,p=1$not*base64$" + key,
+		"empty key":         "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$",
+	} {
+		err := testArgon2.Verify(encoded, "password")
+		if err == nil || errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("%s: verifying %q returned %v, want an invalid hash error", name, encoded, err)
+		}
+		if !testArgon2.NeedsRehash(encoded) {
+			t.Errorf("%s: a malformed hash does not need a rehash", name)
+		}
+	}
+}
+
+func TestBcryptHashesAreVerifiedAndUpgraded(t *testing.T) {
+	legacy, err := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("legacy-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashes made before argon2id became the default still log in, then get upgraded
+	if err := CheckPassword(legacy, "legacy-password"); err != nil {
+		t.Errorf("the bcrypt hash does not verify: %v", err)
+	}
+	if err := CheckPassword(legacy, "other-password"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("another password returned %v, want ErrPasswordMismatch", err)
+	}
+	if !PasswordNeedsRehash(legacy) {
+		t.Error("a bcrypt hash does not need a rehash under argon2id")
+	}
+
+	upgraded, err := HashPassword("legacy-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if PasswordNeedsRehash(upgraded) {
+		t.Errorf("the upgraded hash %q needs a rehash", upgraded)
+	}
+	if err := CheckPassword(upgraded, "legacy-password"); err != nil {
+		t.Errorf("the upgraded hash does not verify: %v", err)
+	}
+
+	if err := CheckPassword("plaintext", "plaintext"); err == nil || err.Error() != "unsupported password hash" {
+		t.Errorf("an unknown hash returned %v", err)
+	}
+}
+
+func TestLoadPasswordHasherValidatesParameters(t *testing.T) {
+	// Loading replaces the hashers of the package, they are put back for the other tests
+	hasher, verifiers := passwordHasher, passwordVerifiers
+	t.Cleanup(func() { passwordHasher, passwordVerifiers = hasher, verifiers })
+
+	for name, env := range map[string]map[string]string{
+		"no parallelism":          {"ARGON2_PARALLELISM": "0"},
+		"parallelism > 255":       {"ARGON2_PARALLELISM": "256"},
+		"memory below 8 KiB/lane": {"ARGON2_PARALLELISM": "4", "ARGON2_MEMORY": "31"},
+		"too much memory":         {"ARGON2_MEMORY": "4194305"},
+		"no iterations":           {"ARGON2_ITERATIONS": "0"},
+		"too many iterations":     {"ARGON2_ITERATIONS": "101"},
+		"not a number":            {"ARGON2_MEMORY": "64MiB"},
+		"bcrypt cost too low":     {"BCRYPT_COST": "3"},
+		"bcrypt cost too high":    {"BCRYPT_COST": "32"},
+		"unknown hasher":          {"PASSWORD_HASHER": "md5"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			if err := loadPasswordHasher(); err == nil {
+				t.Errorf("loading %v succeeded", env)
+			}
+		})
+	}
+
+	t.Run("bcrypt", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASHER", "bcrypt")
+		t.Setenv("BCRYPT_COST", "4")
+		if err := loadPasswordHasher(); err != nil {
+			t.Fatal(err)
+		}
+		if h, ok := passwordHasher.(*BcryptHasher); !ok || h.Cost != 4 {
+			t.Errorf("loaded hasher %#v, want bcrypt of cost 4", passwordHasher)
+		}
+	})
+
+	t.Run("argon2id", func(t *testing.T) {
+		t.Setenv("ARGON2_MEMORY", "32")
+		t.Setenv("ARGON2_ITERATIONS", "1")
+		t.Setenv("ARGON2_PARALLELISM", "4")
+		if err := loadPasswordHasher(); err != nil {
+			t.Fatal(err)
+		}
+		want := Argon2idHasher{Memory: 32, Iterations: 1, Parallelism: 4}
+		if h, ok := passwordHasher.(*Argon2idHasher); !ok || *h != want {
+			t.Errorf("loaded hasher %#v, want %+v", passwordHasher, want)
+		}
+	})
+}
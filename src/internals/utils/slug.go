@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Longest slug made from a title, before any suffix
+const maxSlugLength = 60
+
+// Slugify turns a title into a lowercase URL segment made of Latin letters, digits and dashes,
+// e.g. "Hello, Wörld!" becomes "hello-world". It may return an empty string.
+func Slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	// Decomposing the accented letters keeps their base letter
+	for _, r := range norm.NFKD.String(strings.ToLower(title)) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Accents left by the decomposition
+		default:
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
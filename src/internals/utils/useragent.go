@@ -0,0 +1,29 @@
+package utils
+
+import "strings"
+
+// Known platforms checked in order, the first one found in the user agent wins
+var devicePlatforms = []struct {
+	marker string
+	name   string
+}{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Macintosh", "Mac"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+	{"curl", "curl"},
+	{"PostmanRuntime", "Postman"},
+}
+
+// DeviceFromUserAgent returns a short human readable device name for a User-Agent header
+func DeviceFromUserAgent(userAgent string) string {
+	for _, platform := range devicePlatforms {
+		if strings.Contains(userAgent, platform.marker) {
+			return platform.name
+		}
+	}
+	return "Unknown device"
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
-var validate *validator.Validate
+// The validator caches the structs it has seen, it is built once and shared by all requests
+var validate = newValidator()
 
 // Custom (User) error messages
 var customMessages = map[string]string{
@@ -19,11 +20,38 @@ var customMessages = map[string]string{
 	"Email.required":                "Email is required",
 	"Email.email":                   "Email must be a valid email address",
 	"Password.required":             "Password is required",
-	"Password.min":                  "Password must be at least 8 characters long",
-	"Password.max":                  "Password must be at most 32 characters long",
+	"Password.max":                  "Password is too long",
 	"PasswordConfirmation.required": "Password confirmation is required",
 	"PasswordConfirmation.eqfield":  "Passwords do not match",
-	"Role.min":                      "Role must be at least 5 characters long",
+	"RefreshToken.required":         "Refresh token is required",
+	"Code.required_without":         "Code or recovery code is required",
+	"Code.len":                      "Code must be 6 digits long",
+	"Code.numeric":                  "Code must be 6 digits long",
+	"RecoveryCode.required_without": "Code or recovery code is required",
+	"ChallengeToken.required":       "Challenge token is required",
+	"Token.required":                "Token is required",
+	"Name.required":                 "Name is required",
+	"Name.min":                      "Name must be at least 3 characters long",
+	"Name.max":                      "Name must be at most 64 characters long",
+	"Scopes.required":               "At least one scope is required",
+	"Scopes.min":                    "At least one scope is required",
+	"Roles.required":                "Roles are required",
+	"Action.required":               "Action is required",
+	"Action.oneof":                  "Action must be one of delete, restore, set_roles, add_roles, remove_roles",
+	"Title.required":                "Title is required",
+	"Title.min":                     "Title is required",
+	"Title.max":                     "Title must be at most 200 characters long",
+	"Content.required":              "Content is required",
+	"Content.min":                   "Content is required",
+	"Content.max":                   "Content is too long",
+	"Status.oneof":                  "Status must be one of draft, published, archived",
+	"Body.required":                 "Body is required",
+	"Body.max":                      "Body must be at most 5000 characters long",
+	"ParentID.uuid":                 "Parent ID must be a valid comment ID",
+	"Type.required":                 "Type is required",
+	"Tags.max":                      "A post has at most 10 tags",
+	"Into.required":                 "The slug of the tag to merge into is required",
+	"Description.max":               "Description must be at most 500 characters long",
 }
 
 // Custom validation function for username field
@@ -40,12 +68,15 @@ func usernameValidator(fl validator.FieldLevel) bool {
 	return true
 }
 
-func ValidateUser(data interface{}) error {
-	validate = validator.New()
+func newValidator() *validator.Validate {
+	v := validator.New()
 
 	// Registering the custom validation function
-	validate.RegisterValidation("username", usernameValidator)
+	v.RegisterValidation("username", usernameValidator)
+	return v
+}
 
+func ValidateUser(data interface{}) error {
 	err := validate.Struct(data)
 	if err != nil {
 		for _, err := range err.(validator.ValidationErrors) {